@@ -0,0 +1,101 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics/backup"
+)
+
+// interruptible returns a context cancelled by an interrupt, so that the progress is saved before exiting
+func interruptible() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		cancel()
+	}
+}
+
+// backupExport exports the tenant to a directory, or continues an interrupted export
+func backupExport(e *env, args []string) error {
+	fs := flags("backup export")
+	start := fs.String("start", "", "Start time, defaults to the earliest datapoint")
+	end := fs.String("end", "", "End time, defaults to now")
+	chunk := fs.Int("chunk", 0, "Datapoints per chunk file, defaults to 10000")
+	args, err := arguments(fs, args, 1, "<dir>")
+	if err != nil {
+		return err
+	}
+
+	ex := &backup.Exporter{Client: e.client, Dir: args[0], ChunkSize: *chunk}
+	now := time.Now()
+	if *start != "" {
+		if ex.Start, err = parseTime(*start, now); err != nil {
+			return err
+		}
+	}
+	if *end != "" {
+		if ex.End, err = parseTime(*end, now); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	m, err := ex.Export(ctx)
+	if err != nil {
+		return err
+	}
+	return e.out.print(m, []string{"TENANT", "DEFINITIONS", "DATAPOINTS", "CHUNKS"},
+		[][]string{{m.Tenant, strconv.Itoa(m.Definitions), strconv.Itoa(m.Datapoints), strconv.Itoa(len(m.Chunks))}})
+}
+
+// backupImport imports an export directory, or continues an interrupted import
+func backupImport(e *env, args []string) error {
+	fs := flags("backup import")
+	target := fs.String("target", "", "Target tenant, defaults to the exported tenant")
+	batch := fs.Int("batch", 0, "Datapoints per write request, defaults to 1000")
+	checkpoint := fs.String("checkpoint", "", "Progress file, defaults to a file of the target tenant in the directory")
+	args, err := arguments(fs, args, 1, "<dir>")
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	cp, err := (&backup.Importer{Client: e.client, Dir: args[0], Tenant: *target, Checkpoint: *checkpoint, BatchSize: *batch}).Import(ctx)
+	if err != nil {
+		return err
+	}
+	return e.out.print(cp, []string{"TENANT", "CHUNKS", "DATAPOINTS"},
+		[][]string{{cp.Tenant, strconv.Itoa(cp.Chunks), strconv.Itoa(cp.Datapoints)}})
+}
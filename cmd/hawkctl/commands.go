@@ -0,0 +1,524 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+	"github.com/hawkular/hawkular-client-go/metrics/csvio"
+)
+
+// flags returns a FlagSet which reports the errors to the caller
+func flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return fs
+}
+
+func arguments(fs *flag.FlagSet, args []string, n int, names string) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() < n {
+		return nil, fmt.Errorf("%s requires arguments %s", fs.Name(), names)
+	}
+	return fs.Args(), nil
+}
+
+func parseType(s string) (metrics.MetricType, error) {
+	return csvio.ParseType(s)
+}
+
+// parseTags parses k=v pairs separated by commas
+func parseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	if s == "" {
+		return tags, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		if err := addTag(tags, pair); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+func addTag(tags map[string]string, pair string) error {
+	kv := strings.SplitN(pair, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("Tag %s is not in k=v format", pair)
+	}
+	tags[kv[0]] = kv[1]
+	return nil
+}
+
+// parseTime accepts RFC 3339 timestamps, milliseconds since epoch and durations relative to now
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return metrics.FromUnixMilli(ms), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Time{}, fmt.Errorf("Could not parse time %s", s)
+}
+
+// timeRange adds the -start and -end flags
+type timeRange struct {
+	start string
+	end   string
+}
+
+func (tr *timeRange) register(fs *flag.FlagSet) {
+	fs.StringVar(&tr.start, "start", "", "Start time, defaults to the server default (8 hours ago)")
+	fs.StringVar(&tr.end, "end", "", "End time, defaults to now")
+}
+
+func (tr *timeRange) filters() ([]metrics.Filter, error) {
+	now := time.Now()
+	f := make([]metrics.Filter, 0, 2)
+	if tr.start != "" {
+		t, err := parseTime(tr.start, now)
+		if err != nil {
+			return nil, err
+		}
+		f = append(f, metrics.StartTimeFilter(t))
+	}
+	if tr.end != "" {
+		t, err := parseTime(tr.end, now)
+		if err != nil {
+			return nil, err
+		}
+		f = append(f, metrics.EndTimeFilter(t))
+	}
+	return f, nil
+}
+
+func tenantsList(e *env, args []string) error {
+	if _, err := arguments(flags("tenants list"), args, 0, ""); err != nil {
+		return err
+	}
+	tds, err := e.client.Tenants()
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(tds))
+	for _, td := range tds {
+		retentions := make([]string, 0, len(td.Retentions))
+		for t, days := range td.Retentions {
+			retentions = append(retentions, fmt.Sprintf("%s=%d", t, days))
+		}
+		sort.Strings(retentions)
+		rows = append(rows, []string{td.ID, strings.Join(retentions, ",")})
+	}
+	return e.out.print(tds, []string{"ID", "RETENTIONS"}, rows)
+}
+
+func tenantsCreate(e *env, args []string) error {
+	args, err := arguments(flags("tenants create"), args, 1, "<id>")
+	if err != nil {
+		return err
+	}
+	created, err := e.client.CreateTenant(metrics.TenantDefinition{ID: args[0]})
+	if err != nil {
+		return err
+	}
+	return printCreated(e, args[0], created)
+}
+
+func printCreated(e *env, id string, created bool) error {
+	result := map[string]interface{}{"id": id, "created": created}
+	return e.out.print(result, []string{"ID", "CREATED"}, [][]string{{id, strconv.FormatBool(created)}})
+}
+
+func printDefinitions(e *env, mds []*metrics.MetricDefinition) error {
+	rows := make([][]string, 0, len(mds))
+	for _, md := range mds {
+		retention := ""
+		if md.RetentionTime > 0 {
+			retention = strconv.Itoa(md.RetentionTime)
+		}
+		rows = append(rows, []string{string(md.Type), md.ID, formatTags(md.Tags), retention})
+	}
+	return e.out.print(mds, []string{"TYPE", "ID", "TAGS", "RETENTION"}, rows)
+}
+
+func definitionsList(e *env, args []string) error {
+	fs := flags("definitions list")
+	typ := fs.String("type", "", "Metric type")
+	tagQuery := fs.String("tags", "", "Tag query, k=v pairs separated by commas")
+	if _, err := arguments(fs, args, 0, ""); err != nil {
+		return err
+	}
+
+	f := make([]metrics.Filter, 0, 2)
+	if *typ != "" {
+		t, err := parseType(*typ)
+		if err != nil {
+			return err
+		}
+		f = append(f, metrics.TypeFilter(t))
+	}
+	if *tagQuery != "" {
+		tags, err := parseTags(*tagQuery)
+		if err != nil {
+			return err
+		}
+		f = append(f, metrics.TagsFilter(tags))
+	}
+
+	mds, err := e.client.Definitions(metrics.Filters(f...))
+	if err != nil {
+		return err
+	}
+	return printDefinitions(e, mds)
+}
+
+func definitionsGet(e *env, args []string) error {
+	args, err := arguments(flags("definitions get"), args, 2, "<type> <id>")
+	if err != nil {
+		return err
+	}
+	t, err := parseType(args[0])
+	if err != nil {
+		return err
+	}
+	md, err := e.client.Definition(t, args[1])
+	if err != nil {
+		return err
+	}
+	if md == nil {
+		return fmt.Errorf("Metric %s %s not found", t, args[1])
+	}
+	return printDefinitions(e, []*metrics.MetricDefinition{md})
+}
+
+func definitionsCreate(e *env, args []string) error {
+	fs := flags("definitions create")
+	tagList := fs.String("tags", "", "Tags, k=v pairs separated by commas")
+	retention := fs.Int("retention", 0, "Data retention in days")
+	args, err := arguments(fs, args, 2, "<type> <id>")
+	if err != nil {
+		return err
+	}
+	t, err := parseType(args[0])
+	if err != nil {
+		return err
+	}
+	tags, err := parseTags(*tagList)
+	if err != nil {
+		return err
+	}
+
+	created, err := e.client.Create(metrics.MetricDefinition{Type: t, ID: args[1], Tags: tags, RetentionTime: *retention})
+	if err != nil {
+		return err
+	}
+	return printCreated(e, args[1], created)
+}
+
+func definitionsDelete(e *env, args []string) error {
+	args, err := arguments(flags("definitions delete"), args, 2, "<type> <id>")
+	if err != nil {
+		return err
+	}
+	t, err := parseType(args[0])
+	if err != nil {
+		return err
+	}
+	return e.client.Delete(t, args[1])
+}
+
+func printTags(e *env, tags map[string]string) error {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rows := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		rows = append(rows, []string{k, tags[k]})
+	}
+	return e.out.print(tags, []string{"NAME", "VALUE"}, rows)
+}
+
+func tagsGet(e *env, args []string) error {
+	args, err := arguments(flags("tags get"), args, 2, "<type> <id>")
+	if err != nil {
+		return err
+	}
+	t, err := parseType(args[0])
+	if err != nil {
+		return err
+	}
+	tags, err := e.client.Tags(t, args[1])
+	if err != nil {
+		return err
+	}
+	return printTags(e, tags)
+}
+
+func tagsUpdate(e *env, args []string) error {
+	args, err := arguments(flags("tags update"), args, 3, "<type> <id> <k=v>...")
+	if err != nil {
+		return err
+	}
+	t, err := parseType(args[0])
+	if err != nil {
+		return err
+	}
+	tags := make(map[string]string)
+	for _, pair := range args[2:] {
+		if err := addTag(tags, pair); err != nil {
+			return err
+		}
+	}
+	return e.client.UpdateTags(t, args[1], tags)
+}
+
+func tagsDelete(e *env, args []string) error {
+	args, err := arguments(flags("tags delete"), args, 3, "<type> <id> <name>...")
+	if err != nil {
+		return err
+	}
+	t, err := parseType(args[0])
+	if err != nil {
+		return err
+	}
+	return e.client.DeleteTags(t, args[1], args[2:])
+}
+
+// write reads either an array of metrics of the -type or the Hawkular-Metrics mixed format
+// {"gauges": [...], "counters": [...], ...} from stdin
+func write(e *env, args []string) error {
+	fs := flags("write")
+	typ := fs.String("type", "", "Metric type of the written array, without it the input is in the mixed format")
+	if _, err := arguments(fs, args, 0, ""); err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadAll(e.stdin)
+	if err != nil {
+		return err
+	}
+
+	mhs, err := parseWrite(b, *typ)
+	if err != nil {
+		return err
+	}
+	if len(mhs) == 0 {
+		return fmt.Errorf("No metrics in the input")
+	}
+	return e.client.Write(mhs)
+}
+
+func parseWrite(b []byte, typ string) ([]metrics.MetricHeader, error) {
+	if typ != "" {
+		t, err := parseType(typ)
+		if err != nil {
+			return nil, err
+		}
+		mhs := []metrics.MetricHeader{}
+		if err := json.Unmarshal(b, &mhs); err != nil {
+			return nil, err
+		}
+		for i := range mhs {
+			mhs[i].Type = t
+		}
+		return mhs, nil
+	}
+
+	mixed := make(map[string][]metrics.MetricHeader)
+	if err := json.Unmarshal(b, &mixed); err != nil {
+		return nil, err
+	}
+	mhs := make([]metrics.MetricHeader, 0)
+	for k, v := range mixed {
+		t, err := parseType(k)
+		if err != nil {
+			return nil, err
+		}
+		for _, mh := range v {
+			mh.Type = t
+			mhs = append(mhs, mh)
+		}
+	}
+	return mhs, nil
+}
+
+func readRaw(e *env, args []string) error {
+	fs := flags("read raw")
+	tr := &timeRange{}
+	tr.register(fs)
+	limit := fs.Int("limit", 0, "Maximum amount of datapoints")
+	order := fs.String("order", "", "Order of the datapoints, ASC or DESC")
+	args, err := arguments(fs, args, 2, "<type> <id>")
+	if err != nil {
+		return err
+	}
+	t, err := parseType(args[0])
+	if err != nil {
+		return err
+	}
+
+	f, err := tr.filters()
+	if err != nil {
+		return err
+	}
+	if *limit > 0 {
+		f = append(f, metrics.LimitFilter(*limit))
+	}
+	switch strings.ToUpper(*order) {
+	case "":
+	case "ASC":
+		f = append(f, metrics.OrderFilter(metrics.ASC))
+	case "DESC":
+		f = append(f, metrics.OrderFilter(metrics.DESC))
+	default:
+		return fmt.Errorf("Unknown order %s", *order)
+	}
+
+	dps, err := e.client.ReadRaw(t, args[1], metrics.Filters(f...))
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(dps))
+	for _, dp := range dps {
+		rows = append(rows, []string{formatTime(dp.Timestamp), fmt.Sprint(dp.Value), formatTags(dp.Tags)})
+	}
+	return e.out.print(dps, []string{"TIMESTAMP", "VALUE", "TAGS"}, rows)
+}
+
+func readBuckets(e *env, args []string) error {
+	fs := flags("read buckets")
+	tr := &timeRange{}
+	tr.register(fs)
+	buckets := fs.Int("buckets", 0, "Amount of buckets")
+	duration := fs.Duration("duration", 0, "Duration of a bucket")
+	tagQuery := fs.String("tags", "", "Tag query, k=v pairs separated by commas")
+	percentiles := fs.String("percentiles", "", "Percentiles separated by commas, such as 90,99")
+	args, err := arguments(fs, args, 1, "<type> [id...]")
+	if err != nil {
+		return err
+	}
+	t, err := parseType(args[0])
+	if err != nil {
+		return err
+	}
+
+	f, err := tr.filters()
+	if err != nil {
+		return err
+	}
+	switch {
+	case *buckets > 0 && *duration > 0:
+		return fmt.Errorf("Specify either -buckets or -duration")
+	case *duration > 0:
+		f = append(f, metrics.BucketsDurationFilter(*duration))
+	case *buckets > 0:
+		f = append(f, metrics.BucketsFilter(*buckets))
+	default:
+		f = append(f, metrics.BucketsFilter(1))
+	}
+	if len(args) > 1 {
+		f = append(f, metrics.MetricsFilter(args[1:]))
+	}
+	if *tagQuery != "" {
+		tags, err := parseTags(*tagQuery)
+		if err != nil {
+			return err
+		}
+		f = append(f, metrics.TagsFilter(tags))
+	}
+	if *percentiles != "" {
+		ps := make([]float64, 0)
+		for _, s := range strings.Split(*percentiles, ",") {
+			p, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return fmt.Errorf("Percentile %s is not a number", s)
+			}
+			ps = append(ps, p)
+		}
+		f = append(f, metrics.PercentilesFilter(ps))
+	}
+
+	bps, err := e.client.ReadBuckets(t, metrics.Filters(f...))
+	if err != nil {
+		return err
+	}
+
+	headers := []string{"START", "END", "SAMPLES", "MIN", "AVG", "MEDIAN", "MAX", "EMPTY"}
+	rows := make([][]string, 0, len(bps))
+	for _, bp := range bps {
+		row := []string{formatTime(bp.Start), formatTime(bp.End), strconv.FormatUint(bp.Samples, 10),
+			formatFloat(bp.Min), formatFloat(bp.Avg), formatFloat(bp.Median), formatFloat(bp.Max), strconv.FormatBool(bp.Empty)}
+		ps := make([]string, 0, len(bp.Percentiles))
+		for _, p := range bp.Percentiles {
+			ps = append(ps, fmt.Sprintf("%g=%g", p.Quantile, p.Value))
+		}
+		rows = append(rows, append(row, strings.Join(ps, ",")))
+	}
+	return e.out.print(bps, append(headers, "PERCENTILES"), rows)
+}
+
+func status(e *env, args []string) error {
+	if _, err := arguments(flags("status"), args, 0, ""); err != nil {
+		return err
+	}
+	s, err := e.client.Status()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rows := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		v := s[k]
+		if _, ok := v.(string); !ok {
+			b, _ := json.Marshal(v)
+			v = string(b)
+		}
+		rows = append(rows, []string{k, fmt.Sprint(v)})
+	}
+	for _, ep := range e.client.Endpoints() {
+		health := "healthy"
+		if !ep.Healthy {
+			health = fmt.Sprintf("unhealthy: %v", ep.LastError)
+		}
+		rows = append(rows, []string{"Endpoint " + ep.URL, health})
+	}
+	return e.out.print(s, []string{"NAME", "VALUE"}, rows)
+}
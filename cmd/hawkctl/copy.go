@@ -0,0 +1,169 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+	"github.com/hawkular/hawkular-client-go/metrics/migrate"
+)
+
+// repeated is a flag that can be given multiple times
+type repeated []string
+
+func (r *repeated) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *repeated) Set(s string) error {
+	*r = append(*r, s)
+	return nil
+}
+
+// copyTenant copies the metrics of the tenant to the target given with the -target-* flags,
+// which default to the global connection settings
+func copyTenant(e *env, args []string) error {
+	fs := flags("copy")
+	targetURL := fs.String("target-url", "", "Target Hawkular-Metrics URL")
+	targetTenant := fs.String("target-tenant", "", "Target tenant")
+	targetToken := fs.String("target-token", "", "Bearer token of the target")
+	typ := fs.String("type", "", "Copy only the metrics of this type")
+	tagQuery := fs.String("tags", "", "Copy only the metrics matching the tag query, k=v pairs separated by commas")
+	start := fs.String("start", "", "Start time, defaults to the earliest datapoint")
+	end := fs.String("end", "", "End time, defaults to now")
+	parallel := fs.Int("parallel", 4, "Metrics copied concurrently")
+	verify := fs.Bool("verify", false, "Compare the datapoint counts after the copy")
+	removeTags := fs.Bool("remove-tags", false, "Delete the dropped and renamed tags from the existing target metrics")
+	var renames, renameTags, dropTags, setTags repeated
+	fs.Var(&renames, "rename", "Rewrite the ids matching a regular expression, pattern=replacement with \\= for the equal signs of the pattern (repeatable)")
+	fs.Var(&renameTags, "rename-tag", "Rename a tag, old=new (repeatable)")
+	fs.Var(&dropTags, "drop-tag", "Remove a tag (repeatable)")
+	fs.Var(&setTags, "set-tag", "Add a tag, k=v (repeatable)")
+	if _, err := arguments(fs, args, 0, ""); err != nil {
+		return err
+	}
+
+	o := migrate.Options{TargetTenant: *targetTenant, Parallelism: *parallel, Verify: *verify, RemoveTags: *removeTags}
+	if o.TargetTenant == "" {
+		o.TargetTenant = e.client.Tenant
+	}
+	if *targetURL == "" && o.TargetTenant == e.client.Tenant {
+		return fmt.Errorf("copy requires -target-url or -target-tenant")
+	}
+
+	if *typ != "" {
+		t, err := parseType(*typ)
+		if err != nil {
+			return err
+		}
+		o.Filters = append(o.Filters, metrics.TypeFilter(t))
+	}
+	if *tagQuery != "" {
+		tags, err := parseTags(*tagQuery)
+		if err != nil {
+			return err
+		}
+		o.Filters = append(o.Filters, metrics.TagsFilter(tags))
+	}
+
+	now := time.Now()
+	var err error
+	if *start != "" {
+		if o.Start, err = parseTime(*start, now); err != nil {
+			return err
+		}
+	}
+	if *end != "" {
+		if o.End, err = parseTime(*end, now); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range renames {
+		r, err := migrate.ParseRule(s)
+		if err != nil {
+			return err
+		}
+		o.Rules = append(o.Rules, r)
+	}
+	for _, s := range renameTags {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("Tag rename %s is not in old=new format", s)
+		}
+		o.Tags = append(o.Tags, migrate.RenameTag(kv[0], kv[1]))
+	}
+	if len(dropTags) > 0 {
+		o.Tags = append(o.Tags, migrate.DropTags(dropTags...))
+	}
+	if len(setTags) > 0 {
+		tags := make(map[string]string)
+		for _, pair := range setTags {
+			if err := addTag(tags, pair); err != nil {
+				return err
+			}
+		}
+		o.Tags = append(o.Tags, migrate.SetTags(tags))
+	}
+
+	target := e.client
+	if *targetURL != "" || *targetToken != "" {
+		cfg := *e.config
+		if *targetURL != "" {
+			cfg.URL = *targetURL
+			cfg.URLs = nil
+		}
+		if *targetToken != "" {
+			cfg.Token = *targetToken
+			cfg.TokenFile = ""
+		}
+		p, err := cfg.Parameters()
+		if err != nil {
+			return err
+		}
+		if target, err = metrics.NewHawkularClient(p); err != nil {
+			return err
+		}
+		defer target.Close()
+	}
+
+	o.Progress = func(p migrate.Progress) {
+		fmt.Fprintf(e.stderr, "\r%d/%d metrics, %d datapoints", p.Copied, p.Metrics, p.Datapoints)
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+	res, err := migrate.Copy(ctx, e.client, target, o)
+	if res != nil {
+		fmt.Fprintln(e.stderr)
+	}
+	if ve, ok := err.(*migrate.VerifyError); ok {
+		for _, m := range ve.Mismatches {
+			fmt.Fprintf(e.stderr, "%s %s: %d datapoints in the source, %d in the target\n", m.Type, m.SourceID, m.Source, m.Target)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return e.out.print(res, []string{"METRICS", "DATAPOINTS", "VERIFIED"},
+		[][]string{{strconv.Itoa(res.Metrics), strconv.FormatInt(res.Datapoints, 10), strconv.Itoa(res.Verified)}})
+}
@@ -0,0 +1,125 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+	"github.com/hawkular/hawkular-client-go/metrics/csvio"
+)
+
+// csvOptions adds the -layout and -time-format flags
+type csvOptions struct {
+	layout     string
+	timeFormat string
+}
+
+func (co *csvOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&co.layout, "layout", "long", "CSV layout, long or wide")
+	fs.StringVar(&co.timeFormat, "time-format", "rfc3339", "Timestamp format: rfc3339, unixms, unix or a Go time layout")
+}
+
+func (co *csvOptions) options() (csvio.Options, error) {
+	o := csvio.Options{}
+	switch co.layout {
+	case "long":
+		o.Layout = csvio.Long
+	case "wide":
+		o.Layout = csvio.Wide
+	default:
+		return o, fmt.Errorf("Unknown layout %s", co.layout)
+	}
+	if co.timeFormat != "rfc3339" {
+		o.TimestampFormat = co.timeFormat
+	}
+	return o, nil
+}
+
+// csvExport writes the raw datapoints, or buckets with -buckets or -duration, to stdout
+func csvExport(e *env, args []string) error {
+	fs := flags("csv export")
+	co := &csvOptions{}
+	co.register(fs)
+	tr := &timeRange{}
+	tr.register(fs)
+	buckets := fs.Int("buckets", 0, "Export this many buckets instead of the raw datapoints")
+	duration := fs.Duration("duration", 0, "Export buckets of this duration instead of the raw datapoints")
+	stat := fs.String("stat", csvio.StatAvg, "Bucket statistic of the wide layout: min, avg, median, max or samples")
+	args, err := arguments(fs, args, 2, "<type> <id>...")
+	if err != nil {
+		return err
+	}
+	t, err := parseType(args[0])
+	if err != nil {
+		return err
+	}
+	o, err := co.options()
+	if err != nil {
+		return err
+	}
+	f, err := tr.filters()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *buckets > 0 && *duration > 0:
+		return fmt.Errorf("Specify either -buckets or -duration")
+	case *buckets > 0:
+		f = append(f, metrics.BucketsFilter(*buckets))
+	case *duration > 0:
+		f = append(f, metrics.BucketsDurationFilter(*duration))
+	default:
+		return csvio.ExportRaw(e.client, e.out.w, t, args[1:], o, metrics.Filters(f...))
+	}
+	return csvio.ExportBuckets(e.client, e.out.w, t, args[1:], *stat, o, metrics.Filters(f...))
+}
+
+// csvImport writes the datapoints of the CSV from stdin
+func csvImport(e *env, args []string) error {
+	fs := flags("csv import")
+	co := &csvOptions{}
+	co.register(fs)
+	typ := fs.String("type", "", "Metric type, inferred from the values without it or a type column")
+	batch := fs.Int("batch", 0, "Datapoints per write request, defaults to 1000")
+	if _, err := arguments(fs, args, 0, ""); err != nil {
+		return err
+	}
+	o, err := co.options()
+	if err != nil {
+		return err
+	}
+	if *typ != "" {
+		if o.Type, err = parseType(*typ); err != nil {
+			return err
+		}
+	}
+	o.BatchSize = *batch
+
+	n, err := csvio.Import(e.client, e.stdin, o)
+	if err != nil {
+		if n > 0 {
+			fmt.Fprintf(e.stderr, "%d datapoints were written before the error\n", n)
+		}
+		return err
+	}
+	return e.out.print(map[string]int{"datapoints": n}, []string{"DATAPOINTS"}, [][]string{{strconv.Itoa(n)}})
+}
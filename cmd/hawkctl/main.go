@@ -0,0 +1,222 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Command hawkctl operates a Hawkular-Metrics server from the command line.
+//
+// The connection settings are read from the configuration file given with -config and
+// the HAWKULAR_* environment variables, the same way as metrics.LoadConfig and
+// metrics.Config.LoadEnv do, and can be overridden with the global flags.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+const usage = `Usage: hawkctl [global flags] <command> [flags] [arguments]
+
+Commands:
+  tenants list
+  tenants create <id>
+  definitions list [-type type] [-tags k=v,...]
+  definitions get <type> <id>
+  definitions create [-tags k=v,...] [-retention days] <type> <id>
+  definitions delete <type> <id>
+  tags get <type> <id>
+  tags update <type> <id> <k=v>...
+  tags delete <type> <id> <name>...
+  write [-type type]                     (datapoints as JSON from stdin)
+  read raw [-start t] [-end t] [-limit n] [-order ASC|DESC] <type> <id>
+  read buckets [-start t] [-end t] [-buckets n | -duration d] [-tags k=v,...] [-percentiles p,...] <type> [id...]
+  csv export [-layout long|wide] [-time-format f] [-start t] [-end t] [-buckets n | -duration d] [-stat s] <type> <id>...
+  csv import [-type type] [-time-format f] [-batch n]   (CSV from stdin)
+  backup export [-start t] [-end t] [-chunk n] <dir>
+  backup import [-target tenant] [-checkpoint file] [-batch n] <dir>
+  copy [-target-url u] [-target-tenant t] [-target-token t] [-type type] [-tags k=v,...] [-start t] [-end t]
+       [-rename pattern=replacement]... [-rename-tag old=new]... [-drop-tag name]... [-set-tag k=v]...
+       [-remove-tags] [-parallel n] [-verify]
+  status
+
+Times are RFC 3339 timestamps, milliseconds since epoch or durations relative to now, such as -1h.
+CSV time formats are rfc3339, unixms, unix or a Go time layout.
+
+Global flags:
+`
+
+// command runs a (sub)command with its arguments
+type command func(e *env, args []string) error
+
+// env holds the state shared by the commands
+type env struct {
+	client *metrics.Client
+	config *metrics.Config
+	stdin  io.Reader
+	stderr io.Writer
+	out    *printer
+}
+
+var commands = map[string]map[string]command{
+	"tenants": {
+		"list":   tenantsList,
+		"create": tenantsCreate,
+	},
+	"definitions": {
+		"list":   definitionsList,
+		"get":    definitionsGet,
+		"create": definitionsCreate,
+		"delete": definitionsDelete,
+	},
+	"tags": {
+		"get":    tagsGet,
+		"update": tagsUpdate,
+		"delete": tagsDelete,
+	},
+	"read": {
+		"raw":     readRaw,
+		"buckets": readBuckets,
+	},
+	"write": {
+		"": write,
+	},
+	"csv": {
+		"export": csvExport,
+		"import": csvImport,
+	},
+	"backup": {
+		"export": backupExport,
+		"import": backupImport,
+	},
+	"copy": {
+		"": copyTenant,
+	},
+	"status": {
+		"": status,
+	},
+}
+
+func main() {
+	if err := run(os.Args[1:], os.LookupEnv, os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "hawkctl: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(args []string, lookupEnv func(string) (string, bool), stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("hawkctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configFile := fs.String("config", "", "Configuration file (YAML or JSON)")
+	url := fs.String("url", "", "Hawkular-Metrics URL, overrides "+metrics.EnvURL)
+	tenant := fs.String("tenant", "", "Tenant, overrides "+metrics.EnvTenant)
+	token := fs.String("token", "", "Bearer token, overrides "+metrics.EnvToken)
+	adminToken := fs.String("admin-token", "", "Admin token for the tenant commands, overrides "+metrics.EnvAdminToken)
+	insecure := fs.Bool("insecure", false, "Skip the verification of the server certificate")
+	output := fs.String("output", "table", "Output format: table, json or csv")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	out, err := newPrinter(stdout, *output)
+	if err != nil {
+		return err
+	}
+
+	cmd, rest, err := lookup(fs.Args())
+	if err != nil {
+		fs.Usage()
+		return err
+	}
+
+	cfg := &metrics.Config{}
+	if *configFile != "" {
+		if cfg, err = metrics.LoadConfig(*configFile); err != nil {
+			return err
+		}
+	}
+	if err = cfg.LoadEnvFrom(lookupEnv); err != nil {
+		return err
+	}
+	if *url != "" {
+		cfg.URL = *url
+		cfg.URLs = nil
+	}
+	if *tenant != "" {
+		cfg.Tenant = *tenant
+	}
+	if *token != "" {
+		cfg.Token = *token
+		cfg.TokenFile = ""
+	}
+	if *adminToken != "" {
+		cfg.AdminToken = *adminToken
+	}
+	if *insecure {
+		cfg.InsecureSkipVerify = true
+	}
+
+	p, err := cfg.Parameters()
+	if err != nil {
+		return err
+	}
+	c, err := metrics.NewHawkularClient(p)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return cmd(&env{client: c, config: cfg, stdin: stdin, stderr: stderr, out: out}, rest)
+}
+
+func lookup(args []string) (command, []string, error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("Command is required")
+	}
+	subs, found := commands[args[0]]
+	if !found {
+		return nil, nil, fmt.Errorf("Unknown command %s", args[0])
+	}
+	if cmd, found := subs[""]; found {
+		return cmd, args[1:], nil
+	}
+	if len(args) < 2 {
+		return nil, nil, fmt.Errorf("%s requires one of the subcommands: %s", args[0], strings.Join(subcommands(subs), ", "))
+	}
+	cmd, found := subs[args[1]]
+	if !found {
+		return nil, nil, fmt.Errorf("Unknown command %s %s", args[0], args[1])
+	}
+	return cmd, args[2:], nil
+}
+
+func subcommands(subs map[string]command) []string {
+	names := make([]string, 0, len(subs))
+	for name := range subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,147 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+// noEnv isolates the commands from the HAWKULAR_* variables of the test environment
+func noEnv(string) (string, bool) {
+	return "", false
+}
+
+func runCommand(t *testing.T, url string, stdin string, args ...string) (string, error) {
+	out := &bytes.Buffer{}
+	args = append([]string{"-url", url, "-tenant", "cli"}, args...)
+	err := run(args, noEnv, strings.NewReader(stdin), out, ioutil.Discard)
+	return out.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	var lastBody, lastPath, lastQuery string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		lastBody, lastPath, lastQuery = string(b), r.URL.Path, r.URL.RawQuery
+		switch {
+		case r.URL.Path == "/hawkular/metrics/metrics":
+			w.Write([]byte(`[{"id":"cpu","type":"gauge","tags":{"host":"a","dc":"x"},"dataRetention":7}]`))
+		case strings.HasSuffix(r.URL.Path, "/raw") && r.Method == "GET":
+			w.Write([]byte(`[{"timestamp":1500000000000,"value":1.5}]`))
+		case strings.HasSuffix(r.URL.Path, "/stats"):
+			w.Write([]byte(`[{"start":1500000000000,"end":1500000060000,"min":1,"max":3,"avg":2,"median":2,"samples":3,"empty":false}]`))
+		case r.URL.Path == "/hawkular/metrics/status":
+			w.Write([]byte(`{"MetricsService":"STARTED","Implementation-Version":"0.28.0"}`))
+		}
+	}))
+	defer s.Close()
+
+	out, err := runCommand(t, s.URL, "", "definitions", "list", "-type", "gauge", "-tags", "host=a")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "dc=x,host=a")
+	assert.Contains(t, lastQuery, "type=gauge")
+
+	out, err = runCommand(t, s.URL, "", "-output", "csv", "read", "raw", "-start", "-1h", "gauge", "cpu")
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Equal(t, "TIMESTAMP,VALUE,TAGS", lines[0])
+	assert.Contains(t, lines[1], ",1.5,")
+	assert.Contains(t, lastQuery, "start=")
+
+	out, err = runCommand(t, s.URL, "", "-output", "json", "read", "buckets", "-buckets", "1", "gauge", "cpu", "mem")
+	assert.NoError(t, err)
+	assert.Contains(t, out, `"start": 1500000000000`)
+	assert.Contains(t, lastQuery, "metrics=cpu&metrics=mem")
+
+	_, err = runCommand(t, s.URL, `{"gauges":[{"id":"cpu","data":[{"timestamp":1500000000000,"value":1}]}]}`, "write")
+	assert.NoError(t, err)
+	assert.Equal(t, "/hawkular/metrics/gauges/raw", lastPath)
+	assert.Contains(t, lastBody, `"id":"cpu"`)
+
+	_, err = runCommand(t, s.URL, "", "tags", "update", "gauge", "cpu", "host=b")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"host":"b"}`, lastBody)
+
+	out, err = runCommand(t, s.URL, "", "csv", "export", "-layout", "wide", "-time-format", "unixms", "gauge", "cpu")
+	assert.NoError(t, err)
+	assert.Equal(t, "timestamp,cpu\n1500000000000,1.5\n", out)
+
+	out, err = runCommand(t, s.URL, "metric,timestamp,value\nmem,1500000000000,1\n", "csv", "import")
+	assert.NoError(t, err)
+	assert.Equal(t, "/hawkular/metrics/gauges/raw", lastPath)
+	assert.Contains(t, out, "1")
+
+	_, err = runCommand(t, s.URL, "metric,timestamp,value\nmem,x,1\n", "csv", "import")
+	assert.EqualError(t, err, "Line 2, column timestamp: Timestamp x is neither RFC 3339 nor milliseconds since epoch")
+
+	dir, err := ioutil.TempDir("", "hawkctl")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	out, err = runCommand(t, s.URL, "", "-output", "csv", "backup", "export", "-start", "-1h", dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "TENANT,DEFINITIONS,DATAPOINTS,CHUNKS\ncli,1,1,1\n", out)
+	out, err = runCommand(t, s.URL, "", "-output", "csv", "backup", "import", "-target", "copy", dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "/hawkular/metrics/gauges/raw", lastPath)
+	assert.Equal(t, "TENANT,CHUNKS,DATAPOINTS\ncopy,1,1\n", out)
+
+	out, err = runCommand(t, s.URL, "", "-output", "csv", "copy", "-target-tenant", "copy", "-rename", "^(.*)$=old.$1", "-drop-tag", "dc")
+	assert.NoError(t, err)
+	assert.Equal(t, "/hawkular/metrics/gauges/raw", lastPath)
+	assert.Contains(t, lastBody, `"id":"old.cpu"`)
+	assert.Equal(t, "METRICS,DATAPOINTS,VERIFIED\n1,1,0\n", out)
+	_, err = runCommand(t, s.URL, "", "copy")
+	assert.EqualError(t, err, "copy requires -target-url or -target-tenant")
+
+	out, err = runCommand(t, s.URL, "", "status")
+	assert.NoError(t, err)
+	assert.Contains(t, out, "STARTED")
+
+	_, err = runCommand(t, s.URL, "", "definitions")
+	assert.Error(t, err)
+	_, err = runCommand(t, s.URL, "", "read", "raw", "gauge")
+	assert.Error(t, err)
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	ts, err := parseTime("-1h", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-time.Hour), ts)
+
+	ts, err = parseTime("2017-07-01T10:00:00Z", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-2*time.Hour), ts.UTC())
+
+	ts, err = parseTime("1498910400000", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now, ts.UTC())
+
+	_, err = parseTime("yesterday", now)
+	assert.Error(t, err)
+}
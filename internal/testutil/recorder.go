@@ -0,0 +1,91 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package testutil contains the fakes shared by the tests of the metrics packages
+package testutil
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// Write is a single write received by the Recorder
+type Write struct {
+	Tenant  string
+	Metrics []metrics.MetricHeader
+}
+
+// Recorder implements metrics.Writer and keeps every write with the tenant set by its modifiers
+type Recorder struct {
+	lock   sync.Mutex
+	writes []Write
+}
+
+// Write records the metrics
+func (r *Recorder) Write(mhs []metrics.MetricHeader, o ...metrics.Modifier) error {
+	req, _ := http.NewRequest("POST", "http://localhost", nil)
+	for _, m := range o {
+		m(req)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.writes = append(r.writes, Write{Tenant: req.Header.Get("Hawkular-Tenant"), Metrics: mhs})
+	return nil
+}
+
+// Count returns the amount of writes
+func (r *Recorder) Count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.writes)
+}
+
+// Last returns the latest write, or an empty one if nothing was written
+func (r *Recorder) Last() Write {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.writes) == 0 {
+		return Write{}
+	}
+	return r.writes[len(r.writes)-1]
+}
+
+// Datapoints returns the amount of written datapoints per metric id
+func (r *Recorder) Datapoints() map[string]int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	m := make(map[string]int)
+	for _, w := range r.writes {
+		for _, mh := range w.Metrics {
+			m[mh.ID] += len(mh.Data)
+		}
+	}
+	return m
+}
+
+// Tenants returns the written metrics grouped by tenant
+func (r *Recorder) Tenants() map[string][]metrics.MetricHeader {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	m := make(map[string][]metrics.MetricHeader)
+	for _, w := range r.writes {
+		m[w.Tenant] = append(m[w.Tenant], w.Metrics...)
+	}
+	return m
+}
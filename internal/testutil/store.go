@@ -0,0 +1,228 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package testutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// Start is the timestamp of the first datapoint added with Store.Add
+var Start = time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+
+// Store is an in-memory Hawkular-Metrics server for a single tenant. The maps are keyed by
+// "type/id" and must not be accessed while a client of the store is running.
+type Store struct {
+	Tenant   string
+	Defs     map[string]*metrics.MetricDefinition
+	Data     map[string]map[int64]interface{}
+	Reads    int             // Amount of the raw datapoint reads
+	OnRead   func(reads int) // Called for each raw datapoint read
+	Dropping bool            // Accept and drop the writes
+
+	lock sync.Mutex
+}
+
+// NewStore returns an empty store for the tenant
+func NewStore(tenant string) *Store {
+	return &Store{Tenant: tenant, Defs: make(map[string]*metrics.MetricDefinition), Data: make(map[string]map[int64]interface{})}
+}
+
+// NewClient starts a server for the store and returns a client of its tenant and a function
+// that stops both
+func NewClient(t assert.TestingT, s *Store) (*metrics.Client, func()) {
+	server := httptest.NewServer(s)
+	c, err := metrics.NewHawkularClient(metrics.Parameters{Tenant: s.Tenant, Url: server.URL, Concurrency: 4})
+	assert.NoError(t, err)
+	return c, func() {
+		c.Close()
+		server.Close()
+	}
+}
+
+func typeOf(segment string) metrics.MetricType {
+	switch segment {
+	case "gauges":
+		return metrics.Gauge
+	case "counters":
+		return metrics.Counter
+	case "strings":
+		return metrics.String
+	}
+	return metrics.MetricType(segment)
+}
+
+// Add stores the definition with datapoints one minute apart from Start. The values default to
+// the index of the datapoint.
+func (s *Store) Add(md metrics.MetricDefinition, points int, value func(i int) interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := string(md.Type) + "/" + md.ID
+	d := md
+	s.Defs[key] = &d
+	s.Data[key] = make(map[int64]interface{})
+	for i := 0; i < points; i++ {
+		var v interface{} = float64(i)
+		if value != nil {
+			v = value(i)
+		}
+		s.Data[key][metrics.ToUnixMilli(Start.Add(time.Duration(i)*time.Minute))] = v
+	}
+}
+
+// Points returns the datapoints of a metric
+func (s *Store) Points(t metrics.MetricType, id string) map[int64]interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.Data[string(t)+"/"+id]
+}
+
+// selected returns the unsorted timestamps of the metric in the queried range. The range ends
+// at the end parameter and starts 8 hours earlier by default, as in Hawkular-Metrics.
+func (s *Store) selected(key string, q map[string][]string) []int64 {
+	get := func(name string) string {
+		if v := q[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	end, _ := strconv.ParseInt(get("end"), 10, 64)
+	start := end - 8*3600*1000
+	if get("start") != "" {
+		start, _ = strconv.ParseInt(get("start"), 10, 64)
+	} else if get("fromEarliest") == "true" {
+		start = 0
+	}
+	keys := []int64{}
+	for k := range s.Data[key] {
+		if k >= start && k < end {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r.Header.Get("Hawkular-Tenant") != s.Tenant {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/hawkular/metrics/"), "/")
+	b, _ := ioutil.ReadAll(r.Body)
+	q := r.URL.Query()
+
+	switch {
+	case r.Method == "GET" && len(path) == 1 && path[0] == "metrics":
+		defs := make([]*metrics.MetricDefinition, 0, len(s.Defs))
+		for _, md := range s.Defs {
+			if q.Get("type") == "" || string(md.Type) == q.Get("type") {
+				defs = append(defs, md)
+			}
+		}
+		json.NewEncoder(w).Encode(defs)
+
+	case r.Method == "POST" && len(path) == 1:
+		md := metrics.MetricDefinition{}
+		json.Unmarshal(b, &md)
+		md.Type = typeOf(path[0])
+		key := string(md.Type) + "/" + md.ID
+		if _, found := s.Defs[key]; found {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"errorMsg":"exists"}`))
+			return
+		}
+		s.Defs[key] = &md
+		s.Data[key] = make(map[int64]interface{})
+
+	case r.Method == "PUT" && len(path) == 3 && path[2] == "tags":
+		md := s.Defs[string(typeOf(path[0]))+"/"+path[1]]
+		tags := map[string]string{}
+		json.Unmarshal(b, &tags)
+		if md.Tags == nil {
+			md.Tags = map[string]string{}
+		}
+		for k, v := range tags {
+			md.Tags[k] = v
+		}
+
+	case r.Method == "DELETE" && len(path) == 4 && path[2] == "tags":
+		md := s.Defs[string(typeOf(path[0]))+"/"+path[1]]
+		for _, name := range strings.Split(path[3], ",") {
+			delete(md.Tags, name)
+		}
+
+	case r.Method == "POST" && len(path) == 2 && path[1] == "raw":
+		mhs := []metrics.MetricHeader{}
+		json.Unmarshal(b, &mhs)
+		for _, mh := range mhs {
+			key := string(typeOf(path[0])) + "/" + mh.ID
+			if s.Data[key] == nil {
+				s.Data[key] = make(map[int64]interface{})
+			}
+			for _, dp := range mh.Data {
+				if !s.Dropping {
+					s.Data[key][metrics.ToUnixMilli(dp.Timestamp)] = dp.Value
+				}
+			}
+		}
+
+	case r.Method == "GET" && len(path) == 3 && path[2] == "raw":
+		s.Reads++
+		if s.OnRead != nil {
+			s.OnRead(s.Reads)
+		}
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		key := string(typeOf(path[0])) + "/" + path[1]
+		keys := s.selected(key, q)
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		if limit > 0 && len(keys) > limit {
+			keys = keys[:limit]
+		}
+		dps := []metrics.Datapoint{}
+		for _, k := range keys {
+			dps = append(dps, metrics.Datapoint{Timestamp: metrics.FromUnixMilli(k), Value: s.Data[key][k]})
+		}
+		json.NewEncoder(w).Encode(dps)
+
+	case r.Method == "GET" && len(path) == 2 && path[1] == "stats":
+		n := len(s.selected(string(typeOf(path[0]))+"/"+q.Get("metrics"), q))
+		if n == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`[{"start":0,"end":1,"samples":` + strconv.Itoa(n) + `}]`))
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/hawkular/hawkular-client-go/internal/testutil"
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+func sourceStore() *testutil.Store {
+	s := testutil.NewStore("source")
+	s.Add(metrics.MetricDefinition{Type: metrics.Gauge, ID: "cpu", Tags: map[string]string{"host": "a"}}, 25, func(i int) interface{} { return float64(i) / 2 })
+	s.Add(metrics.MetricDefinition{Type: metrics.Counter, ID: "requests"}, 5, func(i int) interface{} { return float64(i * 10) })
+	s.Add(metrics.MetricDefinition{Type: metrics.String, ID: "log", Tags: map[string]string{"app": "x"}, RetentionTime: 7}, 3, func(i int) interface{} { return "line " + strconv.Itoa(i) })
+	s.Add(metrics.MetricDefinition{Type: metrics.Gauge, ID: "empty"}, 0, nil)
+	return s
+}
+
+func TestExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := sourceStore()
+	c, closer := testutil.NewClient(t, src)
+	defer closer()
+
+	e := &Exporter{Client: c, Dir: dir, ChunkSize: 10, End: testutil.Start.Add(24 * time.Hour)}
+	m, err := e.Export(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, m.Complete)
+	assert.Nil(t, m.Checkpoint)
+	assert.Equal(t, "source", m.Tenant)
+	assert.Equal(t, 4, m.Definitions)
+	assert.Equal(t, 33, m.Datapoints)
+	assert.Equal(t, 4, len(m.Chunks))
+	assert.Equal(t, 10, m.Chunks[0].Datapoints)
+	assert.Equal(t, 3, m.Chunks[3].Datapoints)
+
+	// Exporting a complete directory again does nothing
+	reads := src.Reads
+	_, err = e.Export(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, reads, src.Reads)
+
+	// Existing definitions get the exported tags
+	dst := testutil.NewStore("target")
+	dst.Add(metrics.MetricDefinition{Type: metrics.Gauge, ID: "cpu", Tags: map[string]string{"old": "1"}}, 0, nil)
+	tc, tcloser := testutil.NewClient(t, dst)
+	defer tcloser()
+
+	i := &Importer{Client: tc, Dir: dir, Tenant: "target", BatchSize: 4}
+	cp, err := i.Import(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, cp.Chunks)
+	assert.Equal(t, 33, cp.Datapoints)
+
+	assert.Equal(t, 4, len(dst.Defs))
+	assert.Equal(t, map[string]string{"old": "1", "host": "a"}, dst.Defs["gauge/cpu"].Tags)
+	assert.Equal(t, 7, dst.Defs["string/log"].RetentionTime)
+	assert.Equal(t, src.Points(metrics.Gauge, "cpu"), dst.Points(metrics.Gauge, "cpu"))
+	assert.Equal(t, src.Points(metrics.Counter, "requests"), dst.Points(metrics.Counter, "requests"))
+	assert.Equal(t, src.Points(metrics.String, "log"), dst.Points(metrics.String, "log"))
+}
+
+func TestExportResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := sourceStore()
+	c, closer := testutil.NewClient(t, src)
+	defer closer()
+
+	// Cancelled while reading the third page
+	ctx, cancel := context.WithCancel(context.Background())
+	src.OnRead = func(reads int) {
+		if reads == 3 {
+			cancel()
+		}
+	}
+
+	e := &Exporter{Client: c, Dir: dir, ChunkSize: 10, End: testutil.Start.Add(24 * time.Hour)}
+	_, err = e.Export(ctx)
+	assert.Error(t, err)
+
+	m, err := ReadManifest(dir)
+	assert.NoError(t, err)
+	assert.False(t, m.Complete)
+	assert.Equal(t, 2, len(m.Chunks))
+	assert.Equal(t, &Checkpoint{Metric: 1, Start: metrics.ToUnixMilli(testutil.Start.Add(14*time.Minute)) + 1}, m.Checkpoint)
+
+	src.OnRead = nil
+	m, err = e.Export(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, m.Complete)
+	assert.Equal(t, 33, m.Datapoints)
+
+	// Every datapoint is exported exactly once
+	seen := make(map[string]int)
+	for _, ci := range m.Chunks {
+		err := readLines(filepath.Join(dir, ci.File), ci.SHA256, func(dec *json.Decoder) error {
+			r := Record{}
+			if err := dec.Decode(&r); err != nil {
+				return err
+			}
+			for _, dp := range r.Data {
+				seen[r.ID+"@"+dp.Timestamp.String()]++
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 33, len(seen))
+	for k, v := range seen {
+		assert.Equal(t, 1, v, k)
+	}
+}
+
+func TestImportResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c, closer := testutil.NewClient(t, sourceStore())
+	defer closer()
+	_, err = (&Exporter{Client: c, Dir: dir, ChunkSize: 10, End: testutil.Start.Add(24 * time.Hour)}).Export(context.Background())
+	assert.NoError(t, err)
+
+	// A corrupted chunk stops the import before any of its datapoints are written
+	chunk := filepath.Join(dir, chunkName(3))
+	original, err := ioutil.ReadFile(chunk)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(chunk, append(original[:len(original):len(original)], 0), 0644))
+
+	dst := testutil.NewStore("source")
+	tc, tcloser := testutil.NewClient(t, dst)
+	defer tcloser()
+
+	i := &Importer{Client: tc, Dir: dir}
+	cp, err := i.Import(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 2, cp.Chunks)
+	assert.Equal(t, 20, cp.Datapoints)
+
+	stored := &ImportCheckpoint{}
+	assert.NoError(t, readJSON(filepath.Join(dir, ImportCheckpointName("source")), stored))
+	assert.Equal(t, cp, stored)
+
+	assert.NoError(t, ioutil.WriteFile(chunk, original, 0644))
+	cp, err = i.Import(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, cp.Chunks)
+	assert.Equal(t, 33, cp.Datapoints)
+
+	// Other target tenants have their own checkpoints
+	oc, ocloser := testutil.NewClient(t, testutil.NewStore("other"))
+	defer ocloser()
+	cp, err = (&Importer{Client: oc, Dir: dir, Tenant: "other"}).Import(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, cp.Chunks)
+	assert.Equal(t, "other", cp.Tenant)
+
+	// The checkpoint may be stored outside the directory, but not shared by the tenants
+	checkpoint := filepath.Join(dir, "elsewhere.json")
+	cp, err = (&Importer{Client: tc, Dir: dir, Checkpoint: checkpoint}).Import(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 33, cp.Datapoints)
+	_, err = (&Importer{Client: oc, Dir: dir, Tenant: "other", Checkpoint: checkpoint}).Import(context.Background())
+	assert.Error(t, err)
+}
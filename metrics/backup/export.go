@@ -0,0 +1,234 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// Exporter writes the definitions and raw datapoints of a tenant to a directory
+type Exporter struct {
+	Client *metrics.Client
+	Dir    string
+	Tenant string // Defaults to the tenant of the client
+
+	// Time range of the exported datapoints. A zero Start exports from the earliest datapoint,
+	// a zero End up to the time the export was started.
+	Start time.Time
+	End   time.Time
+
+	ChunkSize int // Datapoints per chunk file and per ReadRaw request, defaults to 10000
+}
+
+// Export writes the tenant to the directory. If the directory has the manifest of an unfinished export,
+// the export continues from its checkpoint and the time range of the manifest is used. A context
+// cancellation stops the export after the last written chunk.
+func (e *Exporter) Export(ctx context.Context) (*Manifest, error) {
+	if err := os.MkdirAll(e.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	m, err := ReadManifest(e.Dir)
+	if os.IsNotExist(err) {
+		m, err = e.begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.Complete {
+		return m, nil
+	}
+	if e.Tenant != "" && m.Tenant != e.Tenant {
+		return nil, fmt.Errorf("Directory %s has an unfinished export of tenant %s", e.Dir, m.Tenant)
+	}
+
+	defs, err := readDefinitions(e.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &chunkWriter{dir: e.Dir, manifest: m, size: e.ChunkSize}
+	if w.size <= 0 {
+		w.size = 10000
+	}
+
+	cp := Checkpoint{}
+	if m.Checkpoint != nil {
+		cp = *m.Checkpoint
+	}
+	for ; cp.Metric < len(defs); cp = (Checkpoint{Metric: cp.Metric + 1}) {
+		md := defs[cp.Metric]
+		for {
+			if err := ctx.Err(); err != nil {
+				return m, err
+			}
+
+			f := []metrics.Filter{metrics.EndTimeFilter(m.End), metrics.OrderFilter(metrics.ASC), metrics.LimitFilter(w.remaining())}
+			switch {
+			case cp.Start != 0:
+				f = append(f, metrics.StartTimeFilter(metrics.FromUnixMilli(cp.Start)))
+			case m.Start.IsZero():
+				f = append(f, metrics.StartFromBeginningFilter())
+			default:
+				f = append(f, metrics.StartTimeFilter(m.Start))
+			}
+
+			dps, err := e.Client.ReadRaw(md.Type, md.ID, metrics.Tenant(m.Tenant), metrics.Filters(f...))
+			if err != nil {
+				return m, err
+			}
+
+			full := len(dps) >= w.remaining()
+			if len(dps) > 0 {
+				w.add(md.Type, md.ID, dps)
+				cp.Start = metrics.ToUnixMilli(dps[len(dps)-1].Timestamp) + 1
+			}
+			if !full {
+				break
+			}
+			if err := w.flush(&cp); err != nil {
+				return m, err
+			}
+		}
+	}
+
+	m.Complete = true
+	if err := w.flush(nil); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// begin writes the definitions and the initial manifest
+func (e *Exporter) begin() (*Manifest, error) {
+	var o []metrics.Modifier
+	if e.Tenant != "" {
+		o = append(o, metrics.Tenant(e.Tenant))
+	}
+	defs, err := e.Client.Definitions(o...)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		if defs[i].Type != defs[j].Type {
+			return defs[i].Type < defs[j].Type
+		}
+		return defs[i].ID < defs[j].ID
+	})
+
+	values := make([]interface{}, 0, len(defs))
+	for _, md := range defs {
+		values = append(values, md)
+	}
+	if _, err := writeLines(filepath.Join(e.Dir, DefinitionsFile), values); err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{
+		Version:     FormatVersion,
+		Tenant:      e.Tenant,
+		Created:     time.Now().UTC(),
+		Start:       e.Start,
+		End:         e.End,
+		Definitions: len(defs),
+		Chunks:      []ChunkInfo{},
+		Checkpoint:  &Checkpoint{},
+	}
+	if m.Tenant == "" {
+		m.Tenant = e.Client.Tenant
+	}
+	if m.End.IsZero() {
+		m.End = m.Created
+	}
+	if err := writeJSON(filepath.Join(e.Dir, ManifestFile), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func readDefinitions(dir string) ([]*metrics.MetricDefinition, error) {
+	defs := []*metrics.MetricDefinition{}
+	err := readLines(filepath.Join(dir, DefinitionsFile), "", func(dec *json.Decoder) error {
+		md := &metrics.MetricDefinition{}
+		if err := dec.Decode(md); err != nil {
+			return err
+		}
+		defs = append(defs, md)
+		return nil
+	})
+	return defs, err
+}
+
+// chunkWriter buffers the records of the next chunk
+type chunkWriter struct {
+	dir      string
+	manifest *Manifest
+	size     int
+	records  []Record
+	count    int
+}
+
+// remaining is the number of datapoints that still fit to the current chunk
+func (w *chunkWriter) remaining() int {
+	return w.size - w.count
+}
+
+func (w *chunkWriter) add(t metrics.MetricType, id string, dps []*metrics.Datapoint) {
+	if n := len(w.records); n == 0 || w.records[n-1].Type != t || w.records[n-1].ID != id {
+		w.records = append(w.records, Record{Type: t, ID: id})
+	}
+	r := &w.records[len(w.records)-1]
+	for _, dp := range dps {
+		r.Data = append(r.Data, *dp)
+	}
+	w.count += len(dps)
+}
+
+// flush writes the buffered records as a new chunk and then the manifest with the checkpoint
+func (w *chunkWriter) flush(cp *Checkpoint) error {
+	m := w.manifest
+	if w.count > 0 {
+		values := make([]interface{}, 0, len(w.records))
+		for _, r := range w.records {
+			values = append(values, r)
+		}
+		name := chunkName(len(m.Chunks) + 1)
+		sum, err := writeLines(filepath.Join(w.dir, name), values)
+		if err != nil {
+			return err
+		}
+		m.Chunks = append(m.Chunks, ChunkInfo{File: name, Datapoints: w.count, SHA256: sum})
+		m.Datapoints += w.count
+		w.records, w.count = nil, 0
+	}
+	if cp != nil {
+		c := *cp
+		m.Checkpoint = &c
+	} else {
+		m.Checkpoint = nil
+	}
+	return writeJSON(filepath.Join(w.dir, ManifestFile), m)
+}
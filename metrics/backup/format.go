@@ -0,0 +1,202 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package backup exports a whole tenant to a directory and imports it back.
+//
+// The export directory contains:
+//
+//	manifest.json                    Manifest, updated after every chunk
+//	definitions.jsonl.gz             Metric definitions with tags and retention, one JSON object per line
+//	chunk-000001.jsonl.gz ...        Raw datapoints, one {"type", "id", "data"} object per line
+//	import-<tenant>.checkpoint.json  Progress of the import to each target tenant
+//
+// Every file is written to a temporary name and renamed into place, so an interrupted export or
+// import can be resumed from the last checkpoint by running it again with the same directory.
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// File names inside the export directory
+const (
+	ManifestFile    = "manifest.json"
+	DefinitionsFile = "definitions.jsonl.gz"
+)
+
+// ImportCheckpointName is the name of the default import checkpoint file of the target tenant
+func ImportCheckpointName(tenant string) string {
+	return "import-" + url.PathEscape(tenant) + ".checkpoint.json"
+}
+
+// FormatVersion is the version of the written export format
+const FormatVersion = 1
+
+// Manifest describes the contents of an export directory
+type Manifest struct {
+	Version     int         `json:"version"`
+	Tenant      string      `json:"tenant"`
+	Created     time.Time   `json:"created"`
+	Start       time.Time   `json:"start"` // Zero if exported from the earliest datapoint
+	End         time.Time   `json:"end"`
+	Definitions int         `json:"definitions"`
+	Datapoints  int         `json:"datapoints"`
+	Chunks      []ChunkInfo `json:"chunks"`
+	Complete    bool        `json:"complete"`
+	Checkpoint  *Checkpoint `json:"checkpoint,omitempty"` // Position of an unfinished export
+}
+
+// ChunkInfo describes a single data chunk
+type ChunkInfo struct {
+	File       string `json:"file"`
+	Datapoints int    `json:"datapoints"`
+	SHA256     string `json:"sha256"`
+}
+
+// Checkpoint is the position the export continues from: the index of the metric in the
+// definitions file and the first timestamp (milliseconds since epoch) not yet exported
+type Checkpoint struct {
+	Metric int   `json:"metric"`
+	Start  int64 `json:"start,omitempty"`
+}
+
+// Record is a single line of a data chunk
+type Record struct {
+	Type metrics.MetricType  `json:"type"`
+	ID   string              `json:"id"`
+	Data []metrics.Datapoint `json:"data"`
+}
+
+// ReadManifest reads the manifest of the export directory
+func ReadManifest(dir string) (*Manifest, error) {
+	m := &Manifest{}
+	if err := readJSON(filepath.Join(dir, ManifestFile), m); err != nil {
+		return nil, err
+	}
+	if m.Version != FormatVersion {
+		return nil, fmt.Errorf("Unsupported export format version %d", m.Version)
+	}
+	return m, nil
+}
+
+func readJSON(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// writeJSON replaces the file atomically
+func writeJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(b)
+		return err
+	})
+}
+
+func writeAtomic(path string, write func(w io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeLines writes the values as gzip compressed JSON lines and returns the SHA-256 of the file
+func writeLines(path string, values []interface{}) (string, error) {
+	h := sha256.New()
+	err := writeAtomic(path, func(w io.Writer) error {
+		gz := gzip.NewWriter(io.MultiWriter(w, h))
+		enc := json.NewEncoder(gz)
+		for _, v := range values {
+			if err := enc.Encode(v); err != nil {
+				return err
+			}
+		}
+		return gz.Close()
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readLines decodes the gzip compressed JSON lines of the file, checking its SHA-256 if one is given
+func readLines(path string, sum string, decode func(dec *json.Decoder) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	gz, err := gzip.NewReader(io.TeeReader(bufio.NewReader(f), h))
+	if err != nil {
+		return fmt.Errorf("Could not read %s: %s", path, err.Error())
+	}
+	dec := json.NewDecoder(gz)
+	for dec.More() {
+		if err := decode(dec); err != nil {
+			return fmt.Errorf("Could not read %s: %s", path, err.Error())
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	// Consume the rest so the checksum covers the whole file
+	if _, err := io.Copy(ioutil.Discard, gz); err != nil {
+		return err
+	}
+	if sum != "" && hex.EncodeToString(h.Sum(nil)) != sum {
+		return fmt.Errorf("Checksum of %s does not match the manifest", path)
+	}
+	return nil
+}
+
+func chunkName(n int) string {
+	return fmt.Sprintf("chunk-%06d.jsonl.gz", n)
+}
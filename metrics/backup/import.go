@@ -0,0 +1,177 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// ImportCheckpoint records the progress of an import to a target tenant
+type ImportCheckpoint struct {
+	Tenant      string `json:"tenant"`
+	Definitions bool   `json:"definitions"` // All the definitions have been created
+	Chunks      int    `json:"chunks"`      // Number of chunks written
+	Datapoints  int    `json:"datapoints"`
+}
+
+// Importer recreates the definitions and writes the datapoints of a complete export
+type Importer struct {
+	Client *metrics.Client
+	Dir    string
+	Tenant string // Target tenant, defaults to the tenant of the export
+
+	// Checkpoint is the path of the progress file, defaults to ImportCheckpointName of the target tenant
+	// in Dir. Imports of the same directory to other servers need their own checkpoints.
+	Checkpoint string
+
+	BatchSize int // Datapoints per Write, defaults to 1000
+}
+
+// Import writes the export to the target tenant. Existing definitions get the exported tags.
+// Progress is saved after every chunk, an interrupted import continues from the first chunk
+// that was not completely written. Rewriting datapoints is harmless, as they replace the
+// stored datapoints with the same timestamps.
+func (i *Importer) Import(ctx context.Context) (*ImportCheckpoint, error) {
+	m, err := ReadManifest(i.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if !m.Complete {
+		return nil, fmt.Errorf("Export in %s is not complete", i.Dir)
+	}
+
+	tenant := i.Tenant
+	if tenant == "" {
+		tenant = m.Tenant
+	}
+
+	path := i.Checkpoint
+	if path == "" {
+		path = filepath.Join(i.Dir, ImportCheckpointName(tenant))
+	}
+	cp := &ImportCheckpoint{Tenant: tenant}
+	if err := readJSON(path, cp); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if cp.Tenant != tenant {
+		return nil, fmt.Errorf("Checkpoint %s belongs to the import of tenant %s", path, cp.Tenant)
+	}
+
+	if !cp.Definitions {
+		if err := i.definitions(ctx, tenant); err != nil {
+			return cp, err
+		}
+		cp.Definitions = true
+		if err := writeJSON(path, cp); err != nil {
+			return cp, err
+		}
+	}
+
+	for cp.Chunks < len(m.Chunks) {
+		if err := ctx.Err(); err != nil {
+			return cp, err
+		}
+		n, err := i.chunk(m.Chunks[cp.Chunks], tenant)
+		if err != nil {
+			return cp, err
+		}
+		cp.Datapoints += n
+		cp.Chunks++
+		if err := writeJSON(path, cp); err != nil {
+			return cp, err
+		}
+	}
+	return cp, nil
+}
+
+func (i *Importer) definitions(ctx context.Context, tenant string) error {
+	defs, err := readDefinitions(i.Dir)
+	if err != nil {
+		return err
+	}
+	for _, md := range defs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		created, err := i.Client.Create(*md, metrics.Tenant(tenant))
+		if err != nil {
+			return err
+		}
+		if !created && len(md.Tags) > 0 {
+			if err := i.Client.UpdateTags(md.Type, md.ID, md.Tags, metrics.Tenant(tenant)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// chunk verifies the checksum of the chunk before writing any of its datapoints
+func (i *Importer) chunk(ci ChunkInfo, tenant string) (int, error) {
+	records := []Record{}
+	err := readLines(filepath.Join(i.Dir, ci.File), ci.SHA256, func(dec *json.Decoder) error {
+		r := Record{}
+		if err := dec.Decode(&r); err != nil {
+			return err
+		}
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	size := i.BatchSize
+	if size <= 0 {
+		size = 1000
+	}
+
+	total, count := 0, 0
+	var batch []metrics.MetricHeader
+	for _, r := range records {
+		for len(r.Data) > 0 {
+			n := size - count
+			if n > len(r.Data) {
+				n = len(r.Data)
+			}
+			batch = append(batch, metrics.MetricHeader{Type: r.Type, ID: r.ID, Data: r.Data[:n]})
+			r.Data = r.Data[n:]
+			count += n
+			if count == size {
+				if err := i.Client.Write(batch, metrics.Tenant(tenant)); err != nil {
+					return total, err
+				}
+				total += count
+				batch, count = nil, 0
+			}
+		}
+	}
+	if count > 0 {
+		if err := i.Client.Write(batch, metrics.Tenant(tenant)); err != nil {
+			return total, err
+		}
+		total += count
+	}
+	return total, nil
+}
@@ -0,0 +1,267 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker in the send path
+type CircuitState int
+
+const (
+	// CircuitClosed lets all the requests through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails all the requests without contacting the server
+	CircuitOpen
+	// CircuitHalfOpen lets a limited amount of probe requests through
+	CircuitHalfOpen
+)
+
+// String returns a string representation of the state
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return ""
+}
+
+const (
+	defaultOpenTimeout      = time.Duration(30 * time.Second)
+	defaultFailureWindow    = time.Duration(60 * time.Second)
+	defaultHalfOpenRequests = 1
+)
+
+// CircuitBreaker configures when the client stops sending requests to a failing server.
+// A request fails if it returns a connection error or a 5xx status code.
+type CircuitBreaker struct {
+	ConsecutiveFailures int           // Open after this many failures in a row, 0 disables
+	FailureRate         float64       // Open when this ratio (0..1) of requests in the Window fail, 0 disables
+	MinRequests         int           // Minimum amount of requests in the Window before FailureRate is evaluated
+	Window              time.Duration // Length of the FailureRate window, defaults to 60s
+	OpenTimeout         time.Duration // Time to stay open before probing, defaults to 30s
+	HalfOpenRequests    int           // Successful probes required to close the circuit, defaults to 1
+
+	// OnStateChange is called after every state transition
+	OnStateChange func(from, to CircuitState)
+}
+
+// CircuitOpenError is returned for requests rejected by an open circuit breaker
+type CircuitOpenError struct {
+	RetryAt time.Time // Time when the breaker will let probe requests through
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("Circuit breaker is open, requests are rejected until %s", e.RetryAt.Format(time.RFC3339))
+}
+
+type circuitBreaker struct {
+	settings CircuitBreaker
+	logger   Logger
+
+	lock        sync.Mutex
+	state       CircuitState
+	openedAt    time.Time
+	consecutive int
+
+	windowStart time.Time
+	requests    int
+	failures    int
+
+	probes    int       // In-flight probes while half-open
+	successes int       // Successful probes while half-open
+	lastProbe time.Time // Admission time of the latest probe
+}
+
+func newCircuitBreaker(s *CircuitBreaker) *circuitBreaker {
+	if s == nil {
+		return nil
+	}
+	cb := &circuitBreaker{settings: *s, logger: noopLogger{}}
+	if cb.settings.Window <= 0 {
+		cb.settings.Window = defaultFailureWindow
+	}
+	if cb.settings.OpenTimeout <= 0 {
+		cb.settings.OpenTimeout = defaultOpenTimeout
+	}
+	if cb.settings.HalfOpenRequests < 1 {
+		cb.settings.HalfOpenRequests = defaultHalfOpenRequests
+	}
+	return cb
+}
+
+// State returns the current state of the breaker
+func (cb *circuitBreaker) State() CircuitState {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.state
+}
+
+// allow checks if a request may proceed. Probe is true if the request was admitted while half-open
+// and its result must be recorded as such.
+func (cb *circuitBreaker) allow() (bool, error) {
+	cb.lock.Lock()
+	now := time.Now()
+	var from CircuitState
+
+	changed := false
+	if cb.state == CircuitOpen {
+		if now.Before(cb.openedAt.Add(cb.settings.OpenTimeout)) {
+			cb.lock.Unlock()
+			return false, &CircuitOpenError{RetryAt: cb.openedAt.Add(cb.settings.OpenTimeout)}
+		}
+		from, changed = cb.transition(CircuitHalfOpen, now)
+	}
+
+	probe := false
+	var err error
+	if cb.state == CircuitHalfOpen {
+		// Probes without a result within the OpenTimeout are considered lost and free their slots
+		next := cb.lastProbe.Add(cb.settings.OpenTimeout)
+		if cb.probes > 0 && !now.Before(next) {
+			cb.probes = 0
+		}
+		if cb.probes+cb.successes >= cb.settings.HalfOpenRequests {
+			err = &CircuitOpenError{RetryAt: next}
+		} else {
+			cb.probes++
+			cb.lastProbe = now
+			probe = true
+		}
+	}
+	cb.lock.Unlock()
+
+	if changed {
+		cb.notify(from, CircuitHalfOpen)
+	}
+	return probe, err
+}
+
+// record stores the result of a request admitted by allow
+func (cb *circuitBreaker) record(probe bool, success bool) {
+	cb.lock.Lock()
+	now := time.Now()
+	from := cb.state
+	to := cb.state
+
+	if probe {
+		if cb.state == CircuitHalfOpen && cb.probes > 0 {
+			cb.probes--
+			if !success {
+				to = CircuitOpen
+			} else {
+				cb.successes++
+				if cb.successes >= cb.settings.HalfOpenRequests {
+					to = CircuitClosed
+				}
+			}
+		}
+	} else if cb.state == CircuitClosed {
+		if now.Sub(cb.windowStart) > cb.settings.Window {
+			cb.windowStart = now
+			cb.requests, cb.failures = 0, 0
+		}
+		cb.requests++
+		if success {
+			cb.consecutive = 0
+		} else {
+			cb.consecutive++
+			cb.failures++
+			if cb.tripped() {
+				to = CircuitOpen
+			}
+		}
+	}
+
+	changed := false
+	if to != from {
+		from, changed = cb.transition(to, now)
+	}
+	cb.lock.Unlock()
+
+	if changed {
+		cb.notify(from, to)
+	}
+}
+
+// release returns the slot of a request admitted by allow that was never sent
+func (cb *circuitBreaker) release(probe bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if probe && cb.state == CircuitHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+func (cb *circuitBreaker) tripped() bool {
+	s := cb.settings
+	if s.ConsecutiveFailures > 0 && cb.consecutive >= s.ConsecutiveFailures {
+		return true
+	}
+	if s.FailureRate > 0 && cb.requests >= s.MinRequests {
+		return float64(cb.failures)/float64(cb.requests) >= s.FailureRate
+	}
+	return false
+}
+
+// transition must be called with the lock held
+func (cb *circuitBreaker) transition(to CircuitState, now time.Time) (CircuitState, bool) {
+	from := cb.state
+	if from == to {
+		return from, false
+	}
+	cb.state = to
+	cb.probes, cb.successes = 0, 0
+	switch to {
+	case CircuitOpen:
+		cb.openedAt = now
+	case CircuitClosed:
+		cb.consecutive = 0
+		cb.windowStart = now
+		cb.requests, cb.failures = 0, 0
+	}
+	return from, true
+}
+
+func (cb *circuitBreaker) notify(from, to CircuitState) {
+	cb.logger.Warn("Hawkular circuit breaker changed state", "from", from.String(), "to", to.String())
+	if cb.settings.OnStateChange != nil {
+		cb.settings.OnStateChange(from, to)
+	}
+}
+
+func requestSucceeded(resp *http.Response, err error) bool {
+	return err == nil && resp != nil && resp.StatusCode < 500
+}
+
+// CircuitState returns the current state of the client's circuit breaker. Without a configured
+// breaker the circuit is always closed.
+func (c *Client) CircuitState() CircuitState {
+	if c.breaker == nil {
+		return CircuitClosed
+	}
+	return c.breaker.State()
+}
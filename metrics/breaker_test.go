@@ -0,0 +1,133 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var failing int32 = 1
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer s.Close()
+
+	lock := &sync.Mutex{}
+	transitions := make([]CircuitState, 0)
+
+	p := Parameters{
+		Tenant: "breaker",
+		Url:    s.URL,
+		CircuitBreaker: &CircuitBreaker{
+			ConsecutiveFailures: 3,
+			OpenTimeout:         50 * time.Millisecond,
+			OnStateChange: func(from, to CircuitState) {
+				lock.Lock()
+				defer lock.Unlock()
+				transitions = append(transitions, to)
+			},
+		},
+	}
+
+	c, err := NewHawkularClient(p)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		r, err := c.Send(c.URL("GET"))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, r.StatusCode)
+	}
+	assert.Equal(t, CircuitOpen, c.CircuitState())
+
+	_, err = c.Send(c.URL("GET"))
+	assert.Error(t, err)
+	_, ok := err.(*CircuitOpenError)
+	assert.True(t, ok, "Open circuit should fail fast")
+
+	// Failed probe opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	_, err = c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, CircuitOpen, c.CircuitState())
+
+	// Successful probe closes it
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(60 * time.Millisecond)
+	_, err = c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, CircuitClosed, c.CircuitState())
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}, transitions)
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+	cb := newCircuitBreaker(&CircuitBreaker{FailureRate: 0.5, MinRequests: 4})
+
+	for _, success := range []bool{true, false, true} {
+		probe, err := cb.allow()
+		assert.NoError(t, err)
+		cb.record(probe, success)
+	}
+	assert.Equal(t, CircuitClosed, cb.State(), "MinRequests was not reached")
+
+	probe, err := cb.allow()
+	assert.NoError(t, err)
+	cb.record(probe, false)
+	assert.Equal(t, CircuitOpen, cb.State())
+}
+
+func TestCircuitBreakerHalfOpenRetryAt(t *testing.T) {
+	cb := newCircuitBreaker(&CircuitBreaker{ConsecutiveFailures: 1, OpenTimeout: 50 * time.Millisecond})
+
+	probe, err := cb.allow()
+	assert.NoError(t, err)
+	cb.record(probe, false)
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	time.Sleep(60 * time.Millisecond)
+	probe, err = cb.allow()
+	assert.NoError(t, err)
+	assert.True(t, probe)
+	started := time.Now()
+
+	// The probe slot is taken, the next probe is admitted once the in-flight one is considered lost
+	_, err = cb.allow()
+	assert.Error(t, err)
+	retryAt := err.(*CircuitOpenError).RetryAt
+	assert.True(t, retryAt.After(time.Now()), "RetryAt should be in the future")
+	assert.False(t, retryAt.After(started.Add(50*time.Millisecond)))
+
+	time.Sleep(time.Until(retryAt))
+	probe, err = cb.allow()
+	assert.NoError(t, err)
+	assert.True(t, probe)
+	cb.record(probe, true)
+	assert.Equal(t, CircuitClosed, cb.State())
+}
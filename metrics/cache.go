@@ -0,0 +1,348 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDefinitionTTL     = time.Duration(5 * time.Minute)
+	defaultEnsureParallelism = 4
+)
+
+// DefinitionCacheOptions configures the DefinitionCache
+type DefinitionCacheOptions struct {
+	TTL time.Duration // Lifetime of the cached definitions, defaults to 5 minutes
+
+	// EnsureDefinitions creates the definitions of the written metrics with MetricHeader.Tags
+	// before their first write. Each definition is created once per TTL.
+	EnsureDefinitions bool
+	EnsureParallelism int // Concurrent creations of a Write, defaults to 4
+}
+
+// CacheStats counts the definition lookups served from the cache
+type CacheStats struct {
+	Hits    int64
+	Misses  int64
+	Entries int
+	Ensured int // Definitions known to exist by EnsureDefinitions, until their TTL expires
+}
+
+type definitionKey struct {
+	tenant string
+	t      MetricType
+	id     string
+}
+
+type cachedDefinition struct {
+	md      MetricDefinition
+	expires time.Time
+}
+
+type ensureCall struct {
+	done chan struct{}
+	err  error
+}
+
+// DefinitionCache caches the metric definitions of the embedded Client by tenant, type and id. UpdateTags and
+// DeleteTags invalidate the cached definition, other commands are passed to the Client unchanged.
+type DefinitionCache struct {
+	*Client
+	ttl         time.Duration
+	ensure      bool
+	parallelism int
+
+	lock        sync.Mutex
+	definitions map[definitionKey]*cachedDefinition
+	ensured     map[definitionKey]time.Time // Expiry of the ensured definitions
+	ensuring    map[definitionKey]*ensureCall
+	swept       time.Time
+
+	hits   int64
+	misses int64
+}
+
+// NewDefinitionCache returns a DefinitionCache in front of the client
+func NewDefinitionCache(c *Client, options DefinitionCacheOptions) *DefinitionCache {
+	if options.TTL <= 0 {
+		options.TTL = defaultDefinitionTTL
+	}
+	if options.EnsureParallelism <= 0 {
+		options.EnsureParallelism = defaultEnsureParallelism
+	}
+	return &DefinitionCache{
+		Client:      c,
+		ttl:         options.TTL,
+		ensure:      options.EnsureDefinitions,
+		parallelism: options.EnsureParallelism,
+		definitions: make(map[definitionKey]*cachedDefinition),
+		ensured:     make(map[definitionKey]time.Time),
+		ensuring:    make(map[definitionKey]*ensureCall),
+	}
+}
+
+func (dc *DefinitionCache) key(t MetricType, id string, o []Modifier) definitionKey {
+	return definitionKey{tenant: dc.tenant(o), t: t, id: id}
+}
+
+// tenant returns the tenant of the command, set by the modifiers or the Client
+func (dc *DefinitionCache) tenant(o []Modifier) string {
+	if tenant := modifierTenant(o); tenant != "" {
+		return tenant
+	}
+	return dc.Client.Tenant
+}
+
+func (dc *DefinitionCache) lookup(k definitionKey) (*MetricDefinition, bool) {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+
+	cd, found := dc.definitions[k]
+	if !found {
+		return nil, false
+	}
+	if time.Now().After(cd.expires) {
+		delete(dc.definitions, k)
+		return nil, false
+	}
+	return copyDefinition(&cd.md), true
+}
+
+func (dc *DefinitionCache) store(k definitionKey, md *MetricDefinition) {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	dc.definitions[k] = &cachedDefinition{md: *copyDefinition(md), expires: time.Now().Add(dc.ttl)}
+}
+
+func copyDefinition(md *MetricDefinition) *MetricDefinition {
+	c := *md
+	if md.Tags != nil {
+		c.Tags = make(map[string]string, len(md.Tags))
+		for k, v := range md.Tags {
+			c.Tags[k] = v
+		}
+	}
+	return &c
+}
+
+// Invalidate removes the cached definition
+func (dc *DefinitionCache) Invalidate(t MetricType, id string, o ...Modifier) {
+	k := dc.key(t, id, o)
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	delete(dc.definitions, k)
+}
+
+// Definition returns the cached definition or fetches it from the server
+func (dc *DefinitionCache) Definition(t MetricType, id string, o ...Modifier) (*MetricDefinition, error) {
+	k := dc.key(t, id, o)
+	if md, found := dc.lookup(k); found {
+		atomic.AddInt64(&dc.hits, 1)
+		return md, nil
+	}
+	atomic.AddInt64(&dc.misses, 1)
+
+	md, err := dc.Client.Definition(t, id, o...)
+	if err != nil || md == nil {
+		return md, err
+	}
+	dc.store(k, md)
+	return md, nil
+}
+
+// Tags returns the tags of the cached definition or fetches the definition from the server
+func (dc *DefinitionCache) Tags(t MetricType, id string, o ...Modifier) (map[string]string, error) {
+	md, err := dc.Definition(t, id, o...)
+	if err != nil || md == nil {
+		return nil, err
+	}
+	if md.Tags == nil {
+		return map[string]string{}, nil
+	}
+	return md.Tags, nil
+}
+
+// Create creates the definition and caches the definition stored by the server, with its defaults.
+// The cached definition is only invalidated if it can not be fetched.
+func (dc *DefinitionCache) Create(md MetricDefinition, o ...Modifier) (bool, error) {
+	created, err := dc.Client.Create(md, o...)
+	if err != nil {
+		return created, err
+	}
+
+	k := dc.key(md.Type, md.ID, o)
+	dc.markEnsured(k)
+	if stored, err := dc.Client.Definition(md.Type, md.ID, o...); err == nil && stored != nil {
+		dc.store(k, stored)
+	} else {
+		dc.Invalidate(md.Type, md.ID, o...)
+	}
+	return created, nil
+}
+
+func (dc *DefinitionCache) markEnsured(k definitionKey) {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	dc.ensured[k] = time.Now().Add(dc.ttl)
+}
+
+// UpdateTags modifies the tags of the definition and invalidates the cached definition
+func (dc *DefinitionCache) UpdateTags(t MetricType, id string, tags map[string]string, o ...Modifier) error {
+	defer dc.Invalidate(t, id, o...)
+	return dc.Client.UpdateTags(t, id, tags, o...)
+}
+
+// DeleteTags deletes the tags of the definition and invalidates the cached definition
+func (dc *DefinitionCache) DeleteTags(t MetricType, id string, tags []string, o ...Modifier) error {
+	defer dc.Invalidate(t, id, o...)
+	return dc.Client.DeleteTags(t, id, tags, o...)
+}
+
+// Delete deletes the metric and forgets its cached and ensured definition
+func (dc *DefinitionCache) Delete(t MetricType, id string, o ...Modifier) error {
+	k := dc.key(t, id, o)
+	defer func() {
+		dc.lock.Lock()
+		defer dc.lock.Unlock()
+		delete(dc.definitions, k)
+		delete(dc.ensured, k)
+	}()
+	return dc.Client.Delete(t, id, o...)
+}
+
+// Write writes the datapoints. With EnsureDefinitions the missing definitions are created first.
+func (dc *DefinitionCache) Write(metrics []MetricHeader, o ...Modifier) error {
+	if dc.ensure {
+		if err := dc.ensureDefinitions(metrics, o); err != nil {
+			return err
+		}
+	}
+	return dc.Client.Write(metrics, o...)
+}
+
+func (dc *DefinitionCache) ensureDefinitions(metrics []MetricHeader, o []Modifier) error {
+	dc.sweep()
+	tenant := dc.tenant(o)
+
+	indexes := make(chan int)
+	errs := make(chan error, dc.parallelism)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < dc.parallelism && w < len(metrics); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := dc.ensureDefinition(tenant, metrics[i], o); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for i := range metrics {
+		select {
+		case indexes <- i:
+		case err = <-errs:
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	return err
+}
+
+// ensureDefinition creates the definition unless it is known to exist. Concurrent writes of the same
+// metric wait for the first creation instead of creating it again. The stored definition is not fetched.
+func (dc *DefinitionCache) ensureDefinition(tenant string, m MetricHeader, o []Modifier) error {
+	k := definitionKey{tenant: tenant, t: m.Type, id: m.ID}
+
+	dc.lock.Lock()
+	if expires, found := dc.ensured[k]; found && time.Now().Before(expires) {
+		dc.lock.Unlock()
+		return nil
+	}
+	if call, found := dc.ensuring[k]; found {
+		dc.lock.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &ensureCall{done: make(chan struct{})}
+	dc.ensuring[k] = call
+	dc.lock.Unlock()
+
+	md := MetricDefinition{Tenant: k.tenant, Type: m.Type, ID: m.ID, Tags: m.Tags}
+	_, err := dc.Client.Create(md, o...)
+
+	dc.lock.Lock()
+	delete(dc.ensuring, k)
+	if err == nil {
+		delete(dc.definitions, k)
+		dc.ensured[k] = time.Now().Add(dc.ttl)
+	}
+	dc.lock.Unlock()
+
+	call.err = err
+	close(call.done)
+	return err
+}
+
+// sweep removes the expired definitions and ensured entries, at most once per TTL
+func (dc *DefinitionCache) sweep() {
+	now := time.Now()
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	if now.Before(dc.swept.Add(dc.ttl)) {
+		return
+	}
+	dc.swept = now
+	for k, cd := range dc.definitions {
+		if now.After(cd.expires) {
+			delete(dc.definitions, k)
+		}
+	}
+	for k, expires := range dc.ensured {
+		if now.After(expires) {
+			delete(dc.ensured, k)
+		}
+	}
+}
+
+// CacheStats returns the cache hit and miss counts
+func (dc *DefinitionCache) CacheStats() CacheStats {
+	dc.lock.Lock()
+	defer dc.lock.Unlock()
+	return CacheStats{
+		Hits:    atomic.LoadInt64(&dc.hits),
+		Misses:  atomic.LoadInt64(&dc.misses),
+		Entries: len(dc.definitions),
+		Ensured: len(dc.ensured),
+	}
+}
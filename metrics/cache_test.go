@@ -0,0 +1,225 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestDefinitionCache(t *testing.T) {
+	var gets int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+			w.Write([]byte(`{"id":"test.cache","type":"gauge","tags":{"a":"b"}}`))
+		}
+	}))
+	defer s.Close()
+
+	c, err := NewHawkularClient(Parameters{Tenant: "cache", Url: s.URL})
+	assert.NoError(t, err)
+	dc := NewDefinitionCache(c, DefinitionCacheOptions{TTL: 50 * time.Millisecond})
+	defer dc.Close()
+
+	md, err := dc.Definition(Gauge, "test.cache")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", md.Tags["a"])
+
+	tags, err := dc.Tags(Gauge, "test.cache")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", tags["a"])
+	assert.Equal(t, int32(1), atomic.LoadInt32(&gets))
+
+	// Other tenant is cached separately
+	_, err = dc.Definition(Gauge, "test.cache", Tenant("other"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&gets))
+
+	assert.NoError(t, dc.UpdateTags(Gauge, "test.cache", map[string]string{"c": "d"}))
+	_, err = dc.Definition(Gauge, "test.cache")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&gets))
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = dc.Definition(Gauge, "test.cache")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&gets))
+
+	cs := dc.CacheStats()
+	assert.Equal(t, int64(1), cs.Hits)
+	assert.Equal(t, int64(4), cs.Misses)
+
+	// Deleted definitions are evicted
+	assert.NoError(t, dc.Delete(Gauge, "test.cache"))
+	_, err = dc.Definition(Gauge, "test.cache")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&gets))
+}
+
+func TestEnsureDefinitions(t *testing.T) {
+	lock := &sync.Mutex{}
+	created := make([]MetricDefinition, 0)
+	var writes int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/raw") {
+			atomic.AddInt32(&writes, 1)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		md := MetricDefinition{}
+		json.Unmarshal(b, &md)
+		lock.Lock()
+		created = append(created, md)
+		lock.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer s.Close()
+
+	c, err := NewHawkularClient(Parameters{Tenant: "cache", Url: s.URL})
+	assert.NoError(t, err)
+	dc := NewDefinitionCache(c, DefinitionCacheOptions{EnsureDefinitions: true})
+	defer dc.Close()
+
+	mhs := []MetricHeader{
+		{Type: Gauge, ID: "test.ensure.1", Tags: map[string]string{"host": "a"}, Data: []Datapoint{{Timestamp: time.Now(), Value: 1.0}}},
+		{Type: Counter, ID: "test.ensure.2", Data: []Datapoint{{Timestamp: time.Now(), Value: 1}}},
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, dc.Write(mhs))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 2, len(created))
+	for _, md := range created {
+		if md.ID == "test.ensure.1" {
+			assert.Equal(t, "a", md.Tags["host"])
+		}
+	}
+	assert.Equal(t, int32(10), atomic.LoadInt32(&writes))
+	assert.Equal(t, 2, dc.CacheStats().Ensured)
+}
+
+func TestEnsureParallelism(t *testing.T) {
+	var active, peak int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/raw") {
+			return
+		}
+		n := atomic.AddInt32(&active, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer s.Close()
+
+	c, err := NewHawkularClient(Parameters{Tenant: "cache", Url: s.URL, Concurrency: 8})
+	assert.NoError(t, err)
+	dc := NewDefinitionCache(c, DefinitionCacheOptions{EnsureDefinitions: true, EnsureParallelism: 2})
+	defer dc.Close()
+
+	mhs := make([]MetricHeader, 0, 10)
+	for i := 0; i < 10; i++ {
+		mhs = append(mhs, MetricHeader{Type: Gauge, ID: "test.parallel." + strconv.Itoa(i), Data: []Datapoint{{Timestamp: time.Now(), Value: 1.0}}})
+	}
+	assert.NoError(t, dc.Write(mhs))
+	assert.Equal(t, 10, dc.CacheStats().Ensured)
+	assert.True(t, atomic.LoadInt32(&peak) <= 2, "At most 2 definitions should be created at a time")
+}
+
+func TestCreateReadsStoredDefinition(t *testing.T) {
+	var gets int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		atomic.AddInt32(&gets, 1)
+		w.Write([]byte(`{"id":"test.create","type":"gauge","tags":{"a":"b"},"dataRetention":7}`))
+	}))
+	defer s.Close()
+
+	c, err := NewHawkularClient(Parameters{Tenant: "cache", Url: s.URL})
+	assert.NoError(t, err)
+	dc := NewDefinitionCache(c, DefinitionCacheOptions{})
+	defer dc.Close()
+
+	created, err := dc.Create(MetricDefinition{Type: Gauge, ID: "test.create", Tags: map[string]string{"a": "b"}})
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&gets), "The stored definition should be fetched by Create")
+
+	md, err := dc.Definition(Gauge, "test.create")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, md.RetentionTime, "The definition stored by the server should be cached")
+	_, err = dc.Definition(Gauge, "test.create")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&gets))
+}
+
+func TestEnsuredExpires(t *testing.T) {
+	var creates int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/raw") {
+			atomic.AddInt32(&creates, 1)
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer s.Close()
+
+	c, err := NewHawkularClient(Parameters{Tenant: "cache", Url: s.URL})
+	assert.NoError(t, err)
+	dc := NewDefinitionCache(c, DefinitionCacheOptions{EnsureDefinitions: true, TTL: 50 * time.Millisecond})
+	defer dc.Close()
+
+	write := func(id string) {
+		assert.NoError(t, dc.Write([]MetricHeader{{Type: Gauge, ID: id, Data: []Datapoint{{Timestamp: time.Now(), Value: 1.0}}}}))
+	}
+	write("test.expires.1")
+	write("test.expires.1")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&creates))
+
+	// Expired entries are ensured again and the ones not written anymore are removed
+	time.Sleep(60 * time.Millisecond)
+	write("test.expires.2")
+	assert.Equal(t, 1, dc.CacheStats().Ensured)
+	write("test.expires.1")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&creates))
+}
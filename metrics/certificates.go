@@ -0,0 +1,282 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultCertReloadInterval = time.Duration(time.Minute)
+
+// CertificateStatus describes the certificates loaded from the files
+type CertificateStatus struct {
+	ClientNotAfter time.Time // Expiry of the client certificate, zero without one
+	CANotAfter     time.Time // Earliest expiry in the CA bundle, zero without one
+	LoadedAt       time.Time // Last time the files were successfully loaded
+	UsedAt         time.Time // First connection made with the loaded certificates, zero until then
+	LastError      error     // Error of the latest reload attempt, the previous certificates are kept in use
+}
+
+// certificateReloader keeps the client certificate and CA bundle in sync with the files. The files are checked
+// for modifications at most once per interval by the client and when new connections are made. After a reload
+// the idle connections are closed, so that the next requests connect with the new certificates.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+	logger   Logger
+	onReload func() // Called after the certificates have been replaced
+
+	lock      sync.RWMutex
+	cert      *tls.Certificate
+	roots     *x509.CertPool
+	modTimes  map[string]time.Time
+	checkedAt time.Time
+	status    CertificateStatus
+}
+
+func newCertificateReloader(certFile, keyFile, caFile string, interval time.Duration, logger Logger) (*certificateReloader, error) {
+	if interval <= 0 {
+		interval = defaultCertReloadInterval
+	}
+	r := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		interval: interval,
+		logger:   logger,
+		modTimes: make(map[string]time.Time),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certificateReloader) files() []string {
+	f := make([]string, 0, 3)
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if name != "" {
+			f = append(f, name)
+		}
+	}
+	return f
+}
+
+// reload reads all the files, the currently used certificates are replaced only if everything could be loaded
+func (r *certificateReloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, name := range r.files() {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		modTimes[name] = fi.ModTime()
+	}
+
+	status := CertificateStatus{LoadedAt: time.Now()}
+
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			return err
+		}
+		c.Leaf = leaf
+		status.ClientNotAfter = leaf.NotAfter
+		cert = &c
+	}
+
+	var roots *x509.CertPool
+	if r.caFile != "" {
+		pemCerts, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		for block, rest := pem.Decode(pemCerts); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			ca, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return err
+			}
+			roots.AddCert(ca)
+			if status.CANotAfter.IsZero() || ca.NotAfter.Before(status.CANotAfter) {
+				status.CANotAfter = ca.NotAfter
+			}
+		}
+		if status.CANotAfter.IsZero() {
+			return fmt.Errorf("No certificates found from CA file %s", r.caFile)
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert = cert
+	r.roots = roots
+	r.modTimes = modTimes
+	r.checkedAt = status.LoadedAt
+	r.status = status
+	return nil
+}
+
+// maybeReload reloads the files if the check interval has passed and any of them has been modified
+func (r *certificateReloader) maybeReload() {
+	r.lock.Lock()
+	now := time.Now()
+	if now.Sub(r.checkedAt) < r.interval {
+		r.lock.Unlock()
+		return
+	}
+	r.checkedAt = now
+
+	modified := false
+	for _, name := range r.files() {
+		fi, err := os.Stat(name)
+		if err != nil || !fi.ModTime().Equal(r.modTimes[name]) {
+			modified = true
+			break
+		}
+	}
+	r.lock.Unlock()
+
+	if !modified {
+		return
+	}
+
+	if err := r.reload(); err != nil {
+		r.lock.Lock()
+		r.status.LastError = err
+		r.lock.Unlock()
+		r.logger.Warn("Hawkular client certificate reload failed", "error", err)
+		return
+	}
+	if r.onReload != nil {
+		r.onReload()
+	}
+}
+
+// used records the first connection made with the loaded certificates
+func (r *certificateReloader) used() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.status.UsedAt.IsZero() {
+		r.status.UsedAt = time.Now()
+	}
+}
+
+func (r *certificateReloader) Status() CertificateStatus {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.status
+}
+
+// tlsConfig returns a copy of the base configuration with the current certificates
+func (r *certificateReloader) tlsConfig(base *tls.Config) *tls.Config {
+	var cfg *tls.Config
+	if base != nil {
+		cfg = base.Clone()
+	} else {
+		cfg = &tls.Config{}
+	}
+
+	if r.certFile != "" {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			r.maybeReload()
+			r.lock.RLock()
+			defer r.lock.RUnlock()
+			return r.cert, nil
+		}
+	}
+
+	if r.caFile != "" {
+		r.lock.RLock()
+		cfg.RootCAs = r.roots
+		r.lock.RUnlock()
+	}
+	return cfg
+}
+
+// transport returns a transport whose new connections use the reloaded certificates. The RootCAs of a config
+// can not be swapped, so every connection is dialed with a fresh config and verified by crypto/tls.
+func (r *certificateReloader) transport(base *tls.Config) *http.Transport {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		TLSClientConfig: r.tlsConfig(base),
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			r.maybeReload()
+			cfg := r.tlsConfig(base)
+			if cfg.ServerName == "" {
+				host, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				cfg.ServerName = host
+			}
+			d := &tls.Dialer{NetDialer: dialer, Config: cfg}
+			conn, err := d.DialContext(ctx, network, addr)
+			if err == nil {
+				r.used()
+			}
+			return conn, err
+		},
+	}
+}
+
+// watchCertificates checks the certificate files once per reload interval, also when the kept-alive
+// connections do not need new handshakes
+func (c *Client) watchCertificates() {
+	ticker := time.NewTicker(c.certificates.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.certificates.maybeReload()
+		}
+	}
+}
+
+// CertificateStatus returns the expiry times of the certificates loaded from ClientCertFile and CAFile.
+// It returns nil if the client was not configured with certificate files.
+func (c *Client) CertificateStatus() *CertificateStatus {
+	if c.certificates == nil {
+		return nil
+	}
+	s := c.certificates.Status()
+	return &s
+}
@@ -0,0 +1,221 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func certificatePEM(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func writeClientCertificate(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, cn string, notAfter time.Time, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(certFile, certificatePEM(der), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+}
+
+func TestClientCertificateReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hawkular-certs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDer)
+	assert.NoError(t, err)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client-CN", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	s.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	s.StartTLS()
+	defer s.Close()
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+	assert.NoError(t, ioutil.WriteFile(caFile, certificatePEM(s.Certificate().Raw), 0600))
+
+	firstExpiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	writeClientCertificate(t, ca, caKey, "first", firstExpiry, certFile, keyFile)
+
+	p := Parameters{
+		Tenant:             "mtls",
+		Url:                s.URL,
+		ClientCertFile:     certFile,
+		ClientKeyFile:      keyFile,
+		CAFile:             caFile,
+		CertReloadInterval: time.Millisecond,
+	}
+	c, err := NewHawkularClient(p)
+	assert.NoError(t, err)
+
+	status := c.CertificateStatus()
+	assert.NotNil(t, status)
+	assert.True(t, firstExpiry.Equal(status.ClientNotAfter))
+	assert.True(t, s.Certificate().NotAfter.Equal(status.CANotAfter))
+
+	r, err := c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, "first", r.Header.Get("X-Client-CN"))
+
+	assert.False(t, c.CertificateStatus().UsedAt.IsZero())
+
+	// Rotate the certificate, the kept-alive connection is closed after the reload
+	secondExpiry := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	writeClientCertificate(t, ca, caKey, "second", secondExpiry, certFile, keyFile)
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, future, future))
+	assert.NoError(t, os.Chtimes(keyFile, future, future))
+	deadline := time.Now().Add(5 * time.Second)
+	for !secondExpiry.Equal(c.CertificateStatus().ClientNotAfter) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, secondExpiry.Equal(c.CertificateStatus().ClientNotAfter))
+
+	r, err = c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, "second", r.Header.Get("X-Client-CN"))
+	status = c.CertificateStatus()
+	assert.False(t, status.UsedAt.Before(status.LoadedAt), "The reloaded certificate should be in use")
+
+	// Server certificates not in the CA bundle are rejected
+	otherCAFile := filepath.Join(dir, "other-ca.crt")
+	assert.NoError(t, ioutil.WriteFile(otherCAFile, certificatePEM(caDer), 0600))
+	p.CAFile = otherCAFile
+	c, err = NewHawkularClient(p)
+	assert.NoError(t, err)
+	_, err = c.Send(c.URL("GET"))
+	assert.Error(t, err)
+
+	p.ClientKeyFile = ""
+	_, err = NewHawkularClient(p)
+	assert.Error(t, err)
+}
+
+func TestCAFileVerifiesIPAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hawkular-certs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDer)
+	assert.NoError(t, err)
+	caFile := filepath.Join(dir, "ca.crt")
+	assert.NoError(t, ioutil.WriteFile(caFile, certificatePEM(caDer), 0600))
+
+	serve := func(tmpl *x509.Certificate) *httptest.Server {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		assert.NoError(t, err)
+		s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		s.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+		s.StartTLS()
+		return s
+	}
+	leaf := func(ips ...net.IP) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      pkix.Name{CommonName: "hawkular.example"},
+			DNSNames:     []string{"hawkular.example"},
+			IPAddresses:  ips,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+	}
+
+	// The certificate has no SAN for the IP address of the URL
+	s := serve(leaf())
+	defer s.Close()
+	c, err := NewHawkularClient(Parameters{Tenant: "tls", Url: s.URL, CAFile: caFile})
+	assert.NoError(t, err)
+	_, err = c.Send(c.URL("GET"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "127.0.0.1")
+
+	s = serve(leaf(net.ParseIP("127.0.0.1")))
+	defer s.Close()
+	c, err = NewHawkularClient(Parameters{Tenant: "tls", Url: s.URL, CAFile: caFile})
+	assert.NoError(t, err)
+	_, err = c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+}
@@ -19,15 +19,19 @@ package metrics
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -35,32 +39,71 @@ func (c *HawkularClientError) Error() string {
 	return fmt.Sprintf("Hawkular returned status code %d, error message: %s", c.Code, c.msg)
 }
 
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("Client closed before %d request(s) finished", e.Abandoned)
+}
+
+// ErrClientClosed is returned for requests sent after Close or abandoned by it
+var ErrClientClosed = errors.New("Client is closed")
+
 // Client creation and instance config
 
 const (
-	baseURL            string        = "hawkular/metrics"
-	defaultConcurrency int           = 1
-	timeout            time.Duration = time.Duration(30 * time.Second)
-	tenantHeader       string        = "Hawkular-Tenant"
-	adminHeader        string        = "Hawkular-Admin-Token"
+	baseURL             string        = "hawkular/metrics"
+	defaultConcurrency  int           = 1
+	timeout             time.Duration = time.Duration(30 * time.Second)
+	defaultRetryBackoff time.Duration = time.Duration(100 * time.Millisecond)
+	tenantHeader        string        = "Hawkular-Tenant"
+	adminHeader         string        = "Hawkular-Admin-Token"
 )
 
+// tenantModifier is the Modifier of Tenant. Its method values are recognized by modifierTenant,
+// so the tenant is found without applying the other modifiers.
+type tenantModifier string
+
+func (t tenantModifier) modify(r *http.Request) error {
+	r.Header.Set(tenantHeader, string(t))
+	return nil
+}
+
 // Tenant function replaces the Tenant in the request (instead of using the default in Client parameters)
 func Tenant(tenant string) Modifier {
+	return tenantModifier(tenant).modify
+}
+
+// AdminAuthentication function to add metrics' admin token to the request
+func AdminAuthentication(token string) Modifier {
 	return func(r *http.Request) error {
-		r.Header.Set(tenantHeader, tenant)
+		r.Header.Set(adminHeader, token)
 		return nil
 	}
 }
 
-// AdminAuthentication function to add metrics' admin token to the request
-func AdminAuthentication(token string) Modifier {
+type authOverrideKey struct{}
+
+// BearerToken replaces the client's authentication with the given token for this request
+func BearerToken(token string) Modifier {
+	return func(r *http.Request) error {
+		r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		*r = *r.WithContext(context.WithValue(r.Context(), authOverrideKey{}, true))
+		return nil
+	}
+}
+
+// BasicAuthentication replaces the client's authentication with the given credentials for this request
+func BasicAuthentication(username, password string) Modifier {
 	return func(r *http.Request) error {
-		r.Header.Add(adminHeader, token)
+		creds := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%v:%v", username, password)))
+		r.Header.Set("Authorization", fmt.Sprintf("Basic %s", creds))
+		*r = *r.WithContext(context.WithValue(r.Context(), authOverrideKey{}, true))
 		return nil
 	}
 }
 
+func authOverridden(r *http.Request) bool {
+	return r != nil && r.Context().Value(authOverrideKey{}) != nil
+}
+
 // Data adds payload to the request
 func Data(data interface{}) Modifier {
 	return func(r *http.Request) error {
@@ -76,6 +119,11 @@ func Data(data interface{}) Modifier {
 		if b != nil {
 			r.ContentLength = int64(b.Len())
 		}
+
+		// Allows resending the request to another server
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(jsonb)), nil
+		}
 		return nil
 	}
 }
@@ -133,6 +181,18 @@ func IdFilter(regexp string) Filter {
 	return Param("id", regexp)
 }
 
+// MetricsFilter is a query parameter to select the metrics by id in the stats queries
+func MetricsFilter(ids []string) Filter {
+	return func(r *http.Request) {
+		q := r.URL.Query()
+		q.Del("metrics")
+		for _, id := range ids {
+			q.Add("metrics", id)
+		}
+		r.URL.RawQuery = q.Encode()
+	}
+}
+
 // StartTimeFilter is a query parameter to filter with start time
 func StartTimeFilter(startTime time.Time) Filter {
 	return Param("start", strconv.FormatInt(ToUnixMilli(startTime), 10))
@@ -194,15 +254,16 @@ func (c *Client) createRequest() *http.Request {
 		Header:     make(http.Header),
 		Host:       c.url.Host,
 	}
+	req = req.WithContext(c.ctx)
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add(tenantHeader, c.Tenant)
 
 	if len(c.Credentials) > 0 {
-		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", c.Credentials))
+		req.Header.Set("Authorization", fmt.Sprintf("Basic %s", c.Credentials))
 	}
 
 	if len(c.Token) > 0 {
-		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.Token))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Token))
 	}
 
 	return req
@@ -211,24 +272,206 @@ func (c *Client) createRequest() *http.Request {
 // Send sends a constructed request to the Hawkular-Metrics server.
 // All the requests are pooled and limited by set concurrency limits
 func (c *Client) Send(o ...Modifier) (*http.Response, error) {
+	c.closeLock.RLock()
+	if c.closed {
+		c.closeLock.RUnlock()
+		return nil, ErrClientClosed
+	}
+	c.inflight.Add(1)
+	c.closeLock.RUnlock()
+	defer c.inflight.Done()
+
+	c.stats.started()
+	defer c.stats.finished()
+
+	resp, err := c.send(o...)
+	if err != nil && c.ctx.Err() != nil {
+		// Abandoned by Close
+		atomic.AddInt64(&c.abandoned, 1)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, ErrClientClosed
+	}
+	return resp, err
+}
+
+func (c *Client) send(o ...Modifier) (*http.Response, error) {
+	admitted := false
+	o = append(o[:len(o):len(o)], rateLimited(&admitted))
+
+	backoff := c.retryBackoff
+	for attempt := 0; ; attempt++ {
+		r, resp, err := c.sendAuthenticated(o...)
+		if attempt >= c.maxRetries || !retryable(resp, err) || !idempotent(r) || c.ctx.Err() != nil {
+			return resp, err
+		}
+
+		if err != nil {
+			c.logger.Warn("Retrying Hawkular request", "attempt", attempt+1, "error", err)
+		} else {
+			c.logger.Warn("Retrying Hawkular request", "attempt", attempt+1, "url", requestURL(resp.Request), "status", resp.StatusCode)
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-c.ctx.Done():
+			t.Stop()
+			return nil, c.ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// retryable returns true for connection errors and server side failures
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		_, ok := err.(*url.Error)
+		return ok
+	}
+	return resp.StatusCode >= 500
+}
+
+// idempotent returns true for the requests that are safe to send again. Datapoint writes are
+// idempotent as the server overwrites the datapoints with the same timestamp, but the other
+// creations and deletions fail or act twice if the first attempt reached the server.
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD", "PUT":
+		return true
+	case "POST":
+		return requestCommand(r).name == "Write"
+	}
+	return false
+}
+
+func (c *Client) sendAuthenticated(o ...Modifier) (*http.Request, *http.Response, error) {
+	r, resp, err := c.sendOnce(o...)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || c.tokenSource == nil || authOverridden(resp.Request) {
+		return r, resp, err
+	}
+
+	// The token might have been rotated, retry once with a fresh one
+	if rerr := c.refreshToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")); rerr != nil {
+		c.logger.Warn("Hawkular token refresh failed", "error", rerr)
+		return r, resp, err
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.logger.Warn("Retrying Hawkular request after token refresh", "url", requestURL(resp.Request))
+
+	return c.sendOnce(o...)
+}
+
+// refreshToken refreshes the rejected token, unless a concurrent request already replaced it
+func (c *Client) refreshToken(rejected string) error {
+	c.refreshLock.Lock()
+	defer c.refreshLock.Unlock()
+
+	if current, err := c.tokenSource.Token(); err == nil && current != rejected {
+		return nil
+	}
+	return c.tokenSource.Refresh()
+}
+
+// sendOnce builds the request with the modifiers and dispatches it. The built request is returned
+// even if it could not be sent.
+func (c *Client) sendOnce(o ...Modifier) (*http.Request, *http.Response, error) {
 	// Initialize
 	r := c.createRequest()
 
+	if c.tokenSource != nil {
+		token, err := c.tokenSource.Token()
+		if err != nil {
+			return r, nil, err
+		}
+		r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+
 	// Run all the modifiers
 	for _, f := range o {
 		err := f(r)
 		if err != nil {
+			return r, nil, err
+		}
+	}
+
+	if c.tracer == nil {
+		resp, err := c.dispatch(r)
+		return r, resp, err
+	}
+
+	span := c.startSpan(r)
+	resp, err := c.dispatch(r)
+	endSpan(span, resp, err)
+	return r, resp, err
+}
+
+// dispatch queues the request to the worker pool and waits for the response. Rate limiter tokens
+// are only taken for requests the circuit breaker lets through.
+func (c *Client) dispatch(r *http.Request) (*http.Response, error) {
+	probe := false
+	if c.breaker != nil {
+		var err error
+		if probe, err = c.breaker.allow(); err != nil {
 			return nil, err
 		}
 	}
 
-	rChan := make(chan *poolResponse)
-	preq := &poolRequest{r, rChan}
+	if admitted := limiterAdmission(r); c.limiter != nil && !*admitted {
+		if err := c.limiter.acquire(r.Context(), r.Header.Get(tenantHeader), requestDatapoints(r)); err != nil {
+			if c.breaker != nil {
+				c.breaker.release(probe)
+			}
+			if _, ok := err.(*RateLimitError); ok {
+				c.logger.Warn("Hawkular request rejected by rate limiter", "url", requestURL(r), "tenant", r.Header.Get(tenantHeader))
+			}
+			return nil, err
+		}
+		*admitted = true
+	}
+
+	var resp *http.Response
+	var err error
+	if c.endpoints != nil {
+		resp, err = c.failover(r)
+	} else {
+		resp, err = c.pooled(r)
+	}
 
-	c.pool <- preq
+	if c.breaker != nil && c.ctx.Err() == nil {
+		c.breaker.record(probe, requestSucceeded(resp, err))
+	}
+
+	return resp, err
+}
 
-	presp := <-rChan
-	close(rChan)
+// pooled sends the request through the worker pool
+func (c *Client) pooled(r *http.Request) (*http.Response, error) {
+	rChan := make(chan *poolResponse, 1)
+	preq := &poolRequest{r, rChan}
+
+	start := time.Now()
+	c.checkSaturation(c.pool.push(requestPriority(r), preq))
+
+	var presp *poolResponse
+	select {
+	case presp = <-rChan:
+	case <-r.Context().Done():
+		// The queued request is dropped by the workers, a response that raced with the cancellation is discarded
+		go func() {
+			if late := <-rChan; late.resp != nil {
+				late.resp.Body.Close()
+			}
+		}()
+		presp = &poolResponse{err: r.Context().Err()}
+	}
+	d := time.Since(start)
+	c.stats.observe(r, presp.resp, presp.err, d)
+	c.logRequest(r, presp.resp, presp.err, d)
 
 	return presp.resp, presp.err
 }
@@ -237,7 +480,7 @@ func (c *Client) Send(o ...Modifier) (*http.Response, error) {
 
 // Tenants returns a list of tenants from the server
 func (c *Client) Tenants(o ...Modifier) ([]*TenantDefinition, error) {
-	o = prepend(o, c.URL("GET", TenantEndpoint()), AdminAuthentication(c.AdminToken))
+	o = prepend(o, command("Tenants", ""), c.URL("GET", TenantEndpoint()), AdminAuthentication(c.AdminToken))
 
 	r, err := c.Send(o...)
 	if err != nil {
@@ -267,7 +510,7 @@ func (c *Client) Tenants(o ...Modifier) ([]*TenantDefinition, error) {
 
 // CreateTenant creates a tenant definition on the server
 func (c *Client) CreateTenant(tenant TenantDefinition, o ...Modifier) (bool, error) {
-	o = prepend(o, c.URL("POST", TenantEndpoint()), AdminAuthentication(c.AdminToken), Data(tenant))
+	o = prepend(o, command("CreateTenant", ""), c.URL("POST", TenantEndpoint()), AdminAuthentication(c.AdminToken), Data(tenant))
 
 	r, err := c.Send(o...)
 	if err != nil {
@@ -292,7 +535,7 @@ func (c *Client) CreateTenant(tenant TenantDefinition, o ...Modifier) (bool, err
 // Create creates a new metric definition
 func (c *Client) Create(md MetricDefinition, o ...Modifier) (bool, error) {
 	// Keep the order, add custom prepend
-	o = prepend(o, c.URL("POST", TypeEndpoint(md.Type)), Data(md))
+	o = prepend(o, command("Create", md.Type), c.URL("POST", TypeEndpoint(md.Type)), Data(md))
 
 	r, err := c.Send(o...)
 	if err != nil {
@@ -316,7 +559,7 @@ func (c *Client) Create(md MetricDefinition, o ...Modifier) (bool, error) {
 
 // AllDefinitions fetches all metric definitions (for every tenant) from the server. Requires admin/service rights
 func (c *Client) AllDefinitions(o ...Modifier) ([]*MetricDefinition, error) {
-	o = prepend(o, c.URL("GET", OpenshiftEndpoint()), AdminAuthentication(c.AdminToken))
+	o = prepend(o, command("AllDefinitions", ""), c.URL("GET", OpenshiftEndpoint()), AdminAuthentication(c.AdminToken))
 
 	r, err := c.Send(o...)
 	if err != nil {
@@ -346,7 +589,7 @@ func (c *Client) AllDefinitions(o ...Modifier) ([]*MetricDefinition, error) {
 
 // Definitions fetches metric definitions from the server
 func (c *Client) Definitions(o ...Modifier) ([]*MetricDefinition, error) {
-	o = prepend(o, c.URL("GET", TypeEndpoint(Generic)))
+	o = prepend(o, command("Definitions", ""), c.URL("GET", TypeEndpoint(Generic)))
 
 	r, err := c.Send(o...)
 	if err != nil {
@@ -376,7 +619,7 @@ func (c *Client) Definitions(o ...Modifier) ([]*MetricDefinition, error) {
 
 // Definition returns a single metric definition
 func (c *Client) Definition(t MetricType, id string, o ...Modifier) (*MetricDefinition, error) {
-	o = prepend(o, c.URL("GET", TypeEndpoint(t), SingleMetricEndpoint(id)))
+	o = prepend(o, command("Definition", t), c.URL("GET", TypeEndpoint(t), SingleMetricEndpoint(id)))
 
 	r, err := c.Send(o...)
 	if err != nil {
@@ -406,7 +649,7 @@ func (c *Client) Definition(t MetricType, id string, o ...Modifier) (*MetricDefi
 
 // TagValues queries for available tagValues
 func (c *Client) TagValues(tagQuery map[string]string, o ...Modifier) (map[string][]string, error) {
-	o = prepend(o, c.URL("GET", TypeEndpoint(Generic), TagEndpoint(), TagsEndpoint(tagQuery)))
+	o = prepend(o, command("TagValues", ""), c.URL("GET", TypeEndpoint(Generic), TagEndpoint(), TagsEndpoint(tagQuery)))
 
 	r, err := c.Send(o...)
 	if err != nil {
@@ -436,7 +679,7 @@ func (c *Client) TagValues(tagQuery map[string]string, o ...Modifier) (map[strin
 
 // UpdateTags modifies the tags of a metric definition
 func (c *Client) UpdateTags(t MetricType, id string, tags map[string]string, o ...Modifier) error {
-	o = prepend(o, c.URL("PUT", TypeEndpoint(t), SingleMetricEndpoint(id), TagEndpoint()), Data(tags))
+	o = prepend(o, command("UpdateTags", t), c.URL("PUT", TypeEndpoint(t), SingleMetricEndpoint(id), TagEndpoint()), Data(tags))
 
 	r, err := c.Send(o...)
 	if err != nil {
@@ -454,7 +697,7 @@ func (c *Client) UpdateTags(t MetricType, id string, tags map[string]string, o .
 
 // DeleteTags deletes given tags from the definition
 func (c *Client) DeleteTags(t MetricType, id string, tags []string, o ...Modifier) error {
-	o = prepend(o, c.URL("DELETE", TypeEndpoint(t), SingleMetricEndpoint(id), TagEndpoint(), TagNamesEndpoint(tags)))
+	o = prepend(o, command("DeleteTags", t), c.URL("DELETE", TypeEndpoint(t), SingleMetricEndpoint(id), TagEndpoint(), TagNamesEndpoint(tags)))
 
 	r, err := c.Send(o...)
 	if err != nil {
@@ -472,7 +715,7 @@ func (c *Client) DeleteTags(t MetricType, id string, tags []string, o ...Modifie
 
 // Tags fetches metric definition's tags
 func (c *Client) Tags(t MetricType, id string, o ...Modifier) (map[string]string, error) {
-	o = prepend(o, c.URL("GET", TypeEndpoint(t), SingleMetricEndpoint(id), TagEndpoint()))
+	o = prepend(o, command("Tags", t), c.URL("GET", TypeEndpoint(t), SingleMetricEndpoint(id), TagEndpoint()))
 
 	r, err := c.Send(o...)
 	if err != nil {
@@ -500,6 +743,54 @@ func (c *Client) Tags(t MetricType, id string, o ...Modifier) (map[string]string
 	return nil, nil
 }
 
+// Delete deletes a metric definition and its datapoints
+func (c *Client) Delete(t MetricType, id string, o ...Modifier) error {
+	o = prepend(o, command("Delete", t), c.URL("DELETE", TypeEndpoint(t), SingleMetricEndpoint(id)))
+
+	r, err := c.Send(o...)
+	if err != nil {
+		return err
+	}
+
+	defer r.Body.Close()
+
+	if r.StatusCode > 399 {
+		return c.parseErrorResponse(r)
+	}
+
+	return nil
+}
+
+// Status fetches the status and version information of the server
+func (c *Client) Status(o ...Modifier) (map[string]interface{}, error) {
+	o = prepend(o, command("Status", ""), c.URL("GET", StatusEndpoint()))
+
+	r, err := c.Send(o...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Body.Close()
+
+	if r.StatusCode == http.StatusOK {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		status := make(map[string]interface{})
+		if len(b) > 0 {
+			if err = json.Unmarshal(b, &status); err != nil {
+				return nil, err
+			}
+		}
+		return status, nil
+	} else if r.StatusCode > 399 {
+		return nil, c.parseErrorResponse(r)
+	}
+
+	return nil, nil
+}
+
 // Write writes datapoints to the server
 func (c *Client) Write(metrics []MetricHeader, o ...Modifier) error {
 	if len(metrics) > 0 {
@@ -521,7 +812,7 @@ func (c *Client) Write(metrics []MetricHeader, o ...Modifier) error {
 
 				// Should be sorted and splitted by type & tenant..
 				on := o
-				on = prepend(on, c.URL("POST", TypeEndpoint(k), RawEndpoint()), Data(v))
+				on = prepend(on, command("Write", k), c.URL("POST", TypeEndpoint(k), RawEndpoint()), Data(v), datapointCount(countDatapoints(v)))
 
 				r, err := c.Send(on...)
 				if err != nil {
@@ -537,23 +828,25 @@ func (c *Client) Write(metrics []MetricHeader, o ...Modifier) error {
 			}(k, v)
 		}
 		wg.Wait()
-		select {
-		case err, ok := <-errorsChan:
-			if ok {
-				return err
+		close(errorsChan)
+
+		// Only the first error is returned, the rest would be lost without logging
+		var err error
+		for e := range errorsChan {
+			if err == nil {
+				err = e
+				continue
 			}
-			// If channel is closed, we're done
-		default:
-			// Nothing to do
+			c.logger.Warn("Hawkular write error dropped", "error", e)
 		}
-
+		return err
 	}
 	return nil
 }
 
 // ReadRaw reads metric datapoints from the server for the given metric
 func (c *Client) ReadRaw(t MetricType, id string, o ...Modifier) ([]*Datapoint, error) {
-	o = prepend(o, c.URL("GET", TypeEndpoint(t), SingleMetricEndpoint(id), RawEndpoint()))
+	o = prepend(o, command("ReadRaw", t), c.URL("GET", TypeEndpoint(t), SingleMetricEndpoint(id), RawEndpoint()))
 
 	r, err := c.Send(o...)
 	if err != nil {
@@ -584,7 +877,7 @@ func (c *Client) ReadRaw(t MetricType, id string, o ...Modifier) ([]*Datapoint,
 
 // ReadBuckets reads datapoints from the server, aggregated to buckets with given parameters.
 func (c *Client) ReadBuckets(t MetricType, o ...Modifier) ([]*Bucketpoint, error) {
-	o = prepend(o, c.URL("GET", TypeEndpoint(t), StatsEndpoint()))
+	o = prepend(o, command("ReadBuckets", t), c.URL("GET", TypeEndpoint(t), StatsEndpoint()))
 
 	r, err := c.Send(o...)
 	if err != nil {
@@ -616,34 +909,50 @@ func (c *Client) ReadBuckets(t MetricType, o ...Modifier) ([]*Bucketpoint, error
 
 // NewHawkularClient returns a new initialized instance of client
 func NewHawkularClient(p Parameters) (*Client, error) {
-	uri, err := url.Parse(p.Url)
-	if err != nil {
-		return nil, err
+	if errs := p.validate(); len(errs) > 0 {
+		return nil, errs[0]
 	}
 
-	if (p.Username != "" && p.Password == "") || (p.Username == "" && p.Password != "") {
-		return nil, fmt.Errorf("To configure credentials, you must specify both Username and Password")
+	var endpoints *endpointPool
+	if len(p.Urls) > 0 {
+		var err error
+		if endpoints, err = newEndpointPool(p.Urls, p.EndpointPolicy); err != nil {
+			return nil, err
+		}
+		p.Url = p.Urls[0]
 	}
 
-	if (p.Username != "" && p.Password != "") && (p.Token != "") {
-		return nil, fmt.Errorf("You cannot specify both Username/Password credentials and a Token.")
+	u, err := parseBaseURL(p.Url)
+	if err != nil {
+		return nil, err
 	}
 
-	if uri.Path == "" {
-		uri.Path = baseURL
+	if p.Logger == nil {
+		p.Logger = noopLogger{}
 	}
 
-	u := &url.URL{
-		Host:   uri.Host,
-		Path:   uri.Path,
-		Scheme: uri.Scheme,
-		Opaque: fmt.Sprintf("/%s", uri.Path),
+	if p.Timeout <= 0 {
+		p.Timeout = timeout
+	}
+
+	if p.RetryBackoff <= 0 {
+		p.RetryBackoff = defaultRetryBackoff
 	}
 
 	c := &http.Client{
-		Timeout: timeout,
+		Timeout: p.Timeout,
 	}
-	if p.TLSConfig != nil {
+
+	var certs *certificateReloader
+	if p.ClientCertFile != "" || p.ClientKeyFile != "" || p.CAFile != "" {
+		certs, err = newCertificateReloader(p.ClientCertFile, p.ClientKeyFile, p.CAFile, p.CertReloadInterval, p.Logger)
+		if err != nil {
+			return nil, err
+		}
+		transport := certs.transport(p.TLSConfig)
+		certs.onReload = transport.CloseIdleConnections
+		c.Transport = transport
+	} else if p.TLSConfig != nil {
 		transport := &http.Transport{TLSClientConfig: p.TLSConfig}
 		c.Transport = transport
 	}
@@ -657,26 +966,99 @@ func NewHawkularClient(p Parameters) (*Client, error) {
 		p.Concurrency = 1
 	}
 
+	if p.CloseTimeout <= 0 {
+		p.CloseTimeout = timeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
 	client := &Client{
-		url:         u,
-		Tenant:      p.Tenant,
-		Credentials: creds,
-		Token:       p.Token,
-		AdminToken:  p.AdminToken,
-		client:      c,
-		pool:        make(chan *poolRequest, p.Concurrency),
+		url:          u,
+		Tenant:       p.Tenant,
+		Credentials:  creds,
+		Token:        p.Token,
+		AdminToken:   p.AdminToken,
+		client:       c,
+		pool:         newRequestQueue(p.PriorityWeights, p.Concurrency),
+		stats:        newClientStats(),
+		tokenSource:  p.TokenSource,
+		certificates: certs,
+		tracer:       p.Tracer,
+		logger:       p.Logger,
+		saturation:   saturationFactor * p.Concurrency,
+		limiter:      newRateLimiter(p.RateLimit, p.TenantRateLimit, p.RateLimitPolicy),
+		breaker:      newCircuitBreaker(p.CircuitBreaker),
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+		closeTimeout: p.CloseTimeout,
+		endpoints:    endpoints,
+		maxRetries:   p.MaxRetries,
+		retryBackoff: p.RetryBackoff,
+	}
+
+	if client.breaker != nil {
+		client.breaker.logger = p.Logger
 	}
 
 	for i := 0; i < p.Concurrency; i++ {
 		go client.sendRoutine()
 	}
 
+	if certs != nil {
+		go client.watchCertificates()
+	}
+
+	if endpoints != nil {
+		if p.HealthCheckInterval <= 0 {
+			p.HealthCheckInterval = defaultHealthCheckInterval
+		}
+		go client.healthCheck(p.HealthCheckInterval)
+	}
+
 	return client, nil
 }
 
-// Close safely closes the Hawkular-Metrics client and flushes remaining writes to the server
-func (c *Client) Close() {
-	close(c.pool)
+// Close stops accepting new requests and waits for the queued and in-flight requests to finish.
+// Requests still running after Parameters.CloseTimeout are abandoned and reported in the returned ShutdownError
+func (c *Client) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.closeTimeout)
+	defer cancel()
+	return c.Shutdown(ctx)
+}
+
+// Shutdown is like Close, but waits for the requests until the given context is done
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.closeLock.Lock()
+	if c.closed {
+		c.closeLock.Unlock()
+		return ErrClientClosed
+	}
+	c.closed = true
+	c.closeLock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(drained)
+	}()
+
+	close(c.done)
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		// Cancel everything that is still queued or running. The request context is not cancelled
+		// after a clean drain, the callers may still be reading the response bodies.
+		c.cancel()
+		<-drained
+	}
+	c.pool.close()
+
+	if abandoned := atomic.LoadInt64(&c.abandoned); abandoned > 0 {
+		return &ShutdownError{Abandoned: int(abandoned)}
+	}
+	return nil
 }
 
 // HTTP Helper functions
@@ -791,6 +1173,13 @@ func StatsEndpoint() Endpoint {
 	}
 }
 
+// StatusEndpoint is an endpoint to read the server status
+func StatusEndpoint() Endpoint {
+	return func(u *url.URL) {
+		addToURL(u, "status")
+	}
+}
+
 func addToURL(u *url.URL, s string) *url.URL {
 	u.Opaque = fmt.Sprintf("%s/%s", u.Opaque, s)
 	return u
@@ -363,6 +363,41 @@ func TestBasicAuthenticationWithSSL(t *testing.T) {
 	assert.Equal(t, fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%v:%v", p.Username, p.Password)))), r.Header.Get("X-Authorization"))
 }
 
+func TestAuthenticationOverride(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Authorization-Count", fmt.Sprintf("%d", len(r.Header["Authorization"])))
+		w.Header().Set("X-Authorization", r.Header.Get("Authorization"))
+		w.Header().Set("X-Admin-Count", fmt.Sprintf("%d", len(r.Header[adminHeader])))
+		w.Header().Set("X-Admin", r.Header.Get(adminHeader))
+	}))
+	defer s.Close()
+
+	p := Parameters{
+		Tenant:     "gateway",
+		Url:        s.URL,
+		Token:      "service-token",
+		AdminToken: "admin-secret",
+	}
+
+	c, err := NewHawkularClient(p)
+	assert.NoError(t, err)
+
+	r, err := c.Send(c.URL("GET"), BearerToken("user-token"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", r.Header.Get("X-Authorization-Count"))
+	assert.Equal(t, "Bearer user-token", r.Header.Get("X-Authorization"))
+
+	r, err = c.Send(c.URL("GET"), BasicAuthentication("user", "pass"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", r.Header.Get("X-Authorization-Count"))
+	assert.Equal(t, fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte("user:pass"))), r.Header.Get("X-Authorization"))
+
+	r, err = c.Send(c.URL("GET"), AdminAuthentication(c.AdminToken), AdminAuthentication("other-admin"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", r.Header.Get("X-Admin-Count"))
+	assert.Equal(t, "other-admin", r.Header.Get("X-Admin"))
+}
+
 func TestInvalidBasicAuthentication(t *testing.T) {
 	tC := &tls.Config{InsecureSkipVerify: true}
 
@@ -553,6 +588,68 @@ func TestDatapointMarshal(t *testing.T) {
 	assert.Equal(t, d.Timestamp.Unix(), ud.Timestamp.Unix())
 }
 
+func TestBucketpointMarshal(t *testing.T) {
+	b := Bucketpoint{
+		Start:   FromUnixMilli(1500000000000),
+		End:     FromUnixMilli(1500000060000),
+		Min:     1,
+		Max:     3,
+		Avg:     2,
+		Samples: 3,
+	}
+
+	j, err := json.Marshal(b)
+	assert.NoError(t, err)
+	assert.Contains(t, string(j), `"start":1500000000000`)
+	assert.Contains(t, string(j), `"end":1500000060000`)
+
+	ub := Bucketpoint{}
+	assert.NoError(t, json.Unmarshal(j, &ub))
+	assert.True(t, b.Start.Equal(ub.Start))
+	assert.True(t, b.End.Equal(ub.End))
+	assert.Equal(t, b.Samples, ub.Samples)
+}
+
+func TestMetricsFilter(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://localhost/hawkular/metrics/gauges/stats?metrics=old&buckets=1", nil)
+	assert.NoError(t, err)
+	Filters(MetricsFilter([]string{"a", "b c"}))(r)
+	assert.Equal(t, []string{"a", "b c"}, r.URL.Query()["metrics"])
+	assert.Equal(t, "1", r.URL.Query().Get("buckets"))
+}
+
+func TestDeleteAndStatus(t *testing.T) {
+	var method, path string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		switch r.URL.Path {
+		case "/hawkular/metrics/status":
+			w.Write([]byte(`{"MetricsService":"STARTED","Implementation-Version":"0.28.0"}`))
+		case "/hawkular/metrics/gauges/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errorMsg":"Not found"}`))
+		}
+	}))
+	defer s.Close()
+
+	c, err := NewHawkularClient(Parameters{Tenant: "delete", Url: s.URL})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	assert.NoError(t, c.Delete(Gauge, "test.delete"))
+	assert.Equal(t, "DELETE", method)
+	assert.Equal(t, "/hawkular/metrics/gauges/test.delete", path)
+
+	err = c.Delete(Gauge, "missing")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, err.(*HawkularClientError).Code)
+
+	status, err := c.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, "STARTED", status["MetricsService"])
+	assert.Equal(t, "/hawkular/metrics/status", path)
+}
+
 func getMetrics(prefix int) []MetricHeader {
 	points := 10
 	metrics := 100000
@@ -626,3 +723,8 @@ func BenchmarkHawkular(b *testing.B) {
 		wg.Wait()
 	}
 }
+
+func TestTaggedID(t *testing.T) {
+	assert.Equal(t, "cpu", TaggedID("cpu", nil))
+	assert.Equal(t, "cpu;dc=east;host=a", TaggedID("cpu", map[string]string{"host": "a", "dc": "east"}))
+}
@@ -0,0 +1,123 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCloseDrainsRequests(t *testing.T) {
+	var served int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&served, 1)
+	}))
+	defer s.Close()
+
+	c, err := NewHawkularClient(Parameters{Tenant: "close", Url: s.URL, Concurrency: 2})
+	assert.NoError(t, err)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := c.Send(c.URL("GET"))
+			assert.NoError(t, err)
+			r.Body.Close()
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, c.Close())
+	wg.Wait()
+	assert.Equal(t, int32(4), atomic.LoadInt32(&served), "Queued requests should have been processed")
+
+	_, err = c.Send(c.URL("GET"))
+	assert.Equal(t, ErrClientClosed, err)
+	assert.Equal(t, ErrClientClosed, c.Close())
+}
+
+func TestCloseKeepsResponseBodies(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("["))
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("]"))
+	}))
+	defer s.Close()
+
+	c, err := NewHawkularClient(Parameters{Tenant: "close", Url: s.URL})
+	assert.NoError(t, err)
+
+	r, err := c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	defer r.Body.Close()
+
+	// The request is no longer in flight, but its body is still being read
+	assert.NoError(t, c.Close())
+	b, err := ioutil.ReadAll(r.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", string(b))
+}
+
+func TestCloseAbandonsAfterDeadline(t *testing.T) {
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer s.Close()
+	defer close(release)
+
+	c, err := NewHawkularClient(Parameters{
+		Tenant:       "close",
+		Url:          s.URL,
+		CloseTimeout: 50 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := c.Send(c.URL("GET"))
+			errs <- err
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	err = c.Close()
+	assert.Error(t, err)
+	sErr, ok := err.(*ShutdownError)
+	assert.True(t, ok)
+	assert.Equal(t, 3, sErr.Abandoned)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, ErrClientClosed, <-errs)
+	}
+}
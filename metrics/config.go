@@ -0,0 +1,344 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Environment variables read by ParametersFromEnv and Config.LoadEnv
+const (
+	EnvURL                = "HAWKULAR_URL"
+	EnvTenant             = "HAWKULAR_TENANT"
+	EnvUsername           = "HAWKULAR_USERNAME"
+	EnvPassword           = "HAWKULAR_PASSWORD"
+	EnvToken              = "HAWKULAR_TOKEN"
+	EnvTokenFile          = "HAWKULAR_TOKEN_FILE"
+	EnvAdminToken         = "HAWKULAR_ADMIN_TOKEN"
+	EnvCAFile             = "HAWKULAR_CA_FILE"
+	EnvClientCertFile     = "HAWKULAR_CLIENT_CERT_FILE"
+	EnvClientKeyFile      = "HAWKULAR_CLIENT_KEY_FILE"
+	EnvInsecureSkipVerify = "HAWKULAR_INSECURE_SKIP_VERIFY"
+	EnvConcurrency        = "HAWKULAR_CONCURRENCY"
+	EnvTimeout            = "HAWKULAR_TIMEOUT"
+	EnvCloseTimeout       = "HAWKULAR_CLOSE_TIMEOUT"
+	EnvMaxRetries         = "HAWKULAR_MAX_RETRIES"
+	EnvRetryBackoff       = "HAWKULAR_RETRY_BACKOFF"
+)
+
+// ConfigError lists all the problems found from the configuration
+type ConfigError struct {
+	Errors []error
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("Invalid configuration: %s", strings.Join(msgs, "; "))
+}
+
+// Duration is a time.Duration which is written as a string, such as "30s", in the configuration files
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration from a JSON string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+// MarshalJSON writes the duration as a JSON string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalYAML parses the duration from a YAML string
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+// MarshalYAML writes the duration as a YAML string
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) parse(s string) error {
+	pd, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(pd)
+	return nil
+}
+
+// Config is the shared configuration file format of the client. Both YAML and JSON files are supported.
+type Config struct {
+	URL                string   `json:"url,omitempty" yaml:"url,omitempty"`
+	URLs               []string `json:"urls,omitempty" yaml:"urls,omitempty"`
+	Tenant             string   `json:"tenant,omitempty" yaml:"tenant,omitempty"`
+	Username           string   `json:"username,omitempty" yaml:"username,omitempty"`
+	Password           string   `json:"password,omitempty" yaml:"password,omitempty"`
+	Token              string   `json:"token,omitempty" yaml:"token,omitempty"`
+	TokenFile          string   `json:"tokenFile,omitempty" yaml:"tokenFile,omitempty"`
+	AdminToken         string   `json:"adminToken,omitempty" yaml:"adminToken,omitempty"`
+	CAFile             string   `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	ClientCertFile     string   `json:"clientCertFile,omitempty" yaml:"clientCertFile,omitempty"`
+	ClientKeyFile      string   `json:"clientKeyFile,omitempty" yaml:"clientKeyFile,omitempty"`
+	InsecureSkipVerify bool     `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+	Concurrency        int      `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	Timeout            Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	CloseTimeout       Duration `json:"closeTimeout,omitempty" yaml:"closeTimeout,omitempty"`
+	MaxRetries         int      `json:"maxRetries,omitempty" yaml:"maxRetries,omitempty"`
+	RetryBackoff       Duration `json:"retryBackoff,omitempty" yaml:"retryBackoff,omitempty"`
+}
+
+// LoadConfig reads the configuration from a YAML or JSON file. Files with .json extension are parsed as JSON.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		err = d.Decode(cfg)
+	} else {
+		err = yaml.UnmarshalStrict(b, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse configuration file %s: %s", path, err.Error())
+	}
+	return cfg, nil
+}
+
+// LoadEnv overrides the configuration with the set HAWKULAR_* environment variables
+func (cfg *Config) LoadEnv() error {
+	return cfg.LoadEnvFrom(os.LookupEnv)
+}
+
+// LoadEnvFrom is like LoadEnv, but reads the variables with the given lookup function, such as os.LookupEnv
+func (cfg *Config) LoadEnvFrom(lookup func(key string) (string, bool)) error {
+	errs := make([]error, 0)
+
+	str := func(name string, target *string) {
+		if v, found := lookup(name); found {
+			*target = v
+		}
+	}
+	integer := func(name string, target *int) {
+		if v, found := lookup(name); found {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s is not an integer: %s", name, v))
+				return
+			}
+			*target = i
+		}
+	}
+	duration := func(name string, target *Duration) {
+		if v, found := lookup(name); found {
+			if err := target.parse(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s is not a duration: %s", name, v))
+			}
+		}
+	}
+
+	if v, found := lookup(EnvURL); found {
+		cfg.URL = v
+		cfg.URLs = nil
+	}
+	str(EnvTenant, &cfg.Tenant)
+	str(EnvUsername, &cfg.Username)
+	str(EnvPassword, &cfg.Password)
+	str(EnvToken, &cfg.Token)
+	str(EnvTokenFile, &cfg.TokenFile)
+	str(EnvAdminToken, &cfg.AdminToken)
+	str(EnvCAFile, &cfg.CAFile)
+	str(EnvClientCertFile, &cfg.ClientCertFile)
+	str(EnvClientKeyFile, &cfg.ClientKeyFile)
+	integer(EnvConcurrency, &cfg.Concurrency)
+	duration(EnvTimeout, &cfg.Timeout)
+	duration(EnvCloseTimeout, &cfg.CloseTimeout)
+	integer(EnvMaxRetries, &cfg.MaxRetries)
+	duration(EnvRetryBackoff, &cfg.RetryBackoff)
+
+	if v, found := lookup(EnvInsecureSkipVerify); found {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s is not a boolean: %s", EnvInsecureSkipVerify, v))
+		} else {
+			cfg.InsecureSkipVerify = b
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ConfigError{Errors: errs}
+	}
+	return nil
+}
+
+func (cfg *Config) parameters() Parameters {
+	p := Parameters{
+		Url:            cfg.URL,
+		Urls:           cfg.URLs,
+		Tenant:         cfg.Tenant,
+		Username:       cfg.Username,
+		Password:       cfg.Password,
+		Token:          cfg.Token,
+		AdminToken:     cfg.AdminToken,
+		CAFile:         cfg.CAFile,
+		ClientCertFile: cfg.ClientCertFile,
+		ClientKeyFile:  cfg.ClientKeyFile,
+		Concurrency:    cfg.Concurrency,
+		Timeout:        time.Duration(cfg.Timeout),
+		CloseTimeout:   time.Duration(cfg.CloseTimeout),
+		MaxRetries:     cfg.MaxRetries,
+		RetryBackoff:   time.Duration(cfg.RetryBackoff),
+	}
+	if cfg.InsecureSkipVerify {
+		p.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return p
+}
+
+// Validate checks the configuration and returns a ConfigError listing every problem found
+func (cfg *Config) Validate() error {
+	errs := cfg.parameters().validate()
+
+	if cfg.URL == "" && len(cfg.URLs) == 0 {
+		errs = append(errs, fmt.Errorf("URL or URLs is required"))
+	}
+
+	// NewHawkularClient replaces these with the defaults, but in a configuration they are mistakes
+	if cfg.Concurrency < 0 {
+		errs = append(errs, fmt.Errorf("Concurrency cannot be negative"))
+	}
+	if cfg.Timeout < 0 || cfg.CloseTimeout < 0 || cfg.RetryBackoff < 0 {
+		errs = append(errs, fmt.Errorf("Timeouts cannot be negative"))
+	}
+	if cfg.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("MaxRetries cannot be negative"))
+	}
+
+	if cfg.TokenFile != "" {
+		if cfg.Token != "" || cfg.Username != "" {
+			errs = append(errs, fmt.Errorf("You cannot specify TokenFile together with a Token or Username/Password credentials."))
+		}
+		if _, err := os.Stat(cfg.TokenFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, f := range []string{cfg.CAFile, cfg.ClientCertFile, cfg.ClientKeyFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ConfigError{Errors: errs}
+	}
+	return nil
+}
+
+// Parameters validates the configuration and returns the matching client Parameters
+func (cfg *Config) Parameters() (Parameters, error) {
+	if err := cfg.Validate(); err != nil {
+		return Parameters{}, err
+	}
+
+	p := cfg.parameters()
+	if cfg.TokenFile != "" {
+		ts, err := NewFileTokenSource(cfg.TokenFile)
+		if err != nil {
+			return Parameters{}, err
+		}
+		p.TokenSource = ts
+	}
+	return p, nil
+}
+
+// ParametersFromEnv returns the client Parameters configured with the HAWKULAR_* environment variables
+func ParametersFromEnv() (Parameters, error) {
+	cfg := &Config{}
+	if err := cfg.LoadEnv(); err != nil {
+		return Parameters{}, err
+	}
+	return cfg.Parameters()
+}
+
+// validate returns all the problems found from the parameters, NewHawkularClient fails with the first one
+func (p Parameters) validate() []error {
+	errs := make([]error, 0)
+
+	if _, err := url.Parse(p.Url); err != nil {
+		errs = append(errs, err)
+	}
+
+	if p.Url != "" && len(p.Urls) > 0 {
+		errs = append(errs, fmt.Errorf("You cannot specify both Url and Urls"))
+	}
+
+	for _, u := range p.Urls {
+		if _, err := url.Parse(u); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if (p.Username != "" && p.Password == "") || (p.Username == "" && p.Password != "") {
+		errs = append(errs, fmt.Errorf("To configure credentials, you must specify both Username and Password"))
+	}
+
+	if (p.Username != "" && p.Password != "") && (p.Token != "") {
+		errs = append(errs, fmt.Errorf("You cannot specify both Username/Password credentials and a Token."))
+	}
+
+	if p.TokenSource != nil && (p.Token != "" || p.Username != "") {
+		errs = append(errs, fmt.Errorf("You cannot specify TokenSource together with a Token or Username/Password credentials."))
+	}
+
+	if (p.ClientCertFile == "") != (p.ClientKeyFile == "") {
+		errs = append(errs, fmt.Errorf("To configure a client certificate, you must specify both ClientCertFile and ClientKeyFile"))
+	}
+
+	return errs
+}
@@ -0,0 +1,168 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hawkular-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("secret"), 0600))
+
+	yamlFile := filepath.Join(dir, "hawkular.yaml")
+	assert.NoError(t, ioutil.WriteFile(yamlFile, []byte(`
+url: https://hawkular-metrics:8443
+tenant: ops
+tokenFile: `+tokenFile+`
+concurrency: 8
+timeout: 10s
+maxRetries: 3
+retryBackoff: 250ms
+`), 0600))
+
+	cfg, err := LoadConfig(yamlFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "ops", cfg.Tenant)
+	assert.Equal(t, Duration(10*time.Second), cfg.Timeout)
+
+	p, err := cfg.Parameters()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://hawkular-metrics:8443", p.Url)
+	assert.Equal(t, 8, p.Concurrency)
+	assert.Equal(t, 3, p.MaxRetries)
+	assert.Equal(t, 250*time.Millisecond, p.RetryBackoff)
+	token, err := p.TokenSource.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", token)
+
+	jsonFile := filepath.Join(dir, "hawkular.json")
+	assert.NoError(t, ioutil.WriteFile(jsonFile, []byte(`{"url": "http://localhost:8080", "tenant": "dev", "closeTimeout": "1m"}`), 0600))
+	cfg, err = LoadConfig(jsonFile)
+	assert.NoError(t, err)
+	assert.Equal(t, Duration(time.Minute), cfg.CloseTimeout)
+
+	assert.NoError(t, ioutil.WriteFile(yamlFile, []byte("urll: typo\n"), 0600))
+	_, err = LoadConfig(yamlFile)
+	assert.Error(t, err, "Unknown fields should be rejected")
+
+	assert.NoError(t, ioutil.WriteFile(jsonFile, []byte(`{"urll": "typo"}`), 0600))
+	_, err = LoadConfig(jsonFile)
+	assert.Error(t, err, "Unknown fields should be rejected")
+}
+
+func TestConfigEnvAndValidation(t *testing.T) {
+	os.Setenv(EnvURL, "http://localhost:8080")
+	os.Setenv(EnvTenant, "env-tenant")
+	os.Setenv(EnvUsername, "user")
+	os.Setenv(EnvPassword, "pass")
+	os.Setenv(EnvConcurrency, "4")
+	os.Setenv(EnvTimeout, "5s")
+	defer func() {
+		for _, e := range []string{EnvURL, EnvTenant, EnvUsername, EnvPassword, EnvConcurrency, EnvTimeout, EnvToken, EnvClientCertFile} {
+			os.Unsetenv(e)
+		}
+	}()
+
+	p, err := ParametersFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, "env-tenant", p.Tenant)
+	assert.Equal(t, 4, p.Concurrency)
+	assert.Equal(t, 5*time.Second, p.Timeout)
+
+	os.Setenv(EnvConcurrency, "many")
+	os.Setenv(EnvTimeout, "forever")
+	err = (&Config{}).LoadEnv()
+	assert.Error(t, err)
+	assert.Equal(t, 2, len(err.(*ConfigError).Errors), "Every invalid variable should be reported")
+
+	cfg := &Config{URL: "http://localhost:8080"}
+	assert.NoError(t, cfg.LoadEnvFrom(func(name string) (string, bool) {
+		if name == EnvTenant {
+			return "injected", true
+		}
+		return "", false
+	}))
+	assert.Equal(t, "injected", cfg.Tenant)
+	assert.Equal(t, "http://localhost:8080", cfg.URL, "Only the injected variables should be read")
+
+	cfg = &Config{
+		Username:       "user",
+		Token:          "token",
+		ClientCertFile: "/nonexistent/tls.crt",
+		MaxRetries:     -1,
+	}
+	err = cfg.Validate()
+	assert.Error(t, err)
+	cErr, ok := err.(*ConfigError)
+	assert.True(t, ok)
+	// Missing password, missing key file, negative retries, missing URL and missing cert file
+	assert.Equal(t, 5, len(cErr.Errors))
+
+	// The client parameters still fall back to the defaults
+	c, err := NewHawkularClient(Parameters{Tenant: "defaults", Url: "http://localhost", Concurrency: -1, MaxRetries: -1, Timeout: -time.Second})
+	assert.NoError(t, err)
+	c.Close()
+}
+
+func TestRetries(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer s.Close()
+
+	c, err := NewHawkularClient(Parameters{Tenant: "retry", Url: s.URL, MaxRetries: 2, RetryBackoff: time.Millisecond})
+	assert.NoError(t, err)
+
+	err = c.Write([]MetricHeader{{ID: "retried", Type: Gauge, Data: []Datapoint{{Value: 1.0, Timestamp: time.Now()}}}})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	c, err = NewHawkularClient(Parameters{Tenant: "retry", Url: s.URL})
+	assert.NoError(t, err)
+	atomic.StoreInt32(&requests, 0)
+	r, err := c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, r.StatusCode, "Retries are disabled by default")
+	c.Close()
+
+	// The definitions may have been created by the failed attempt
+	c, err = NewHawkularClient(Parameters{Tenant: "retry", Url: s.URL, MaxRetries: 2, RetryBackoff: time.Millisecond})
+	assert.NoError(t, err)
+	defer c.Close()
+	atomic.StoreInt32(&requests, 0)
+	_, err = c.Create(MetricDefinition{ID: "created", Type: Gauge})
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "Creations should not be retried")
+}
@@ -0,0 +1,266 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package csvio exports Hawkular-Metrics datapoints to CSV and imports them back.
+//
+// Two layouts are supported. The long layout has one datapoint per row:
+//
+//	metric,timestamp,value
+//	cpu,2017-07-01T12:00:00Z,0.5
+//
+// and may also have type and tags columns. The tags column holds the datapoint tags as k=v pairs separated
+// by semicolons, such as a=1;b=2, with the backslashes, semicolons and equal signs escaped with a backslash.
+// The wide layout has one row per timestamp and one column per metric:
+//
+//	timestamp,cpu,memory
+//	2017-07-01T12:00:00Z,0.5,1024
+package csvio
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// Layout selects how the datapoints are arranged in the rows
+type Layout int
+
+const (
+	// Long writes one datapoint per row
+	Long Layout = iota
+	// Wide writes one row per timestamp and one column per metric
+	Wide
+)
+
+// Timestamp formats in addition to the time package layouts
+const (
+	UnixMilli   = "unixms" // Milliseconds since epoch
+	UnixSeconds = "unix"   // Seconds since epoch
+)
+
+// Column names
+const (
+	ColumnMetric    = "metric"
+	ColumnType      = "type"
+	ColumnTimestamp = "timestamp"
+	ColumnValue     = "value"
+	ColumnTags      = "tags"
+)
+
+// Options configures the export and import
+type Options struct {
+	Layout Layout
+
+	// TimestampFormat is UnixMilli, UnixSeconds or a time package layout. Exports default to time.RFC3339Nano,
+	// imports detect RFC 3339 timestamps and milliseconds since epoch when it is not set.
+	TimestampFormat string
+	Location        *time.Location // Time zone of the timestamps without one, defaults to UTC
+
+	// Type of the imported metrics without a type column. When it is not set, the type is inferred from
+	// the first value of each metric: numbers are gauges, up/down/unknown availabilities and the rest strings.
+	Type      metrics.MetricType
+	BatchSize int // Datapoints per imported batch, defaults to 1000
+}
+
+// ParseError points to the line and column of an invalid value in the imported CSV
+type ParseError struct {
+	Line   int
+	Column string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("Line %d: %s", e.Line, e.Err.Error())
+	}
+	return fmt.Sprintf("Line %d, column %s: %s", e.Line, e.Column, e.Err.Error())
+}
+
+func formatTimestamp(t time.Time, o Options) string {
+	switch o.TimestampFormat {
+	case UnixMilli:
+		return strconv.FormatInt(metrics.ToUnixMilli(t), 10)
+	case UnixSeconds:
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+
+	loc := o.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	layout := o.TimestampFormat
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	return t.In(loc).Format(layout)
+}
+
+func parseTimestamp(s string, o Options) (time.Time, error) {
+	switch o.TimestampFormat {
+	case UnixMilli, UnixSeconds:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Timestamp %s is not an integer", s)
+		}
+		if o.TimestampFormat == UnixSeconds {
+			return time.Unix(i, 0), nil
+		}
+		return metrics.FromUnixMilli(i), nil
+	case "":
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t, nil
+		}
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return metrics.FromUnixMilli(i), nil
+		}
+		return time.Time{}, fmt.Errorf("Timestamp %s is neither RFC 3339 nor milliseconds since epoch", s)
+	}
+
+	loc := o.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation(o.TimestampFormat, s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Timestamp %s does not match the format %s", s, o.TimestampFormat)
+	}
+	return t, nil
+}
+
+func formatValue(v interface{}) string {
+	switch i := v.(type) {
+	case string:
+		return i
+	case float64:
+		return strconv.FormatFloat(i, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+var tagEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, "=", `\=`)
+
+// formatTags writes the tags as k=v pairs separated by semicolons. Backslashes, semicolons and equal
+// signs in the names and values are escaped with a backslash.
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, tagEscaper.Replace(k)+"="+tagEscaper.Replace(v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
+
+func parseTags(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	tags := make(map[string]string)
+	for _, pair := range splitUnescaped(s, ';', -1) {
+		kv := splitUnescaped(pair, '=', 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Tag %s is not in k=v format", pair)
+		}
+		tags[unescapeTag(kv[0])] = unescapeTag(kv[1])
+	}
+	return tags, nil
+}
+
+// splitUnescaped splits s at the separators not escaped with a backslash, into at most n parts if n > 0
+func splitUnescaped(s string, sep byte, n int) []string {
+	parts := make([]string, 0, 1)
+	start := 0
+	for i := 0; i < len(s) && (n <= 0 || len(parts) < n-1); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unescapeTag(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	b := &strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ParseType parses a metric type name, both the singular and the plural form are accepted
+func ParseType(s string) (metrics.MetricType, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "gauge", "gauges":
+		return metrics.Gauge, nil
+	case "counter", "counters":
+		return metrics.Counter, nil
+	case "availability", "availabilities":
+		return metrics.Availability, nil
+	case "string", "strings":
+		return metrics.String, nil
+	}
+	return "", fmt.Errorf("Unknown metric type %s", s)
+}
+
+// inferType guesses the metric type from a value: numbers are gauges, up/down/unknown availabilities
+// and everything else strings
+func inferType(s string) metrics.MetricType {
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return metrics.Gauge
+	}
+	switch strings.ToLower(s) {
+	case "up", "down", "unknown":
+		return metrics.Availability
+	}
+	return metrics.String
+}
+
+func parseValue(t metrics.MetricType, s string) (interface{}, error) {
+	switch t {
+	case metrics.Gauge:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Gauge value %s is not a number", s)
+		}
+		return f, nil
+	case metrics.Counter:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Counter value %s is not an integer", s)
+		}
+		return i, nil
+	case metrics.Availability:
+		switch strings.ToLower(s) {
+		case "up", "down", "unknown":
+			return strings.ToLower(s), nil
+		}
+		return nil, fmt.Errorf("Availability value %s is not up, down or unknown", s)
+	}
+	return s, nil
+}
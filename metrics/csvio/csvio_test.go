@@ -0,0 +1,221 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package csvio
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/hawkular/hawkular-client-go/internal/testutil"
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+var ts = time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+
+func testSeries() []metrics.MetricHeader {
+	return []metrics.MetricHeader{
+		{Type: metrics.Gauge, ID: "cpu", Data: []metrics.Datapoint{
+			{Timestamp: ts, Value: 0.5, Tags: map[string]string{"b": "2", "a": "1"}},
+			{Timestamp: ts.Add(time.Minute), Value: 0.75},
+		}},
+		{Type: metrics.Gauge, ID: "memory", Data: []metrics.Datapoint{
+			{Timestamp: ts.Add(time.Minute), Value: 1024.0},
+		}},
+	}
+}
+
+func TestWriteRaw(t *testing.T) {
+	b := &bytes.Buffer{}
+	assert.NoError(t, WriteRaw(b, testSeries(), Options{}))
+	assert.Equal(t, `metric,type,timestamp,value,tags
+cpu,gauge,2017-07-01T12:00:00Z,0.5,a=1;b=2
+cpu,gauge,2017-07-01T12:01:00Z,0.75,
+memory,gauge,2017-07-01T12:01:00Z,1024,
+`, b.String())
+
+	b.Reset()
+	assert.NoError(t, WriteRaw(b, testSeries(), Options{Layout: Wide, TimestampFormat: UnixMilli}))
+	assert.Equal(t, `timestamp,cpu,memory
+1498910400000,0.5,
+1498910460000,0.75,1024
+`, b.String())
+}
+
+func TestWriteBuckets(t *testing.T) {
+	series := []BucketSeries{
+		{ID: "cpu", Buckets: []*metrics.Bucketpoint{
+			{Start: ts, End: ts.Add(time.Hour), Min: 1, Max: 3, Avg: 2, Median: 2, Samples: 3},
+			{Start: ts.Add(time.Hour), End: ts.Add(2 * time.Hour), Empty: true},
+		}},
+	}
+
+	b := &bytes.Buffer{}
+	assert.NoError(t, WriteBuckets(b, series, StatMax, Options{Layout: Wide, TimestampFormat: "2006-01-02 15:04"}))
+	assert.Equal(t, `start,end,cpu
+2017-07-01 12:00,2017-07-01 13:00,3
+2017-07-01 13:00,2017-07-01 14:00,
+`, b.String())
+
+	b.Reset()
+	assert.NoError(t, WriteBuckets(b, series, "", Options{}))
+	assert.True(t, strings.HasPrefix(b.String(), "metric,start,end,samples,min,avg,median,max\ncpu,2017-07-01T12:00:00Z,2017-07-01T13:00:00Z,3,1,2,2,3\n"))
+
+	assert.Error(t, WriteBuckets(b, series, "p99", Options{Layout: Wide}))
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, layout := range []Layout{Long, Wide} {
+		b := &bytes.Buffer{}
+		assert.NoError(t, WriteRaw(b, testSeries(), Options{Layout: layout}))
+
+		mhs, err := ReadAll(b, Options{})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(mhs))
+		assert.Equal(t, "cpu", mhs[0].ID)
+		assert.Equal(t, metrics.MetricType(metrics.Gauge), mhs[0].Type)
+		assert.Equal(t, 2, len(mhs[0].Data))
+		assert.True(t, ts.Equal(mhs[0].Data[0].Timestamp))
+		assert.Equal(t, 0.5, mhs[0].Data[0].Value)
+		assert.Equal(t, 1024.0, mhs[1].Data[0].Value)
+		if layout == Long {
+			assert.Equal(t, "1", mhs[0].Data[0].Tags["a"])
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	in := `metric,timestamp,value
+cpu,1498910400000,1
+status,1498910400000,UP
+log,1498910400000,started
+cpu,1498910460000,2
+`
+	d := NewDecoder(strings.NewReader(in), Options{BatchSize: 2})
+	first, err := d.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(first))
+	assert.Equal(t, metrics.MetricType(metrics.Availability), first[1].Type)
+	assert.Equal(t, "up", first[1].Data[0].Value)
+
+	second, err := d.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, metrics.MetricType(metrics.String), second[0].Type)
+	assert.Equal(t, metrics.MetricType(metrics.Gauge), second[1].Type)
+
+	_, err = d.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// Forced type
+	mhs, err := ReadAll(strings.NewReader("timestamp,requests\n1498910400000,5\n"), Options{Type: metrics.Counter})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), mhs[0].Data[0].Value)
+}
+
+func TestDecoderErrors(t *testing.T) {
+	cases := []struct {
+		in     string
+		line   int
+		column string
+	}{
+		{"metric,timestamp,value\ncpu,1498910400000,1\ncpu,yesterday,2\n", 3, "timestamp"},
+		{"metric,timestamp,value\ncpu,1498910400000,1\ncpu,1498910460000,high\n", 3, "value"},
+		{"metric,type,timestamp,value\ncpu,gauge,1498910400000,1\ncpu,counter,1498910460000,2\n", 3, "type"},
+		{"metric,type,timestamp,value\ncpu,histogram,1498910400000,1\n", 2, "type"},
+		{"timestamp,cpu\n1498910400000,1\n1498910460000,\"multi\nline\"\n1498910520000,x\n", 3, "cpu"},
+		{"value,cpu\n1,2\n", 1, ""},
+	}
+
+	for _, c := range cases {
+		_, err := ReadAll(strings.NewReader(c.in), Options{})
+		assert.Error(t, err, c.in)
+		pe, ok := err.(*ParseError)
+		assert.True(t, ok, "%s: %v", c.in, err)
+		assert.Equal(t, c.line, pe.Line, c.in)
+		assert.Equal(t, c.column, pe.Column, c.in)
+	}
+}
+
+func TestImport(t *testing.T) {
+	lock := &sync.Mutex{}
+	points := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mhs := []metrics.MetricHeader{}
+		assert.NoError(t, json.Unmarshal(b, &mhs))
+		lock.Lock()
+		defer lock.Unlock()
+		for _, mh := range mhs {
+			assert.True(t, len(mh.Data) <= 2)
+			points += len(mh.Data)
+		}
+	}))
+	defer s.Close()
+
+	c, err := metrics.NewHawkularClient(metrics.Parameters{Tenant: "csv", Url: s.URL})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	b := &bytes.Buffer{}
+	assert.NoError(t, WriteRaw(b, testSeries(), Options{}))
+	n, err := Import(c, b, Options{BatchSize: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 3, points)
+
+	// The batches before the invalid row are written
+	n, err = Import(c, strings.NewReader("metric,timestamp,value\ncpu,1,1\ncpu,2,1\ncpu,x,1\n"), Options{BatchSize: 1})
+	assert.Error(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 5, points)
+
+	// Any Writer is accepted, such as a DefinitionCache or a Router
+	rec := &testutil.Recorder{}
+	n, err = Import(rec, strings.NewReader("metric,timestamp,value\ncpu,1,1\ncpu,2,1\n"), Options{BatchSize: 1}, metrics.Tenant("other"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, map[string]int{"cpu": 2}, rec.Datapoints())
+	assert.Equal(t, "other", rec.Last().Tenant)
+}
+
+func TestTagsEscaping(t *testing.T) {
+	tags := map[string]string{"a;b": "c=d", `back\slash`: "x;y=z", "empty": ""}
+	s := formatTags(tags)
+	assert.Equal(t, `a\;b=c\=d;back\\slash=x\;y\=z;empty=`, s)
+	parsed, err := parseTags(s)
+	assert.NoError(t, err)
+	assert.Equal(t, tags, parsed)
+
+	parsed, err = parseTags("url=http://host/?q=1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://host/?q=1", parsed["url"], "Only the first equal sign separates the value")
+
+	for _, in := range []string{"a", "=b", "a=1;b"} {
+		_, err = parseTags(in)
+		assert.Error(t, err, in)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package csvio
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// Bucket statistics selectable for the wide bucket layout
+const (
+	StatMin     = "min"
+	StatMax     = "max"
+	StatAvg     = "avg"
+	StatMedian  = "median"
+	StatSamples = "samples"
+)
+
+// BucketSeries is the ReadBuckets result of a single metric
+type BucketSeries struct {
+	ID      string
+	Buckets []*metrics.Bucketpoint
+}
+
+// WriteRaw writes the datapoints of the metrics. The long layout includes the type and tags columns.
+func WriteRaw(w io.Writer, series []metrics.MetricHeader, o Options) error {
+	cw := csv.NewWriter(w)
+
+	if o.Layout == Wide {
+		if err := writeWide(cw, series, o); err != nil {
+			return err
+		}
+	} else {
+		if err := cw.Write([]string{ColumnMetric, ColumnType, ColumnTimestamp, ColumnValue, ColumnTags}); err != nil {
+			return err
+		}
+		for _, mh := range series {
+			for _, dp := range mh.Data {
+				row := []string{mh.ID, string(mh.Type), formatTimestamp(dp.Timestamp, o), formatValue(dp.Value), formatTags(dp.Tags)}
+				if err := cw.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeWide(cw *csv.Writer, series []metrics.MetricHeader, o Options) error {
+	header := make([]string, 0, len(series)+1)
+	header = append(header, ColumnTimestamp)
+	for _, mh := range series {
+		header = append(header, mh.ID)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	rows := make(map[int64][]string)
+	for i, mh := range series {
+		for _, dp := range mh.Data {
+			ts := dp.Timestamp.UnixNano()
+			row, found := rows[ts]
+			if !found {
+				row = make([]string, len(series)+1)
+				row[0] = formatTimestamp(dp.Timestamp, o)
+				rows[ts] = row
+			}
+			row[i+1] = formatValue(dp.Value)
+		}
+	}
+	return writeSorted(cw, rows)
+}
+
+func writeSorted(cw *csv.Writer, rows map[int64][]string) error {
+	keys := make([]int64, 0, len(rows))
+	for k := range rows {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, k := range keys {
+		if err := cw.Write(rows[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bucketStat(bp *metrics.Bucketpoint, stat string) (string, error) {
+	if bp.Empty {
+		return "", nil
+	}
+	switch stat {
+	case StatMin:
+		return formatValue(bp.Min), nil
+	case StatMax:
+		return formatValue(bp.Max), nil
+	case StatAvg, "":
+		return formatValue(bp.Avg), nil
+	case StatMedian:
+		return formatValue(bp.Median), nil
+	case StatSamples:
+		return strconv.FormatUint(bp.Samples, 10), nil
+	}
+	return "", fmt.Errorf("Unknown bucket statistic %s", stat)
+}
+
+// WriteBuckets writes the statistical buckets of the metrics. The long layout has a column for every
+// statistic, the wide layout a column per metric with the selected statistic (StatAvg by default).
+func WriteBuckets(w io.Writer, series []BucketSeries, stat string, o Options) error {
+	cw := csv.NewWriter(w)
+
+	if o.Layout == Wide {
+		header := []string{"start", "end"}
+		for _, s := range series {
+			header = append(header, s.ID)
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+
+		rows := make(map[int64][]string)
+		for i, s := range series {
+			for _, bp := range s.Buckets {
+				ts := bp.Start.UnixNano()
+				row, found := rows[ts]
+				if !found {
+					row = make([]string, len(series)+2)
+					row[0] = formatTimestamp(bp.Start, o)
+					row[1] = formatTimestamp(bp.End, o)
+					rows[ts] = row
+				}
+				v, err := bucketStat(bp, stat)
+				if err != nil {
+					return err
+				}
+				row[i+2] = v
+			}
+		}
+		if err := writeSorted(cw, rows); err != nil {
+			return err
+		}
+	} else {
+		header := []string{ColumnMetric, "start", "end", StatSamples, StatMin, StatAvg, StatMedian, StatMax}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		for _, s := range series {
+			for _, bp := range s.Buckets {
+				row := []string{s.ID, formatTimestamp(bp.Start, o), formatTimestamp(bp.End, o), strconv.FormatUint(bp.Samples, 10)}
+				for _, stat := range []string{StatMin, StatAvg, StatMedian, StatMax} {
+					v, _ := bucketStat(bp, stat)
+					row = append(row, v)
+				}
+				if err := cw.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportRaw reads the raw datapoints of the metrics with ReadRaw and writes them to w
+func ExportRaw(c *metrics.Client, w io.Writer, t metrics.MetricType, ids []string, o Options, m ...metrics.Modifier) error {
+	series := make([]metrics.MetricHeader, 0, len(ids))
+	for _, id := range ids {
+		dps, err := c.ReadRaw(t, id, m...)
+		if err != nil {
+			return err
+		}
+		mh := metrics.MetricHeader{Type: t, ID: id, Data: make([]metrics.Datapoint, 0, len(dps))}
+		for _, dp := range dps {
+			mh.Data = append(mh.Data, *dp)
+		}
+		series = append(series, mh)
+	}
+	return WriteRaw(w, series, o)
+}
+
+// ExportBuckets reads the buckets of each metric with ReadBuckets and writes them to w. The modifiers
+// should set the time range and the bucket size, such as BucketsDurationFilter.
+func ExportBuckets(c *metrics.Client, w io.Writer, t metrics.MetricType, ids []string, stat string, o Options, m ...metrics.Modifier) error {
+	series := make([]BucketSeries, 0, len(ids))
+	for _, id := range ids {
+		bps, err := c.ReadBuckets(t, append(m[:len(m):len(m)], metrics.Filters(metrics.MetricsFilter([]string{id})))...)
+		if err != nil {
+			return err
+		}
+		series = append(series, BucketSeries{ID: id, Buckets: bps})
+	}
+	return WriteBuckets(w, series, stat, o)
+}
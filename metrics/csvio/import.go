@@ -0,0 +1,294 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package csvio
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+const defaultBatchSize = 1000
+
+// Decoder reads the datapoints from CSV in batches. The layout is detected from the header row: a metric
+// (or id) column selects the long layout, otherwise the first column must be the timestamp of the wide layout.
+type Decoder struct {
+	r *csv.Reader
+	o Options
+
+	header    []string
+	layout    Layout
+	metric    int
+	typ       int
+	timestamp int
+	value     int
+	tags      int
+
+	types map[string]metrics.MetricType
+	done  bool
+}
+
+// NewDecoder returns a Decoder reading from r
+func NewDecoder(r io.Reader, o Options) *Decoder {
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultBatchSize
+	}
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	return &Decoder{r: cr, o: o, types: make(map[string]metrics.MetricType)}
+}
+
+func (d *Decoder) readHeader() error {
+	header, err := d.r.Read()
+	if err == io.EOF {
+		return fmt.Errorf("CSV is empty")
+	}
+	if err != nil {
+		return d.wrap(err)
+	}
+
+	d.header = header
+	d.metric, d.typ, d.timestamp, d.value, d.tags = -1, -1, -1, -1, -1
+	for i, h := range header {
+		switch strings.ToLower(strings.TrimSpace(h)) {
+		case ColumnMetric, "id":
+			d.metric = i
+		case ColumnType:
+			d.typ = i
+		case ColumnTimestamp:
+			d.timestamp = i
+		case ColumnValue:
+			d.value = i
+		case ColumnTags:
+			d.tags = i
+		}
+	}
+
+	if d.metric >= 0 {
+		d.layout = Long
+		if d.timestamp < 0 || d.value < 0 {
+			return &ParseError{Line: 1, Err: fmt.Errorf("Long layout requires %s and %s columns", ColumnTimestamp, ColumnValue)}
+		}
+		return nil
+	}
+
+	d.layout = Wide
+	if d.timestamp != 0 {
+		return &ParseError{Line: 1, Err: fmt.Errorf("Header must have a %s column or start with a %s column", ColumnMetric, ColumnTimestamp)}
+	}
+	if len(header) < 2 {
+		return &ParseError{Line: 1, Err: fmt.Errorf("Wide layout requires at least one metric column")}
+	}
+	return nil
+}
+
+func (d *Decoder) wrap(err error) error {
+	if pe, ok := err.(*csv.ParseError); ok {
+		return &ParseError{Line: pe.Line, Err: pe.Err}
+	}
+	return err
+}
+
+// Next returns the next batch of at most BatchSize datapoints grouped by metric, or io.EOF after the last batch
+func (d *Decoder) Next() ([]metrics.MetricHeader, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+	if d.header == nil {
+		if err := d.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	b := newBatch()
+	for b.size < d.o.BatchSize {
+		record, err := d.r.Read()
+		if err == io.EOF {
+			d.done = true
+			break
+		}
+		if err != nil {
+			return nil, d.wrap(err)
+		}
+		line, _ := d.r.FieldPos(0)
+
+		if d.layout == Long {
+			err = d.long(b, record, line)
+		} else {
+			err = d.wide(b, record, line)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if b.size == 0 {
+		return nil, io.EOF
+	}
+	return b.headers, nil
+}
+
+func (d *Decoder) long(b *batch, record []string, line int) error {
+	id := strings.TrimSpace(record[d.metric])
+	if id == "" {
+		return &ParseError{Line: line, Column: d.header[d.metric], Err: fmt.Errorf("Metric id is empty")}
+	}
+
+	ts, err := parseTimestamp(strings.TrimSpace(record[d.timestamp]), d.o)
+	if err != nil {
+		return &ParseError{Line: line, Column: d.header[d.timestamp], Err: err}
+	}
+
+	var t metrics.MetricType
+	if d.typ >= 0 && strings.TrimSpace(record[d.typ]) != "" {
+		if t, err = ParseType(record[d.typ]); err != nil {
+			return &ParseError{Line: line, Column: d.header[d.typ], Err: err}
+		}
+	}
+
+	raw := record[d.value]
+	t, err = d.resolveType(id, t, raw)
+	if err != nil {
+		column := d.header[d.value]
+		if d.typ >= 0 {
+			column = d.header[d.typ]
+		}
+		return &ParseError{Line: line, Column: column, Err: err}
+	}
+	v, err := parseValue(t, strings.TrimSpace(raw))
+	if err != nil {
+		return &ParseError{Line: line, Column: d.header[d.value], Err: err}
+	}
+
+	dp := metrics.Datapoint{Timestamp: ts, Value: v}
+	if d.tags >= 0 {
+		if dp.Tags, err = parseTags(strings.TrimSpace(record[d.tags])); err != nil {
+			return &ParseError{Line: line, Column: d.header[d.tags], Err: err}
+		}
+	}
+	b.add(t, id, dp)
+	return nil
+}
+
+func (d *Decoder) wide(b *batch, record []string, line int) error {
+	ts, err := parseTimestamp(strings.TrimSpace(record[0]), d.o)
+	if err != nil {
+		return &ParseError{Line: line, Column: d.header[0], Err: err}
+	}
+
+	for i := 1; i < len(record); i++ {
+		raw := strings.TrimSpace(record[i])
+		if raw == "" {
+			continue
+		}
+		id := d.header[i]
+		t, err := d.resolveType(id, "", raw)
+		if err != nil {
+			return &ParseError{Line: line, Column: id, Err: err}
+		}
+		v, err := parseValue(t, raw)
+		if err != nil {
+			return &ParseError{Line: line, Column: id, Err: err}
+		}
+		b.add(t, id, metrics.Datapoint{Timestamp: ts, Value: v})
+	}
+	return nil
+}
+
+// resolveType returns the type of the metric: the given type, Options.Type or the type inferred from the
+// first value. A metric can not change its type in the middle of the file.
+func (d *Decoder) resolveType(id string, t metrics.MetricType, raw string) (metrics.MetricType, error) {
+	known, found := d.types[id]
+	if t == "" {
+		if found {
+			return known, nil
+		}
+		t = d.o.Type
+		if t == "" {
+			t = inferType(strings.TrimSpace(raw))
+		}
+	}
+	if found && known != t {
+		return "", fmt.Errorf("Metric %s changes type from %s to %s", id, known, t)
+	}
+	d.types[id] = t
+	return t, nil
+}
+
+type batchKey struct {
+	t  metrics.MetricType
+	id string
+}
+
+type batch struct {
+	headers []metrics.MetricHeader
+	index   map[batchKey]int
+	size    int
+}
+
+func newBatch() *batch {
+	return &batch{headers: make([]metrics.MetricHeader, 0), index: make(map[batchKey]int)}
+}
+
+func (b *batch) add(t metrics.MetricType, id string, dp metrics.Datapoint) {
+	k := batchKey{t: t, id: id}
+	i, found := b.index[k]
+	if !found {
+		i = len(b.headers)
+		b.index[k] = i
+		b.headers = append(b.headers, metrics.MetricHeader{Type: t, ID: id, Data: make([]metrics.Datapoint, 0, 1)})
+	}
+	b.headers[i].Data = append(b.headers[i].Data, dp)
+	b.size++
+}
+
+// ReadAll reads all the datapoints from the CSV
+func ReadAll(r io.Reader, o Options) ([]metrics.MetricHeader, error) {
+	o.BatchSize = int(^uint(0) >> 1)
+	mhs, err := NewDecoder(r, o).Next()
+	if err == io.EOF {
+		return []metrics.MetricHeader{}, nil
+	}
+	return mhs, err
+}
+
+// Import writes the datapoints from the CSV to the writer in batches of BatchSize datapoints and returns the
+// amount of written datapoints. The CSV is read as it is written, so the batches before an invalid row are
+// written before the error is returned.
+func Import(w metrics.Writer, r io.Reader, o Options, m ...metrics.Modifier) (int, error) {
+	d := NewDecoder(r, o)
+	written := 0
+	for {
+		mhs, err := d.Next()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+		if err := w.Write(mhs, m...); err != nil {
+			return written, err
+		}
+		for _, mh := range mhs {
+			written += len(mh.Data)
+		}
+	}
+}
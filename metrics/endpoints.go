@@ -0,0 +1,285 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EndpointPolicy selects how the requests are spread over multiple servers
+type EndpointPolicy int
+
+const (
+	// EndpointFailover sends all the requests to the first healthy server in the list
+	EndpointFailover EndpointPolicy = iota
+	// EndpointRoundRobin rotates the requests over all the healthy servers
+	EndpointRoundRobin
+)
+
+const defaultHealthCheckInterval = time.Duration(10 * time.Second)
+
+// EndpointStatus is the health of a single Hawkular-Metrics server
+type EndpointStatus struct {
+	URL       string
+	Healthy   bool
+	LastError error     // Reason the server was marked unhealthy
+	Since     time.Time // Time of the latest health change
+}
+
+type endpoint struct {
+	base      *url.URL
+	healthy   bool
+	lastError error
+	since     time.Time
+}
+
+// endpointPool tracks the health of the servers. Servers are marked unhealthy after a connection
+// error or a 5xx response and healthy again once their status endpoint responds.
+type endpointPool struct {
+	policy EndpointPolicy
+
+	lock      sync.Mutex
+	endpoints []*endpoint
+	next      int
+}
+
+func parseBaseURL(raw string) (*url.URL, error) {
+	uri, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if uri.Path == "" {
+		uri.Path = baseURL
+	}
+
+	return &url.URL{
+		Host:   uri.Host,
+		Path:   uri.Path,
+		Scheme: uri.Scheme,
+		Opaque: fmt.Sprintf("/%s", uri.Path),
+	}, nil
+}
+
+func newEndpointPool(urls []string, policy EndpointPolicy) (*endpointPool, error) {
+	p := &endpointPool{
+		policy:    policy,
+		endpoints: make([]*endpoint, 0, len(urls)),
+	}
+	for _, raw := range urls {
+		u, err := parseBaseURL(raw)
+		if err != nil {
+			return nil, err
+		}
+		p.endpoints = append(p.endpoints, &endpoint{base: u, healthy: true, since: time.Now()})
+	}
+	return p, nil
+}
+
+// pick returns the next healthy endpoint which has not been tried yet. If every server is unhealthy,
+// the first request is still sent to one of them instead of failing without trying.
+func (p *endpointPool) pick(tried map[*endpoint]bool) *endpoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	n := len(p.endpoints)
+	start := 0
+	if p.policy == EndpointRoundRobin {
+		start = p.next
+		p.next = (p.next + 1) % n
+	}
+
+	for i := 0; i < n; i++ {
+		e := p.endpoints[(start+i)%n]
+		if e.healthy && !tried[e] {
+			return e
+		}
+	}
+	if len(tried) == 0 {
+		return p.endpoints[start]
+	}
+	return nil
+}
+
+func (p *endpointPool) mark(e *endpoint, healthy bool, err error) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	e.lastError = err
+	if e.healthy == healthy {
+		return false
+	}
+	e.healthy = healthy
+	e.since = time.Now()
+	return true
+}
+
+func (p *endpointPool) unhealthy() []*endpoint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	u := make([]*endpoint, 0)
+	for _, e := range p.endpoints {
+		if !e.healthy {
+			u = append(u, e)
+		}
+	}
+	return u
+}
+
+func (p *endpointPool) status() []EndpointStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	s := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		s = append(s, EndpointStatus{
+			URL:       fmt.Sprintf("%s://%s%s", e.base.Scheme, e.base.Host, e.base.Opaque),
+			Healthy:   e.healthy,
+			LastError: e.lastError,
+			Since:     e.since,
+		})
+	}
+	return s
+}
+
+// apply points the request to the endpoint, keeping the path after the base URL
+func (e *endpoint) apply(r *http.Request, suffix string) {
+	u := *r.URL
+	u.Scheme = e.base.Scheme
+	u.Host = e.base.Host
+	u.Opaque = e.base.Opaque + suffix
+	r.URL = &u
+	r.Host = e.base.Host
+}
+
+func endpointError(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("Server returned status code %d", resp.StatusCode)
+}
+
+// failover sends the request to the healthy servers in turn until one of them does not fail
+func (c *Client) failover(r *http.Request) (*http.Response, error) {
+	suffix := strings.TrimPrefix(r.URL.Opaque, c.url.Opaque)
+	tried := make(map[*endpoint]bool)
+
+	e := c.endpoints.pick(tried)
+	for {
+		e.apply(r, suffix)
+		tried[e] = true
+
+		resp, err := c.pooled(r)
+		if !retryable(resp, err) {
+			if c.endpoints.mark(e, true, nil) {
+				c.logger.Warn("Hawkular endpoint is healthy", "endpoint", e.base.Host)
+			}
+			return resp, err
+		}
+		if c.ctx.Err() != nil {
+			return resp, err
+		}
+
+		if c.endpoints.mark(e, false, endpointError(resp, err)) {
+			c.logger.Warn("Hawkular endpoint marked unhealthy", "endpoint", e.base.Host, "error", endpointError(resp, err))
+		}
+
+		if !idempotent(r) || (r.Body != nil && r.GetBody == nil) {
+			return resp, err
+		}
+		// The picked endpoint is kept for the next attempt, picking advances the round robin
+		next := c.endpoints.pick(tried)
+		if next == nil {
+			return resp, err
+		}
+		e = next
+
+		if r.GetBody != nil {
+			body, berr := r.GetBody()
+			if berr != nil {
+				return resp, err
+			}
+			r.Body = body
+		}
+		if resp != nil {
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		c.logger.Warn("Hawkular request failed over to another endpoint", "url", requestURL(r))
+	}
+}
+
+// healthCheck probes the unhealthy servers' status endpoint until the client is closed
+func (c *Client) healthCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			for _, e := range c.endpoints.unhealthy() {
+				if err := c.probe(e); err == nil {
+					if c.endpoints.mark(e, true, nil) {
+						c.logger.Warn("Hawkular endpoint is healthy", "endpoint", e.base.Host)
+					}
+				} else {
+					c.endpoints.mark(e, false, err)
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) probe(e *endpoint) error {
+	u := *e.base
+	StatusEndpoint()(&u)
+
+	req, err := http.NewRequest("GET", "", nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(c.ctx)
+	req.URL = &u
+	req.Host = u.Host
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return endpointError(resp, nil)
+	}
+	return nil
+}
+
+// Endpoints returns the health of the configured servers
+func (c *Client) Endpoints() []EndpointStatus {
+	if c.endpoints == nil {
+		return []EndpointStatus{{URL: fmt.Sprintf("%s://%s%s", c.url.Scheme, c.url.Host, c.url.Opaque), Healthy: true}}
+	}
+	return c.endpoints.status()
+}
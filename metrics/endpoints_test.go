@@ -0,0 +1,160 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestEndpointFailover(t *testing.T) {
+	var failing int32 = 1
+	var bodies int32
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if name == "first" && atomic.LoadInt32(&failing) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if r.Method == "POST" {
+				b, _ := ioutil.ReadAll(r.Body)
+				if strings.Contains(string(b), "test.failover") {
+					atomic.AddInt32(&bodies, 1)
+				}
+			}
+			w.Header().Set("X-Server", name)
+		}
+	}
+	first := httptest.NewServer(handler("first"))
+	defer first.Close()
+	second := httptest.NewServer(handler("second"))
+	defer second.Close()
+
+	c, err := NewHawkularClient(Parameters{
+		Tenant:              "failover",
+		Urls:                []string{first.URL, second.URL},
+		HealthCheckInterval: 20 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	r, err := c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, "second", r.Header.Get("X-Server"))
+
+	status := c.Endpoints()
+	assert.Equal(t, 2, len(status))
+	assert.False(t, status[0].Healthy)
+	assert.Error(t, status[0].LastError)
+	assert.True(t, status[1].Healthy)
+
+	// Unhealthy server is skipped and the body is delivered
+	err = c.Write([]MetricHeader{{
+		Type: Gauge,
+		ID:   "test.failover",
+		Data: []Datapoint{{Timestamp: time.Now(), Value: 1.0}},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&bodies))
+
+	// Health check brings the first server back
+	atomic.StoreInt32(&failing, 0)
+	for i := 0; i < 50 && !c.Endpoints()[0].Healthy; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, c.Endpoints()[0].Healthy)
+
+	r, err = c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, "first", r.Header.Get("X-Server"))
+}
+
+func TestEndpointRoundRobin(t *testing.T) {
+	var hits [2]int32
+	servers := make([]string, 0, 2)
+	for i := range hits {
+		n := i
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits[n], 1)
+		}))
+		defer s.Close()
+		servers = append(servers, s.URL)
+	}
+
+	c, err := NewHawkularClient(Parameters{
+		Tenant:         "roundrobin",
+		Urls:           servers,
+		EndpointPolicy: EndpointRoundRobin,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := c.Send(c.URL("GET"))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(5), atomic.LoadInt32(&hits[0]))
+	assert.Equal(t, int32(5), atomic.LoadInt32(&hits[1]))
+
+	_, err = NewHawkularClient(Parameters{Url: servers[0], Urls: servers})
+	assert.Error(t, err)
+}
+
+func TestEndpointRoundRobinFailover(t *testing.T) {
+	var hits [3]int32
+	servers := make([]string, 0, 3)
+	for i := range hits {
+		n := i
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits[n], 1)
+			if n == 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer s.Close()
+		servers = append(servers, s.URL)
+	}
+
+	c, err := NewHawkularClient(Parameters{
+		Tenant:              "roundrobin",
+		Urls:                servers,
+		EndpointPolicy:      EndpointRoundRobin,
+		HealthCheckInterval: time.Hour,
+	})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// The failed request moves to the next server in the rotation without skipping any
+	r, err := c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits[0]))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits[1]))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&hits[2]))
+
+	r, err = c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits[2]))
+}
@@ -0,0 +1,148 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package graphite
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/hawkular/hawkular-client-go/internal/testutil"
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+var now = time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+
+func templates(t *testing.T, specs ...string) []Template {
+	ts := make([]Template, 0, len(specs))
+	for _, s := range specs {
+		tpl, err := ParseTemplate(s)
+		assert.NoError(t, err)
+		ts = append(ts, tpl)
+	}
+	return ts
+}
+
+func TestParseTemplate(t *testing.T) {
+	tpl, err := ParseTemplate("servers.* .host.measurement* dc=east,rack=2")
+	assert.NoError(t, err)
+	assert.Equal(t, Template{Filter: "servers.*", Format: ".host.measurement*", Tags: map[string]string{"dc": "east", "rack": "2"}}, tpl)
+
+	tpl, err = ParseTemplate("env.host.measurement* dc=east")
+	assert.NoError(t, err)
+	assert.Equal(t, "", tpl.Filter)
+	assert.Equal(t, "env.host.measurement*", tpl.Format)
+
+	_, err = ParseTemplate("a b c d")
+	assert.Error(t, err)
+	_, err = ParseTemplate("[ measurement")
+	assert.Error(t, err)
+	_, err = ParseTemplate("measurement =east")
+	assert.Error(t, err)
+}
+
+func TestParse(t *testing.T) {
+	o := Options{
+		Templates: templates(t, "servers.* .host.measurement*", "stats.*.* .region.measurement.measurement", "env.host.measurement* dc=east"),
+		Tags:      map[string]string{"source": "graphite"},
+	}
+
+	mh, err := Parse("servers.node-1.cpu.load 0.5 1500000000", now, o)
+	assert.NoError(t, err)
+	assert.Equal(t, "cpu.load;host=node-1", mh.ID)
+	assert.Equal(t, metrics.MetricType(metrics.Gauge), mh.Type)
+	assert.Equal(t, map[string]string{"host": "node-1", "source": "graphite"}, mh.Tags)
+	assert.Equal(t, 0.5, mh.Data[0].Value)
+	assert.Equal(t, metrics.FromUnixMilli(1500000000000), mh.Data[0].Timestamp)
+
+	// Extra parts are ignored without measurement*
+	mh, err = Parse("stats.eu.requests.ok.extra 3", now, o)
+	assert.NoError(t, err)
+	assert.Equal(t, "requests.ok;region=eu", mh.ID)
+	assert.Equal(t, now, mh.Data[0].Timestamp)
+
+	mh, err = Parse("prod.node-2.mem.free;host=override;disk=sda 10 -1", now, o)
+	assert.NoError(t, err)
+	assert.Equal(t, "mem.free;disk=sda;env=prod;host=override", mh.ID)
+	assert.Equal(t, map[string]string{"env": "prod", "host": "override", "disk": "sda", "dc": "east", "source": "graphite"}, mh.Tags)
+	assert.Equal(t, now, mh.Data[0].Timestamp)
+
+	mh, err = Parse("plain 1 1500000000.25", now, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", mh.ID)
+	assert.Equal(t, metrics.FromUnixMilli(1500000000250), mh.Data[0].Timestamp)
+
+	// Repeated tag parts are joined
+	mh, err = Parse("a.b.c 1", now, Options{Templates: templates(t, "dc.dc.measurement"), Separator: "_"})
+	assert.NoError(t, err)
+	assert.Equal(t, "c;dc=a_b", mh.ID)
+
+	for _, line := range []string{"novalue", "a.b x", "a.b NaN", "a.b 1 x", "a.b;tag 1", "a b c d", ";t=v 1"} {
+		_, err = Parse(line, now, o)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	rec := &testutil.Recorder{}
+	errs := make(chan error, 10)
+	s := &Server{
+		Writer:        rec,
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+		OnError:       func(err error) { errs <- err },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Serve(ctx, ln, pc) }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	assert.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		fmt.Fprintf(conn, "tcp.metric %d %d\n", i, 1500000000+i)
+	}
+	fmt.Fprintf(conn, "broken\n")
+	conn.Close()
+
+	udp, err := net.Dial("udp", pc.LocalAddr().String())
+	assert.NoError(t, err)
+	_, err = udp.Write([]byte("udp.metric 1\nudp.metric 2\n"))
+	assert.NoError(t, err)
+	udp.Close()
+
+	assert.Error(t, <-errs)
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.Datapoints()["udp.metric"] < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The rest of the batch is written when the server stops
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Equal(t, map[string]int{"tcp.metric": 4, "udp.metric": 2}, rec.Datapoints())
+}
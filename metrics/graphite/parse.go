@@ -0,0 +1,112 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package graphite
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// Options configures the parsing of the lines
+type Options struct {
+	Templates []Template
+	Separator string            // Joins the measurement parts of the templates, defaults to "."
+	Tags      map[string]string // Added to every definition, but not to the ids
+}
+
+func (o *Options) separator() string {
+	if o.Separator == "" {
+		return "."
+	}
+	return o.Separator
+}
+
+// Parse converts a plaintext line to a gauge with a single datapoint. The datapoints without
+// a timestamp, or with a negative one, are timestamped with now.
+func Parse(line string, now time.Time, o Options) (metrics.MetricHeader, error) {
+	mh := metrics.MetricHeader{Type: metrics.Gauge}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return mh, fmt.Errorf("Line %q is not in path value [timestamp] format", line)
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return mh, fmt.Errorf("Invalid value %s of %s", fields[1], fields[0])
+	}
+	ts := now
+	if len(fields) == 3 {
+		secs, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return mh, fmt.Errorf("Invalid timestamp %s of %s", fields[2], fields[0])
+		}
+		if secs >= 0 {
+			ts = metrics.FromUnixMilli(int64(secs * 1000))
+		}
+	}
+
+	// Graphite tags, path;tag=value
+	segments := strings.Split(fields[0], ";")
+	tags := make(map[string]string)
+	for _, seg := range segments[1:] {
+		kv := strings.SplitN(seg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return mh, fmt.Errorf("Tag %s of %s is not in tag=value format", seg, segments[0])
+		}
+		tags[kv[0]] = kv[1]
+	}
+	if segments[0] == "" {
+		return mh, fmt.Errorf("Line %q has an empty path", line)
+	}
+
+	parts := strings.Split(segments[0], ".")
+	measurement := segments[0]
+	var static map[string]string
+	for i := range o.Templates {
+		t := &o.Templates[i]
+		if !t.matches(parts) {
+			continue
+		}
+		var pathTags map[string]string
+		measurement, pathTags = t.apply(parts, o.separator())
+		for k, v := range pathTags {
+			// The tags of the line win over the template
+			if _, found := tags[k]; !found {
+				tags[k] = v
+			}
+		}
+		static = t.Tags
+		break
+	}
+
+	mh.ID = metrics.TaggedID(measurement, tags)
+	mh.Tags = make(map[string]string, len(o.Tags)+len(static)+len(tags))
+	for _, m := range []map[string]string{o.Tags, static, tags} {
+		for k, v := range m {
+			mh.Tags[k] = v
+		}
+	}
+	mh.Data = []metrics.Datapoint{{Timestamp: ts, Value: value}}
+	return mh, nil
+}
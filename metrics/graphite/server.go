@@ -0,0 +1,240 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// maxPacketSize is the largest UDP datagram read
+const maxPacketSize = 65535
+
+// Server receives the plaintext lines and writes them in batches
+type Server struct {
+	Writer        metrics.Writer // Destination of the parsed datapoints
+	Options       Options
+	BatchSize     int           // Datapoints written at once, defaults to 1000
+	FlushInterval time.Duration // Maximum time the datapoints are buffered, defaults to 1 second
+
+	Modifiers []metrics.Modifier // Passed to Write, such as Tenant
+	OnError   func(err error)    // Receives the parse and write errors
+
+	lock    sync.Mutex
+	batch   map[string]*metrics.MetricHeader
+	order   []string
+	pending int
+}
+
+// ListenAndServe listens on the address, such as ":2003", with both TCP and UDP
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	return s.Serve(ctx, ln, pc)
+}
+
+// Serve reads the lines of the TCP connections accepted from ln and of the UDP datagrams of pc until the
+// context is cancelled, either can be nil. Both are closed and the buffered datapoints are written
+// before returning.
+func (s *Server) Serve(ctx context.Context, ln net.Listener, pc net.PacketConn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg := &sync.WaitGroup{}
+	errs := make(chan error, 2)
+
+	if ln != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.serveTCP(ctx, ln, wg)
+		}()
+	}
+	if pc != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.serveUDP(ctx, pc)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(s.flushInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Flush()
+			}
+		}
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+	cancel()
+	if ln != nil {
+		ln.Close()
+	}
+	if pc != nil {
+		pc.Close()
+	}
+	wg.Wait()
+	s.Flush()
+
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+func (s *Server) serveTCP(ctx context.Context, ln net.Listener, wg *sync.WaitGroup) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				select {
+				case <-ctx.Done():
+				case <-done:
+				}
+				conn.Close()
+			}()
+			s.read(conn)
+		}()
+	}
+}
+
+func (s *Server) serveUDP(ctx context.Context, pc net.PacketConn) error {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		s.read(strings.NewReader(string(buf[:n])))
+	}
+}
+
+// read adds the lines of the reader
+func (s *Server) read(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			s.Add(line)
+		}
+	}
+}
+
+// Add parses the line and buffers its datapoint, the batch is written when it is full
+func (s *Server) Add(line string) {
+	mh, err := Parse(line, time.Now(), s.Options)
+	if err != nil {
+		s.error(err)
+		return
+	}
+
+	s.lock.Lock()
+	if s.batch == nil {
+		s.batch = make(map[string]*metrics.MetricHeader)
+	}
+	if b, found := s.batch[mh.ID]; found {
+		b.Data = append(b.Data, mh.Data...)
+	} else {
+		s.batch[mh.ID] = &mh
+		s.order = append(s.order, mh.ID)
+	}
+	s.pending++
+	full := s.pending >= s.batchSize()
+	s.lock.Unlock()
+
+	if full {
+		s.Flush()
+	}
+}
+
+// Flush writes the buffered datapoints
+func (s *Server) Flush() {
+	s.lock.Lock()
+	mhs := make([]metrics.MetricHeader, 0, len(s.order))
+	for _, id := range s.order {
+		mhs = append(mhs, *s.batch[id])
+	}
+	s.batch, s.order, s.pending = nil, nil, 0
+	s.lock.Unlock()
+
+	if len(mhs) == 0 {
+		return
+	}
+	if err := s.Writer.Write(mhs, s.Modifiers...); err != nil {
+		s.error(err)
+	}
+}
+
+func (s *Server) error(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+func (s *Server) batchSize() int {
+	if s.BatchSize <= 0 {
+		return 1000
+	}
+	return s.BatchSize
+}
+
+func (s *Server) flushInterval() time.Duration {
+	if s.FlushInterval <= 0 {
+		return time.Second
+	}
+	return s.FlushInterval
+}
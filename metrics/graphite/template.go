@@ -0,0 +1,132 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package graphite receives the Graphite plaintext protocol over TCP and UDP and writes the datapoints
+// to Hawkular-Metrics as gauges.
+//
+// Each line is "path value [timestamp]", with the timestamp in seconds since epoch. The paths may have
+// Graphite tags, "path;tag=value;tag2=value2". Templates map the dotted paths to metric ids and tags,
+// in the style of the InfluxDB Graphite templates:
+//
+//	servers.* .host.measurement*    servers.node-1.cpu.load -> id cpu.load;host=node-1
+//	env.host.measurement* dc=east   prod.node-1.mem.free    -> id mem.free;env=prod;host=node-1, tag dc=east
+//
+// The template parts named measurement are joined to the id, measurement* joins the rest of the path,
+// empty parts are skipped and the other names are tags. Without a measurement part the whole path is used.
+// The first matching template is applied, so the templates without a filter should be the last ones.
+//
+// The tags read from the line are added to the id in the Graphite ";tag=value" form, sorted by name, to keep
+// the ids unique. All the tags, including the static ones of the templates, are the definition tags.
+package graphite
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Measurement is the template part that is added to the metric id
+const Measurement = "measurement"
+
+// Template maps the paths matching the filter to an id and tags
+type Template struct {
+	Filter string            // Dotted glob pattern matched to the beginning of the path, empty matches all
+	Format string            // Dotted template parts
+	Tags   map[string]string // Added to the matching paths
+}
+
+// ParseTemplate parses a template in "[filter] format [k=v,...]" format
+func ParseTemplate(s string) (Template, error) {
+	t := Template{}
+	fields := strings.Fields(s)
+	if n := len(fields); n > 1 && strings.Contains(fields[n-1], "=") {
+		t.Tags = make(map[string]string)
+		for _, pair := range strings.Split(fields[n-1], ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return t, fmt.Errorf("Template tag %s is not in k=v format", pair)
+			}
+			t.Tags[kv[0]] = kv[1]
+		}
+		fields = fields[:n-1]
+	}
+
+	switch len(fields) {
+	case 1:
+		t.Format = fields[0]
+	case 2:
+		t.Filter, t.Format = fields[0], fields[1]
+		if _, err := path.Match(t.Filter, ""); err != nil {
+			return t, fmt.Errorf("Invalid template filter %s", t.Filter)
+		}
+	default:
+		return t, fmt.Errorf("Template %s is not in [filter] format [k=v,...] format", s)
+	}
+	return t, nil
+}
+
+// matches checks the filter against the beginning of the path
+func (t *Template) matches(parts []string) bool {
+	if t.Filter == "" {
+		return true
+	}
+	filter := strings.Split(t.Filter, ".")
+	if len(filter) > len(parts) {
+		return false
+	}
+	for i, f := range filter {
+		if ok, _ := path.Match(f, parts[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns the measurement and the tags of the path parts
+func (t *Template) apply(parts []string, separator string) (string, map[string]string) {
+	tags := make(map[string]string)
+
+	var id []string
+	format := strings.Split(t.Format, ".")
+	for i, f := range format {
+		if i >= len(parts) {
+			break
+		}
+		switch {
+		case f == "":
+		case f == Measurement:
+			id = append(id, parts[i])
+		case f == Measurement+"*":
+			id = append(id, parts[i:]...)
+		default:
+			// Repeated tag parts are joined
+			if v, found := tags[f]; found {
+				tags[f] = v + separator + parts[i]
+			} else {
+				tags[f] = parts[i]
+			}
+		}
+		if f == Measurement+"*" {
+			break
+		}
+	}
+
+	if len(id) == 0 {
+		return strings.Join(parts, "."), tags
+	}
+	return strings.Join(id, separator), tags
+}
@@ -20,20 +20,23 @@ package metrics
 import (
 	"fmt"
 	"math"
+	"net/http"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
 func (c *Client) sendRoutine() {
 	for {
-		select {
-		case pr, open := <-c.pool:
-			if !open {
-				return
-			}
-			resp, err := c.client.Do(pr.req)
-			pr.rChan <- &poolResponse{err, resp}
+		pr, p, open := c.pool.pop()
+		if !open {
+			return
 		}
+		resp, err := c.client.Do(pr.req)
+		c.pool.done(p)
+		pr.rChan <- &poolResponse{err, resp}
 	}
 }
 
@@ -85,6 +88,25 @@ func FromUnixMilli(milli int64) time.Time {
 	return time.Unix(0, milli*int64(time.Millisecond))
 }
 
+// TaggedID appends the tags to the name in the Graphite ";tag=value" form, sorted by name
+func TaggedID(name string, tags map[string]string) string {
+	if len(tags) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b := &strings.Builder{}
+	b.WriteString(name)
+	for _, k := range keys {
+		fmt.Fprintf(b, ";%s=%s", k, tags[k])
+	}
+	return b.String()
+}
+
 // Prepend Helper function to insert modifier in the beginning of slice
 func prepend(slice []Modifier, a ...Modifier) []Modifier {
 	p := make([]Modifier, 0, len(slice)+len(a))
@@ -92,3 +114,21 @@ func prepend(slice []Modifier, a ...Modifier) []Modifier {
 	p = append(p, slice...)
 	return p
 }
+
+// tenantModify is the code of the Tenant modifiers
+var tenantModify = reflect.ValueOf(tenantModifier("").modify).Pointer()
+
+// modifierTenant returns the tenant set by the Tenant modifiers, or an empty string if they do not set one.
+// Only the Tenant modifiers are applied, to a request which is never sent.
+func modifierTenant(o []Modifier) string {
+	tenant := ""
+	for _, m := range o {
+		if m == nil || reflect.ValueOf(m).Pointer() != tenantModify {
+			continue
+		}
+		r := &http.Request{Header: make(http.Header)}
+		m(r)
+		tenant = r.Header.Get(tenantHeader)
+	}
+	return tenant
+}
@@ -0,0 +1,109 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package influx
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// DefaultField is the field name of the definitions without the FieldTag tag
+const DefaultField = "value"
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// Encode writes the datapoints of the definition as lines with nanosecond timestamps. The measurement and
+// the field are read from the MeasurementTag and FieldTag tags, defaulting to the id and DefaultField,
+// and the rest of the tags are the tags of the lines. The gauges are written as float fields, the counters
+// as integer fields and the string and availability metrics as string fields.
+func Encode(w io.Writer, md *metrics.MetricDefinition, dps []*metrics.Datapoint) error {
+	measurement, field := md.ID, DefaultField
+	keys := make([]string, 0, len(md.Tags))
+	for k, v := range md.Tags {
+		switch k {
+		case MeasurementTag:
+			measurement = v
+		case FieldTag:
+			field = v
+		default:
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	prefix := &strings.Builder{}
+	prefix.WriteString(measurementEscaper.Replace(measurement))
+	for _, k := range keys {
+		if md.Tags[k] == "" {
+			continue
+		}
+		fmt.Fprintf(prefix, ",%s=%s", keyEscaper.Replace(k), keyEscaper.Replace(md.Tags[k]))
+	}
+	fmt.Fprintf(prefix, " %s=", keyEscaper.Replace(field))
+
+	bw := bufio.NewWriter(w)
+	for _, dp := range dps {
+		var value string
+		switch md.Type {
+		case metrics.Gauge:
+			v, err := metrics.ConvertToFloat64(dp.Value)
+			if err != nil {
+				return err
+			}
+			value = strconv.FormatFloat(v, 'g', -1, 64)
+		case metrics.Counter:
+			v, err := metrics.ConvertToFloat64(dp.Value)
+			if err != nil {
+				return err
+			}
+			value = strconv.FormatInt(int64(v), 10) + "i"
+		case metrics.String, metrics.Availability:
+			value = `"` + stringEscaper.Replace(fmt.Sprint(dp.Value)) + `"`
+		default:
+			return fmt.Errorf("Metric type %s of %s can not be encoded", md.Type, md.ID)
+		}
+		fmt.Fprintf(bw, "%s%s %d\n", prefix.String(), value, dp.Timestamp.UnixNano())
+	}
+	return bw.Flush()
+}
+
+// Export reads the datapoints of the definitions with ReadRaw, in ascending order, and encodes them.
+// The modifiers are passed to ReadRaw, such as Filters with StartTimeFilter and EndTimeFilter.
+func Export(w io.Writer, c *metrics.Client, mds []*metrics.MetricDefinition, o ...metrics.Modifier) error {
+	o = append([]metrics.Modifier{metrics.Filters(metrics.OrderFilter(metrics.ASC))}, o...)
+	for _, md := range mds {
+		dps, err := c.ReadRaw(md.Type, md.ID, o...)
+		if err != nil {
+			return err
+		}
+		if err := Encode(w, md, dps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package influx
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+var (
+	// maxRequestSize limits the size of the request bodies
+	maxRequestSize int64 = 32 << 20
+	// maxDecodedSize limits the size of the decompressed request bodies
+	maxDecodedSize int64 = 128 << 20
+)
+
+// limitedReader fails the read that goes past n bytes and flags the body as exceeded, so that the
+// size is reported instead of the parse error of the cut line
+type limitedReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		l.exceeded = true
+		return n + int(l.n), fmt.Errorf("Request body is too large")
+	}
+	return n, err
+}
+
+// Precisions of the precision query parameter, in both InfluxDB 1.x and 2.x forms
+var precisions = map[string]time.Duration{
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+type writeHandler struct {
+	w metrics.Writer
+	o Options
+}
+
+// NewWriteHandler returns an InfluxDB compatible write endpoint, such as /write. The precision query
+// parameter overrides Options.Precision and gzip compressed bodies are accepted.
+func NewWriteHandler(w metrics.Writer, o Options) http.Handler {
+	o.defaults()
+	return &writeHandler{w: w, o: o}
+}
+
+func (h *writeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o := h.o
+	if p := r.URL.Query().Get("precision"); p != "" {
+		precision, found := precisions[p]
+		if !found {
+			http.Error(w, fmt.Sprintf("Unknown precision %s", p), http.StatusBadRequest)
+			return
+		}
+		o.Precision = precision
+	}
+
+	raw := &limitedReader{r: http.MaxBytesReader(w, r.Body, maxRequestSize+1), n: maxRequestSize}
+	body := raw
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(raw)
+		if err != nil {
+			bodyError(w, err, raw)
+			return
+		}
+		defer gz.Close()
+		body = &limitedReader{r: gz, n: maxDecodedSize}
+	}
+
+	mhs, err := Parse(body, time.Now(), o)
+	if err != nil {
+		bodyError(w, err, raw, body)
+		return
+	}
+
+	var mo []metrics.Modifier
+	if h.o.TenantParam != "" {
+		if tenant := r.URL.Query().Get(h.o.TenantParam); tenant != "" {
+			mo = append(mo, metrics.Tenant(tenant))
+		}
+	}
+	if len(mhs) > 0 {
+		if err := h.w.Write(mhs, mo...); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// bodyError responds with 413 if any of the limits was exceeded, otherwise the body is malformed
+func bodyError(w http.ResponseWriter, err error, limits ...*limitedReader) {
+	for _, l := range limits {
+		if l.exceeded {
+			http.Error(w, "Request body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,201 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/hawkular/hawkular-client-go/internal/testutil"
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+var now = time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+
+func TestParseLine(t *testing.T) {
+	p, err := ParseLine(`cpu\ load,host=node\,1,dc=east usage=0.5,count=3i,free=7u,up=t,msg="a \"b\", c=d" 1500000000000000001`, now, time.Nanosecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "cpu load", p.Measurement)
+	assert.Equal(t, map[string]string{"host": "node,1", "dc": "east"}, p.Tags)
+	assert.Equal(t, map[string]interface{}{"usage": 0.5, "count": int64(3), "free": uint64(7), "up": true, "msg": `a "b", c=d`}, p.Fields)
+	assert.Equal(t, time.Unix(0, 1500000000000000001), p.Time)
+
+	p, err = ParseLine("mem free=1", now, time.Second)
+	assert.NoError(t, err)
+	assert.Nil(t, p.Tags)
+	assert.Equal(t, now, p.Time)
+
+	p, err = ParseLine("mem free=1 1500000000", now, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1500000000, 0), p.Time)
+
+	for _, line := range []string{"mem", ",t=v f=1", "mem,t f=1", "mem f", "mem f=x", "mem f=\"open", "mem f=1 x", "mem f=NaN", "mem f=1 2 3"} {
+		_, err = ParseLine(line, now, time.Nanosecond)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestParse(t *testing.T) {
+	in := `# comment
+cpu,host=a usage=0.5,count=3i,up=false,state="ok" 1500000000000000000
+
+cpu,host=a usage=0.7 1500000001000000000
+`
+	mhs, err := Parse(strings.NewReader(in), now, Options{Tags: map[string]string{"source": "influx"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(mhs))
+
+	m := make(map[string]metrics.MetricHeader)
+	for _, mh := range mhs {
+		m[mh.ID] = mh
+	}
+	usage := m["cpu.usage;host=a"]
+	assert.Equal(t, metrics.MetricType(metrics.Gauge), usage.Type)
+	assert.Equal(t, 2, len(usage.Data))
+	assert.Equal(t, 0.7, usage.Data[1].Value)
+	assert.Equal(t, metrics.FromUnixMilli(1500000001000), usage.Data[1].Timestamp)
+	assert.Equal(t, map[string]string{"host": "a", "source": "influx", MeasurementTag: "cpu", FieldTag: "usage"}, usage.Tags)
+
+	assert.Equal(t, metrics.MetricType(metrics.Counter), m["cpu.count;host=a"].Type)
+	assert.Equal(t, int64(3), m["cpu.count;host=a"].Data[0].Value)
+	assert.Equal(t, 0.0, m["cpu.up;host=a"].Data[0].Value)
+	assert.Equal(t, metrics.MetricType(metrics.String), m["cpu.state;host=a"].Type)
+	assert.Equal(t, "ok", m["cpu.state;host=a"].Data[0].Value)
+
+	mhs, err = Parse(strings.NewReader("cpu count=3i"), now, Options{IntegersAsGauges: true})
+	assert.NoError(t, err)
+	assert.Equal(t, metrics.MetricType(metrics.Gauge), mhs[0].Type)
+	assert.Equal(t, 3.0, mhs[0].Data[0].Value)
+
+	_, err = Parse(strings.NewReader("cpu usage=1\ncpu usage=\n"), now, Options{})
+	assert.EqualError(t, err, "Line 2: Field usage of cpu: Missing value")
+	_, err = Parse(strings.NewReader("cpu big=18446744073709551615u"), now, Options{})
+	assert.Error(t, err)
+}
+
+func TestWriteHandler(t *testing.T) {
+	rec := &testutil.Recorder{}
+	h := NewWriteHandler(rec, Options{TenantParam: "db"})
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write([]byte("cpu usage=0.5 1500000000\n"))
+	gz.Close()
+
+	r := httptest.NewRequest("POST", "/write?db=ops&precision=s", buf)
+	r.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	last := rec.Last()
+	assert.Equal(t, "ops", last.Tenant)
+	assert.Equal(t, "cpu.usage", last.Metrics[0].ID)
+	assert.Equal(t, time.Unix(1500000000, 0), last.Metrics[0].Data[0].Timestamp)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/write?precision=x", strings.NewReader("cpu usage=1")))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/write", strings.NewReader("cpu")))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestWriteHandlerLimits(t *testing.T) {
+	defer func(request, decoded int64) {
+		maxRequestSize, maxDecodedSize = request, decoded
+	}(maxRequestSize, maxDecodedSize)
+	maxRequestSize, maxDecodedSize = 26, 60
+
+	rec := &testutil.Recorder{}
+	h := NewWriteHandler(rec, Options{})
+
+	// The last line would be cut to cpu value=12
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/write", strings.NewReader("cpu value=1 1\ncpu value=123 2\n")))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// The decompressed body is limited separately
+	maxRequestSize = 64
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write([]byte(strings.Repeat("cpu value=1 1\n", 20)))
+	gz.Close()
+	assert.True(t, int64(buf.Len()) <= maxRequestSize)
+
+	r := httptest.NewRequest("POST", "/write", buf)
+	r.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, 0, rec.Count())
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/write", strings.NewReader("cpu value=123 2\n")))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestEncode(t *testing.T) {
+	md := &metrics.MetricDefinition{Type: metrics.Gauge, ID: "cpu.usage;host=a", Tags: map[string]string{MeasurementTag: "cpu load", FieldTag: "usage", "host": "node,1", "dc": "east"}}
+	dps := []*metrics.Datapoint{{Timestamp: metrics.FromUnixMilli(1500000000000), Value: 0.5}, {Timestamp: metrics.FromUnixMilli(1500000001000), Value: 2.0}}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, Encode(buf, md, dps))
+	assert.Equal(t, "cpu\\ load,dc=east,host=node\\,1 usage=0.5 1500000000000000000\ncpu\\ load,dc=east,host=node\\,1 usage=2 1500000001000000000\n", buf.String())
+
+	// The lines parse back to the same metrics
+	mhs, err := Parse(buf, now, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, md.Tags, mhs[0].Tags)
+	assert.Equal(t, 2, len(mhs[0].Data))
+
+	buf.Reset()
+	assert.NoError(t, Encode(buf, &metrics.MetricDefinition{Type: metrics.String, ID: "log"}, []*metrics.Datapoint{{Timestamp: metrics.FromUnixMilli(1), Value: `say "hi"`}}))
+	assert.Equal(t, "log value=\"say \\\"hi\\\"\" 1000000\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, Encode(buf, &metrics.MetricDefinition{Type: metrics.Counter, ID: "hits"}, []*metrics.Datapoint{{Timestamp: metrics.FromUnixMilli(1), Value: 7.0}}))
+	assert.Equal(t, "hits value=7i 1000000\n", buf.String())
+}
+
+func TestExport(t *testing.T) {
+	var query string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/hawkular/metrics/counters/hits/raw", r.URL.Path)
+		query = r.URL.RawQuery
+		w.Write([]byte(`[{"timestamp":1000,"value":1},{"timestamp":2000,"value":3}]`))
+	}))
+	defer s.Close()
+
+	c, err := metrics.NewHawkularClient(metrics.Parameters{Tenant: "default", Url: s.URL})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	buf := &bytes.Buffer{}
+	mds := []*metrics.MetricDefinition{{Type: metrics.Counter, ID: "hits"}}
+	assert.NoError(t, Export(buf, c, mds, metrics.Filters(metrics.StartTimeFilter(metrics.FromUnixMilli(500)))))
+	assert.Equal(t, "hits value=1i 1000000000\nhits value=3i 2000000000\n", buf.String())
+	assert.Contains(t, query, "order=ASC")
+	assert.Contains(t, query, "start=500")
+}
@@ -0,0 +1,266 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package influx converts between the InfluxDB line protocol and Hawkular-Metrics.
+//
+// Each field of a line is a metric, "measurement,tag=value field=1.5,count=3i 1500000000000000000" is written
+// to the gauge measurement.field;tag=value and to the counter measurement.count;tag=value. The float and
+// boolean fields are gauges, the booleans as 1 and 0, the integer fields are counters and the string fields
+// are string metrics. The tags of the line, and the measurement and field names as the MeasurementTag and
+// FieldTag tags, are the definition tags. Export writes the datapoints back as line protocol.
+package influx
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// Definition tags holding the measurement and the field names
+const (
+	MeasurementTag = "_measurement"
+	FieldTag       = "_field"
+)
+
+// Point is a parsed line. The field values are float64, int64, uint64, string or bool.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// IDFunc builds the metric id of a field
+type IDFunc func(measurement, field string, tags map[string]string) string
+
+// DefaultID joins the measurement and the field with a dot and appends the tags in the Graphite
+// ";tag=value" form, sorted by name
+func DefaultID(measurement, field string, tags map[string]string) string {
+	return metrics.TaggedID(measurement+"."+field, tags)
+}
+
+// Options configures the conversion of the lines
+type Options struct {
+	ID               IDFunc            // Defaults to DefaultID
+	Precision        time.Duration     // Unit of the timestamps, defaults to nanoseconds
+	Tags             map[string]string // Added to every definition, but not to the ids
+	IntegersAsGauges bool              // Stores the integer fields as gauges
+
+	// The tenant of the write handler requests is read from the TenantParam query parameter, such as db.
+	// The tenant of the client is used if it is not set.
+	TenantParam string
+}
+
+func (o *Options) defaults() {
+	if o.ID == nil {
+		o.ID = DefaultID
+	}
+	if o.Precision <= 0 {
+		o.Precision = time.Nanosecond
+	}
+}
+
+// Parse reads the lines and converts the fields to metrics, merging the datapoints of the same metric.
+// The points without a timestamp are timestamped with now.
+func Parse(r io.Reader, now time.Time, o Options) ([]metrics.MetricHeader, error) {
+	o.defaults()
+
+	mhs := []metrics.MetricHeader{}
+	index := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		p, err := ParseLine(line, now, o.Precision)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", n, err.Error())
+		}
+
+		for field, value := range p.Fields {
+			mh, err := header(p, field, value, &o)
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %s", n, err.Error())
+			}
+			key := string(mh.Type) + "/" + mh.ID
+			if i, found := index[key]; found {
+				mhs[i].Data = append(mhs[i].Data, mh.Data...)
+				continue
+			}
+			index[key] = len(mhs)
+			mhs = append(mhs, mh)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mhs, nil
+}
+
+// header converts a field of the point to a metric with a single datapoint
+func header(p Point, field string, value interface{}, o *Options) (metrics.MetricHeader, error) {
+	mh := metrics.MetricHeader{ID: o.ID(p.Measurement, field, p.Tags)}
+
+	switch v := value.(type) {
+	case float64:
+		mh.Type = metrics.Gauge
+	case bool:
+		mh.Type = metrics.Gauge
+		value = 0.0
+		if v {
+			value = 1.0
+		}
+	case string:
+		mh.Type = metrics.String
+	case uint64:
+		if v > math.MaxInt64 {
+			return mh, fmt.Errorf("Field %s value %d overflows int64", field, v)
+		}
+		value = int64(v)
+		mh.Type = metrics.Counter
+	case int64:
+		mh.Type = metrics.Counter
+	}
+	if mh.Type == metrics.Counter && o.IntegersAsGauges {
+		mh.Type = metrics.Gauge
+		value = float64(value.(int64))
+	}
+
+	mh.Tags = make(map[string]string, len(o.Tags)+len(p.Tags)+2)
+	for _, m := range []map[string]string{o.Tags, p.Tags} {
+		for k, v := range m {
+			mh.Tags[k] = v
+		}
+	}
+	mh.Tags[MeasurementTag] = p.Measurement
+	mh.Tags[FieldTag] = field
+
+	mh.Data = []metrics.Datapoint{{Timestamp: p.Time, Value: value}}
+	return mh, nil
+}
+
+// ParseLine parses a single line, the timestamp is in precision units
+func ParseLine(line string, now time.Time, precision time.Duration) (Point, error) {
+	p := Point{Time: now}
+
+	sections := split(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return p, fmt.Errorf("Line %q is not in measurement[,tags] fields [timestamp] format", line)
+	}
+
+	keys := split(sections[0], ',', false)
+	p.Measurement = unescape(keys[0])
+	if p.Measurement == "" {
+		return p, fmt.Errorf("Missing measurement")
+	}
+	if len(keys) > 1 {
+		p.Tags = make(map[string]string, len(keys)-1)
+	}
+	for _, tag := range keys[1:] {
+		kv := split(tag, '=', false)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return p, fmt.Errorf("Tag %s of %s is not in key=value format", tag, p.Measurement)
+		}
+		p.Tags[unescape(kv[0])] = unescape(kv[1])
+	}
+
+	p.Fields = make(map[string]interface{})
+	for _, field := range split(sections[1], ',', true) {
+		kv := split(field, '=', true)
+		if len(kv) != 2 || kv[0] == "" {
+			return p, fmt.Errorf("Field %s of %s is not in key=value format", field, p.Measurement)
+		}
+		v, err := parseValue(kv[1])
+		if err != nil {
+			return p, fmt.Errorf("Field %s of %s: %s", kv[0], p.Measurement, err.Error())
+		}
+		p.Fields[unescape(kv[0])] = v
+	}
+
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("Invalid timestamp %s of %s", sections[2], p.Measurement)
+		}
+		p.Time = time.Unix(0, ts*int64(precision))
+	}
+	return p, nil
+}
+
+func parseValue(s string) (interface{}, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("Missing value")
+	case s[0] == '"':
+		if len(s) < 2 || s[len(s)-1] != '"' {
+			return nil, fmt.Errorf("Unterminated string %s", s)
+		}
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s[1 : len(s)-1]), nil
+	case s == "t" || s == "T" || s == "true" || s == "True" || s == "TRUE":
+		return true, nil
+	case s == "f" || s == "F" || s == "false" || s == "False" || s == "FALSE":
+		return false, nil
+	case strings.HasSuffix(s, "i"):
+		return strconv.ParseInt(s[:len(s)-1], 10, 64)
+	case strings.HasSuffix(s, "u"):
+		return strconv.ParseUint(s[:len(s)-1], 10, 64)
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return nil, fmt.Errorf("Invalid value %s", s)
+	}
+	return v, err
+}
+
+// split splits s at the unescaped separators, outside of the double quoted strings if quotes is set.
+// Empty parts between repeated spaces are dropped.
+func split(s string, sep byte, quotes bool) []string {
+	parts := []string{}
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"' && quotes:
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			if sep != ' ' || i > start {
+				parts = append(parts, s[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if sep != ' ' || len(s) > start {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
+var unescaper = strings.NewReplacer(`\,`, ",", `\=`, "=", `\ `, " ")
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
@@ -0,0 +1,76 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Logger receives the client's log events as a message and alternating key-value pairs.
+// *slog.Logger from log/slog implements this interface.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Debug(msg string, args ...interface{}) {}
+func (noopLogger) Warn(msg string, args ...interface{})  {}
+
+const saturationFactor = 10
+
+// requestURL returns the request path without the server address. The credentials are
+// never part of it, they travel in the headers which are not logged.
+func requestURL(r *http.Request) string {
+	if r.URL == nil {
+		return ""
+	}
+	if r.URL.RawQuery != "" {
+		return r.URL.Opaque + "?" + r.URL.RawQuery
+	}
+	return r.URL.Opaque
+}
+
+func (c *Client) logRequest(r *http.Request, resp *http.Response, err error, d time.Duration) {
+	args := []interface{}{
+		"method", r.Method,
+		"url", requestURL(r),
+		"tenant", r.Header.Get(tenantHeader),
+		"duration", d,
+	}
+	if err != nil {
+		c.logger.Debug("Hawkular request failed", append(args, "error", err)...)
+		return
+	}
+	c.logger.Debug("Hawkular request", append(args, "status", resp.StatusCode)...)
+}
+
+// checkSaturation warns once when the queue grows over the threshold and rearms after it
+// has dropped below half of it
+func (c *Client) checkSaturation(depth int) {
+	if depth >= c.saturation {
+		if atomic.CompareAndSwapInt32(&c.saturated, 0, 1) {
+			c.logger.Warn("Hawkular request pool is saturated", "queued", depth, "threshold", c.saturation)
+		}
+	} else if depth < c.saturation/2 {
+		atomic.StoreInt32(&c.saturated, 0)
+	}
+}
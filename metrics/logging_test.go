@@ -0,0 +1,99 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+type lockedBuffer struct {
+	lock sync.Mutex
+	b    bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *lockedBuffer) String() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.b.String()
+}
+
+func TestSlogLogger(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errorMsg":"invalid"}`))
+	}))
+	defer s.Close()
+
+	out := &lockedBuffer{}
+	logger := slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	c, err := NewHawkularClient(Parameters{
+		Tenant:   "logged",
+		Url:      s.URL,
+		Username: "user",
+		Password: "secretpassword",
+		Logger:   logger,
+	})
+	assert.NoError(t, err)
+
+	dp := []Datapoint{{Value: 1.0, Timestamp: time.Now()}}
+	err = c.Write([]MetricHeader{{ID: "a", Type: Gauge, Data: dp}, {ID: "b", Type: Counter, Data: dp}})
+	assert.Error(t, err)
+
+	log := out.String()
+	assert.Contains(t, log, `level=DEBUG msg="Hawkular request"`)
+	assert.Contains(t, log, "url=/hawkular/metrics/gauges/raw")
+	assert.Contains(t, log, "tenant=logged")
+	assert.Contains(t, log, "status=400")
+	assert.Contains(t, log, `level=WARN msg="Hawkular write error dropped"`)
+	assert.False(t, strings.Contains(log, "secretpassword") || strings.Contains(log, c.Credentials), "Credentials should not be logged")
+}
+
+func TestReportStatsLogsErrors(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errorMsg":"invalid"}`))
+	}))
+	defer s.Close()
+
+	out := &lockedBuffer{}
+	c, err := NewHawkularClient(Parameters{Tenant: "reported", Url: s.URL, Logger: slog.New(slog.NewTextHandler(out, nil))})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, c.ReportStats(ctx, 10*time.Millisecond, "client"))
+	assert.Contains(t, out.String(), `msg="Hawkular statistics report failed"`)
+	assert.NoError(t, c.Close())
+}
@@ -0,0 +1,423 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package migrate copies metric definitions, tags and raw datapoints from one tenant to another,
+// on the same Hawkular-Metrics cluster or between two clusters.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// Rule rewrites the metric ids matching Pattern, Replacement may refer to the submatches as in regexp.Expand
+type Rule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// ParseRule parses a rule in pattern=replacement format. The rule is split at the first equal sign not
+// escaped with a backslash, so the equal signs of the pattern are written as \= and the replacement may
+// contain equal signs.
+func ParseRule(s string) (Rule, error) {
+	i := -1
+	for j := 0; j < len(s) && i < 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '=':
+			i = j
+		}
+	}
+	if i < 1 {
+		return Rule{}, fmt.Errorf("Rule %s is not in pattern=replacement format", s)
+	}
+	p, err := regexp.Compile(s[:i])
+	if err != nil {
+		return Rule{}, fmt.Errorf("Invalid rule pattern %s: %s", s[:i], err.Error())
+	}
+	return Rule{Pattern: p, Replacement: s[i+1:]}, nil
+}
+
+// TagTransform modifies the definition tags of a copied metric. The given map is a copy and may be modified.
+type TagTransform func(tags map[string]string) map[string]string
+
+// RenameTag renames the tag
+func RenameTag(from, to string) TagTransform {
+	return func(tags map[string]string) map[string]string {
+		if v, found := tags[from]; found {
+			delete(tags, from)
+			tags[to] = v
+		}
+		return tags
+	}
+}
+
+// DropTags removes the tags
+func DropTags(names ...string) TagTransform {
+	return func(tags map[string]string) map[string]string {
+		for _, name := range names {
+			delete(tags, name)
+		}
+		return tags
+	}
+}
+
+// SetTags adds the tags, replacing the existing values
+func SetTags(set map[string]string) TagTransform {
+	return func(tags map[string]string) map[string]string {
+		for k, v := range set {
+			tags[k] = v
+		}
+		return tags
+	}
+}
+
+// Options configures the copy
+type Options struct {
+	SourceTenant string // Defaults to the tenant of the source client
+	TargetTenant string // Defaults to the tenant of the target client
+
+	Filters []metrics.Filter // Select the copied definitions, such as TypeFilter or TagsQueryFilter
+
+	// Time range of the copied datapoints. A zero Start copies from the earliest datapoint,
+	// a zero End up to the time the copy was started.
+	Start time.Time
+	End   time.Time
+
+	Rules []Rule         // The first rule matching the id rewrites it
+	Tags  []TagTransform // Applied in order to the definition tags
+
+	// RemoveTags deletes the source tags dropped or renamed by the Tags transforms from the existing
+	// targets, for example from an earlier copy. The tags of a target which is the source metric itself
+	// are never deleted.
+	RemoveTags bool
+
+	Parallelism int // Metrics copied concurrently, defaults to 4
+	PageSize    int // Datapoints per read and write, defaults to 10000
+
+	Progress func(Progress) // Called after every written page and copied metric, never concurrently
+	Verify   bool           // Compare the datapoint counts of the gauges and counters after the copy
+}
+
+// Progress of the copy
+type Progress struct {
+	Metrics    int    // Metrics to copy
+	Copied     int    // Metrics copied
+	Datapoints int64  // Datapoints written
+	ID         string // Source id of the metric that was written
+}
+
+// Mismatch is a metric with a different datapoint count in the source and the target
+type Mismatch struct {
+	Type     metrics.MetricType
+	SourceID string
+	TargetID string
+	Source   uint64
+	Target   uint64
+}
+
+// VerifyError is returned when the datapoint counts of the source and the target differ
+type VerifyError struct {
+	Mismatches []Mismatch
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("Datapoint counts differ for %d metrics", len(e.Mismatches))
+}
+
+// Result summarizes a copy
+type Result struct {
+	Metrics    int
+	Datapoints int64
+	Verified   int // Metrics with equal datapoint counts
+}
+
+// task is a single metric to copy
+type task struct {
+	source *metrics.MetricDefinition
+	target metrics.MetricDefinition
+}
+
+// removedTags returns the sorted names of the source tags the target does not have
+func (t *task) removedTags() []string {
+	removed := make([]string, 0)
+	for k := range t.source.Tags {
+		if _, found := t.target.Tags[k]; !found {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// copier copies metrics from the source client to the target client
+type copier struct {
+	source *metrics.Client
+	target *metrics.Client
+	o      Options
+
+	lock     sync.Mutex
+	progress Progress
+}
+
+// Copy copies the definitions selected by the filters with their tags and datapoints. Existing target
+// definitions get the transformed tags. With Verify a *VerifyError lists the metrics whose datapoint
+// counts differ after the copy.
+func Copy(ctx context.Context, source, target *metrics.Client, o Options) (*Result, error) {
+	if o.SourceTenant == "" {
+		o.SourceTenant = source.Tenant
+	}
+	if o.TargetTenant == "" {
+		o.TargetTenant = target.Tenant
+	}
+	if o.End.IsZero() {
+		o.End = time.Now()
+	}
+	if o.Parallelism <= 0 {
+		o.Parallelism = 4
+	}
+	if o.PageSize <= 0 {
+		o.PageSize = 10000
+	}
+
+	c := &copier{source: source, target: target, o: o}
+	tasks, err := c.plan()
+	if err != nil {
+		return nil, err
+	}
+	c.progress.Metrics = len(tasks)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan *task)
+	errs := make(chan error, o.Parallelism)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < o.Parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range ch {
+				if err := c.copy(ctx, t); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, t := range tasks {
+		select {
+		case ch <- t:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(ch)
+	wg.Wait()
+	close(errs)
+
+	res := &Result{Metrics: c.progress.Copied, Datapoints: c.progress.Datapoints}
+	if err := <-errs; err != nil {
+		return res, err
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	if o.Verify {
+		return res, c.verify(tasks, res)
+	}
+	return res, nil
+}
+
+// plan reads the source definitions and maps them to the target definitions
+func (c *copier) plan() ([]*task, error) {
+	defs, err := c.source.Definitions(metrics.Tenant(c.o.SourceTenant), metrics.Filters(c.o.Filters...))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		if defs[i].Type != defs[j].Type {
+			return defs[i].Type < defs[j].Type
+		}
+		return defs[i].ID < defs[j].ID
+	})
+
+	tasks := make([]*task, 0, len(defs))
+	targets := make(map[string]string, len(defs))
+	for _, md := range defs {
+		t := &task{source: md, target: metrics.MetricDefinition{Type: md.Type, ID: c.rewrite(md.ID), RetentionTime: md.RetentionTime}}
+
+		key := string(md.Type) + "/" + t.target.ID
+		if other, found := targets[key]; found {
+			return nil, fmt.Errorf("Metrics %s and %s would both be copied to %s", other, md.ID, t.target.ID)
+		}
+		targets[key] = md.ID
+
+		tags := make(map[string]string, len(md.Tags))
+		for k, v := range md.Tags {
+			tags[k] = v
+		}
+		for _, f := range c.o.Tags {
+			tags = f(tags)
+		}
+		if len(tags) > 0 {
+			t.target.Tags = tags
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, nil
+}
+
+func (c *copier) rewrite(id string) string {
+	for _, r := range c.o.Rules {
+		if m := r.Pattern.FindStringSubmatchIndex(id); m != nil {
+			return string(r.Pattern.ExpandString(nil, r.Replacement, id, m))
+		}
+	}
+	return id
+}
+
+// isSource checks if the target of the task is the source metric, copied to the same client and tenant
+func (c *copier) isSource(t *task) bool {
+	return c.source == c.target && c.o.SourceTenant == c.o.TargetTenant && t.source.Type == t.target.Type && t.source.ID == t.target.ID
+}
+
+// copy creates the target definition and copies the datapoints page by page
+func (c *copier) copy(ctx context.Context, t *task) error {
+	created, err := c.target.Create(t.target, metrics.Tenant(c.o.TargetTenant))
+	if err != nil {
+		return err
+	}
+	if !created {
+		if len(t.target.Tags) > 0 {
+			if err := c.target.UpdateTags(t.target.Type, t.target.ID, t.target.Tags, metrics.Tenant(c.o.TargetTenant)); err != nil {
+				return err
+			}
+		}
+		// The tags dropped or renamed by the transforms may have been copied earlier
+		if removed := t.removedTags(); len(removed) > 0 && c.o.RemoveTags && !c.isSource(t) {
+			if err := c.target.DeleteTags(t.target.Type, t.target.ID, removed, metrics.Tenant(c.o.TargetTenant)); err != nil {
+				return err
+			}
+		}
+	}
+
+	start := c.o.Start
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		f := []metrics.Filter{metrics.EndTimeFilter(c.o.End), metrics.OrderFilter(metrics.ASC), metrics.LimitFilter(c.o.PageSize)}
+		if start.IsZero() {
+			f = append(f, metrics.StartFromBeginningFilter())
+		} else {
+			f = append(f, metrics.StartTimeFilter(start))
+		}
+		dps, err := c.source.ReadRaw(t.source.Type, t.source.ID, metrics.Tenant(c.o.SourceTenant), metrics.Filters(f...))
+		if err != nil {
+			return err
+		}
+
+		if len(dps) > 0 {
+			mh := metrics.MetricHeader{Type: t.target.Type, ID: t.target.ID, Data: make([]metrics.Datapoint, 0, len(dps))}
+			for _, dp := range dps {
+				mh.Data = append(mh.Data, *dp)
+			}
+			if err := c.target.Write([]metrics.MetricHeader{mh}, metrics.Tenant(c.o.TargetTenant)); err != nil {
+				return err
+			}
+			start = dps[len(dps)-1].Timestamp.Add(time.Millisecond)
+		}
+
+		done := len(dps) < c.o.PageSize
+		c.report(t.source.ID, int64(len(dps)), done)
+		if done {
+			return nil
+		}
+	}
+}
+
+func (c *copier) report(id string, datapoints int64, done bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.progress.Datapoints += datapoints
+	c.progress.ID = id
+	if done {
+		c.progress.Copied++
+	}
+	if c.o.Progress != nil {
+		c.o.Progress(c.progress)
+	}
+}
+
+// verify compares the sample counts of a single stats bucket over the copied time range. The server
+// has no sample counts for strings and availabilities, they are not verified.
+func (c *copier) verify(tasks []*task, res *Result) error {
+	start := c.o.Start
+	if start.IsZero() {
+		start = metrics.FromUnixMilli(0)
+	}
+
+	var mismatches []Mismatch
+	for _, t := range tasks {
+		if t.source.Type != metrics.Gauge && t.source.Type != metrics.Counter {
+			continue
+		}
+		s, err := samples(c.source, t.source.Type, t.source.ID, start, c.o.End, c.o.SourceTenant)
+		if err != nil {
+			return err
+		}
+		d, err := samples(c.target, t.target.Type, t.target.ID, start, c.o.End, c.o.TargetTenant)
+		if err != nil {
+			return err
+		}
+		if s != d {
+			mismatches = append(mismatches, Mismatch{Type: t.source.Type, SourceID: t.source.ID, TargetID: t.target.ID, Source: s, Target: d})
+			continue
+		}
+		res.Verified++
+	}
+	if len(mismatches) > 0 {
+		return &VerifyError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+func samples(c *metrics.Client, t metrics.MetricType, id string, start, end time.Time, tenant string) (uint64, error) {
+	bps, err := c.ReadBuckets(t, metrics.Tenant(tenant), metrics.Filters(
+		metrics.MetricsFilter([]string{id}), metrics.StartTimeFilter(start), metrics.EndTimeFilter(end), metrics.BucketsFilter(1)))
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, bp := range bps {
+		n += bp.Samples
+	}
+	return n, nil
+}
@@ -0,0 +1,149 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package migrate
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/hawkular/hawkular-client-go/internal/testutil"
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+func TestCopy(t *testing.T) {
+	src := testutil.NewStore("old")
+	src.Add(metrics.MetricDefinition{Type: metrics.Gauge, ID: "node-1.cpu", Tags: map[string]string{"host": "node-1", "tmp": "x"}, RetentionTime: 14}, 25, nil)
+	src.Add(metrics.MetricDefinition{Type: metrics.Gauge, ID: "node-2.cpu", Tags: map[string]string{"host": "node-2"}}, 7, nil)
+	src.Add(metrics.MetricDefinition{Type: metrics.Counter, ID: "requests"}, 0, nil)
+	src.Add(metrics.MetricDefinition{Type: metrics.String, ID: "log"}, 3, nil)
+	sc, sclose := testutil.NewClient(t, src)
+	defer sclose()
+
+	dst := testutil.NewStore("new")
+	// Copied earlier without the tag transforms
+	dst.Add(metrics.MetricDefinition{Type: metrics.Gauge, ID: "cpu.node-2", Tags: map[string]string{"keep": "1", "host": "node-2"}}, 0, nil)
+	dc, dclose := testutil.NewClient(t, dst)
+	defer dclose()
+
+	lock := &sync.Mutex{}
+	reports := []Progress{}
+	res, err := Copy(context.Background(), sc, dc, Options{
+		End:         testutil.Start.Add(time.Hour),
+		Rules:       []Rule{{Pattern: regexp.MustCompile(`^(node-\d+)\.cpu$`), Replacement: "cpu.$1"}},
+		Tags:        []TagTransform{RenameTag("host", "node"), DropTags("tmp"), SetTags(map[string]string{"migrated": "true"})},
+		RemoveTags:  true,
+		Parallelism: 3,
+		PageSize:    10,
+		Verify:      true,
+		Progress: func(p Progress) {
+			lock.Lock()
+			defer lock.Unlock()
+			reports = append(reports, p)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, res.Metrics)
+	assert.Equal(t, int64(35), res.Datapoints)
+	assert.Equal(t, 3, res.Verified)
+
+	last := reports[len(reports)-1]
+	assert.Equal(t, Progress{Metrics: 4, Copied: 4, Datapoints: 35}, Progress{Metrics: last.Metrics, Copied: last.Copied, Datapoints: last.Datapoints})
+
+	assert.Equal(t, src.Data["gauge/node-1.cpu"], dst.Data["gauge/cpu.node-1"])
+	assert.Equal(t, 7, len(dst.Data["gauge/cpu.node-2"]))
+	assert.Equal(t, 3, len(dst.Data["string/log"]))
+	assert.Equal(t, map[string]string{"node": "node-1", "migrated": "true"}, dst.Defs["gauge/cpu.node-1"].Tags)
+	assert.Equal(t, 14, dst.Defs["gauge/cpu.node-1"].RetentionTime)
+	assert.Equal(t, map[string]string{"keep": "1", "node": "node-2", "migrated": "true"}, dst.Defs["gauge/cpu.node-2"].Tags)
+	assert.NotNil(t, dst.Defs["counter/requests"])
+}
+
+func TestCopyKeepsSourceTags(t *testing.T) {
+	s := testutil.NewStore("tenant")
+	s.Add(metrics.MetricDefinition{Type: metrics.Gauge, ID: "cpu", Tags: map[string]string{"host": "a", "tmp": "x"}}, 1, nil)
+	c, closer := testutil.NewClient(t, s)
+	defer closer()
+
+	// The target of the copy to the same client and tenant is the source itself
+	_, err := Copy(context.Background(), c, c, Options{End: testutil.Start.Add(time.Hour), Tags: []TagTransform{DropTags("tmp")}, RemoveTags: true})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "a", "tmp": "x"}, s.Defs["gauge/cpu"].Tags)
+
+	// Without RemoveTags the existing targets keep their tags
+	dst := testutil.NewStore("other")
+	dst.Add(metrics.MetricDefinition{Type: metrics.Gauge, ID: "cpu", Tags: map[string]string{"tmp": "y"}}, 0, nil)
+	dc, dclose := testutil.NewClient(t, dst)
+	defer dclose()
+	_, err = Copy(context.Background(), c, dc, Options{End: testutil.Start.Add(time.Hour), Tags: []TagTransform{DropTags("tmp")}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "a", "tmp": "y"}, dst.Defs["gauge/cpu"].Tags)
+}
+
+func TestCopyVerify(t *testing.T) {
+	src := testutil.NewStore("tenant")
+	src.Add(metrics.MetricDefinition{Type: metrics.Gauge, ID: "cpu"}, 5, nil)
+	src.Add(metrics.MetricDefinition{Type: metrics.Counter, ID: "requests"}, 5, nil)
+	sc, sclose := testutil.NewClient(t, src)
+	defer sclose()
+
+	dst := testutil.NewStore("tenant")
+	dst.Dropping = true
+	dc, dclose := testutil.NewClient(t, dst)
+	defer dclose()
+
+	_, err := Copy(context.Background(), sc, dc, Options{End: testutil.Start.Add(time.Hour), Filters: []metrics.Filter{metrics.TypeFilter(metrics.Gauge)}, Verify: true})
+	assert.Error(t, err)
+	ve, ok := err.(*VerifyError)
+	assert.True(t, ok)
+	assert.Equal(t, []Mismatch{{Type: metrics.Gauge, SourceID: "cpu", TargetID: "cpu", Source: 5, Target: 0}}, ve.Mismatches)
+}
+
+func TestCopyErrors(t *testing.T) {
+	src := testutil.NewStore("tenant")
+	src.Add(metrics.MetricDefinition{Type: metrics.Gauge, ID: "a.cpu"}, 1, nil)
+	src.Add(metrics.MetricDefinition{Type: metrics.Gauge, ID: "b.cpu"}, 1, nil)
+	sc, sclose := testutil.NewClient(t, src)
+	defer sclose()
+
+	// Both rewritten to the same id
+	rule, err := ParseRule(`^.*\.(cpu)$=$1`)
+	assert.NoError(t, err)
+	_, err = Copy(context.Background(), sc, sc, Options{Rules: []Rule{rule}})
+	assert.EqualError(t, err, "Metrics a.cpu and b.cpu would both be copied to cpu")
+
+	rule, err = ParseRule(`^a\=(.*)$=key=$1`)
+	assert.NoError(t, err)
+	assert.Equal(t, "key=b", rule.Pattern.ReplaceAllString("a=b", rule.Replacement))
+
+	_, err = ParseRule("no-replacement")
+	assert.Error(t, err)
+	_, err = ParseRule("(=x")
+	assert.Error(t, err)
+
+	// The target rejects the writes of another tenant
+	dst := testutil.NewStore("other")
+	dc, dclose := testutil.NewClient(t, dst)
+	defer dclose()
+	_, err = Copy(context.Background(), sc, dc, Options{TargetTenant: "tenant"})
+	assert.Error(t, err)
+}
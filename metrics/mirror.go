@@ -0,0 +1,356 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MirrorConsistency defines when a mirrored write is successful
+type MirrorConsistency int
+
+const (
+	// MirrorPrimaryRequired writes to the primary and queues the write to the secondary whatever the
+	// result of the primary, only a failure of the primary is returned
+	MirrorPrimaryRequired MirrorConsistency = iota
+	// MirrorBothRequired writes to both clusters and fails if either of them fails, without buffering
+	MirrorBothRequired
+	// MirrorBestEffort writes to the primary and queues the write to the secondary after the primary succeeded
+	MirrorBestEffort
+)
+
+const defaultMirrorBufferSize = 1000
+
+// ErrMirrorBufferFull is reported as the divergence error when the secondary queue overflows
+var ErrMirrorBufferFull = fmt.Errorf("Secondary write buffer is full")
+
+// MirrorOptions configures the Mirror
+type MirrorOptions struct {
+	Consistency MirrorConsistency
+	BufferSize  int // Queued secondary writes, defaults to 1000. Not used with MirrorBothRequired.
+
+	// OnDivergence is called whenever a write succeeded in only one of the clusters
+	OnDivergence func(MirrorDivergence)
+}
+
+// MirrorDivergence describes a write which is missing from one of the clusters
+type MirrorDivergence struct {
+	Secondary bool           // True if the secondary is missing the write, otherwise the primary
+	Command   string         // Name of the mirrored command, such as Write or UpdateTags
+	Metrics   []MetricHeader // Written datapoints, or the modified definition without data
+	Err       error
+	Time      time.Time
+}
+
+// MirrorStats counts the mirrored writes and divergences
+type MirrorStats struct {
+	Writes           int64 // Mirrored commands
+	PrimaryFailed    int64 // Writes which succeeded only in the secondary
+	SecondaryFailed  int64 // Writes which succeeded only in the primary
+	SecondaryDropped int64 // Writes dropped because the secondary buffer was full
+	Pending          int64 // Writes waiting in the secondary buffer or being written
+}
+
+// MirrorError is returned with MirrorBothRequired when either of the clusters failed
+type MirrorError struct {
+	Primary   error
+	Secondary error
+}
+
+func (e *MirrorError) Error() string {
+	switch {
+	case e.Primary != nil && e.Secondary != nil:
+		return fmt.Sprintf("Primary write failed: %s, secondary write failed: %s", e.Primary.Error(), e.Secondary.Error())
+	case e.Primary != nil:
+		return fmt.Sprintf("Primary write failed: %s", e.Primary.Error())
+	}
+	return fmt.Sprintf("Secondary write failed: %s", e.Secondary.Error())
+}
+
+type mirrorOp struct {
+	command string
+	metrics []MetricHeader
+	apply   func(*Client) error
+	primary error // Result of the primary, compared to the secondary when it has been written
+}
+
+// Mirror duplicates the writes of a primary Client to a secondary one, for example during a migration
+// between clusters. Datapoint writes and definition changes are mirrored, all the reads are served by
+// the primary through the embedded Client.
+type Mirror struct {
+	*Client
+	secondary *Client
+	options   MirrorOptions
+
+	closeLock sync.RWMutex
+	closed    bool
+	queue     chan mirrorOp
+	done      chan struct{}
+
+	writes           int64
+	pending          int64
+	primaryFailed    int64
+	secondaryFailed  int64
+	secondaryDropped int64
+}
+
+// NewMirror returns a Mirror writing to both clients. The Mirror owns the clients and closes them in Close.
+func NewMirror(primary, secondary *Client, options MirrorOptions) *Mirror {
+	if options.BufferSize <= 0 {
+		options.BufferSize = defaultMirrorBufferSize
+	}
+	m := &Mirror{
+		Client:    primary,
+		secondary: secondary,
+		options:   options,
+		done:      make(chan struct{}),
+	}
+	if options.Consistency == MirrorBothRequired {
+		close(m.done)
+	} else {
+		m.queue = make(chan mirrorOp, options.BufferSize)
+		go m.drain()
+	}
+	return m
+}
+
+// Secondary returns the client of the secondary cluster
+func (m *Mirror) Secondary() *Client {
+	return m.secondary
+}
+
+// Write writes the datapoints to both clusters
+func (m *Mirror) Write(metrics []MetricHeader, o ...Modifier) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	if m.options.Consistency != MirrorBothRequired {
+		// The secondary write outlives the call, the caller may reuse the slices once it returns
+		metrics = copyHeaders(metrics)
+	}
+	return m.run(mirrorOp{
+		command: "Write",
+		metrics: metrics,
+		apply: func(c *Client) error {
+			return c.Write(metrics, o...)
+		},
+	})
+}
+
+// Create creates the metric definition in both clusters, the result of the primary is returned
+func (m *Mirror) Create(md MetricDefinition, o ...Modifier) (bool, error) {
+	var created bool
+	err := m.run(mirrorOp{
+		command: "Create",
+		metrics: []MetricHeader{{Tenant: md.Tenant, Type: md.Type, ID: md.ID}},
+		apply: func(c *Client) error {
+			ok, err := c.Create(md, o...)
+			if c == m.Client {
+				created = ok
+			}
+			return err
+		},
+	})
+	return created, err
+}
+
+// UpdateTags modifies the tags of the metric definition in both clusters
+func (m *Mirror) UpdateTags(t MetricType, id string, tags map[string]string, o ...Modifier) error {
+	return m.run(mirrorOp{
+		command: "UpdateTags",
+		metrics: []MetricHeader{{Type: t, ID: id}},
+		apply: func(c *Client) error {
+			return c.UpdateTags(t, id, tags, o...)
+		},
+	})
+}
+
+// DeleteTags deletes the tags of the metric definition in both clusters
+func (m *Mirror) DeleteTags(t MetricType, id string, tags []string, o ...Modifier) error {
+	return m.run(mirrorOp{
+		command: "DeleteTags",
+		metrics: []MetricHeader{{Type: t, ID: id}},
+		apply: func(c *Client) error {
+			return c.DeleteTags(t, id, tags, o...)
+		},
+	})
+}
+
+// Delete deletes the metric from both clusters
+func (m *Mirror) Delete(t MetricType, id string, o ...Modifier) error {
+	return m.run(mirrorOp{
+		command: "Delete",
+		metrics: []MetricHeader{{Type: t, ID: id}},
+		apply: func(c *Client) error {
+			return c.Delete(t, id, o...)
+		},
+	})
+}
+
+func (m *Mirror) run(op mirrorOp) error {
+	atomic.AddInt64(&m.writes, 1)
+
+	if m.options.Consistency != MirrorBothRequired {
+		// The secondary is written in the background so that it can not slow down the primary
+		op.primary = op.apply(m.Client)
+		if op.primary == nil || m.options.Consistency == MirrorPrimaryRequired {
+			m.enqueue(op)
+		}
+		return op.primary
+	}
+
+	var serr error
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serr = op.apply(m.secondary)
+	}()
+	perr := op.apply(m.Client)
+	wg.Wait()
+
+	switch {
+	case perr != nil && serr == nil:
+		m.diverge(op, false, perr)
+	case perr == nil && serr != nil:
+		m.diverge(op, true, serr)
+	}
+
+	if perr != nil || serr != nil {
+		return &MirrorError{Primary: perr, Secondary: serr}
+	}
+	return nil
+}
+
+func copyHeaders(mhs []MetricHeader) []MetricHeader {
+	c := make([]MetricHeader, len(mhs))
+	for i, mh := range mhs {
+		c[i] = mh
+		c[i].Tags = copyTags(mh.Tags)
+		c[i].Data = make([]Datapoint, len(mh.Data))
+		for j, dp := range mh.Data {
+			c[i].Data[j] = dp
+			c[i].Data[j].Tags = copyTags(dp.Tags)
+		}
+	}
+	return c
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	if tags == nil {
+		return nil
+	}
+	c := make(map[string]string, len(tags))
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
+
+func (m *Mirror) enqueue(op mirrorOp) {
+	m.closeLock.RLock()
+	defer m.closeLock.RUnlock()
+
+	if m.closed {
+		if op.primary == nil {
+			m.diverge(op, true, ErrClientClosed)
+		}
+		return
+	}
+
+	atomic.AddInt64(&m.pending, 1)
+	select {
+	case m.queue <- op:
+	default:
+		atomic.AddInt64(&m.pending, -1)
+		if op.primary == nil {
+			atomic.AddInt64(&m.secondaryDropped, 1)
+			m.diverge(op, true, ErrMirrorBufferFull)
+		}
+	}
+}
+
+func (m *Mirror) drain() {
+	defer close(m.done)
+	for op := range m.queue {
+		serr := op.apply(m.secondary)
+		switch {
+		case op.primary != nil && serr == nil:
+			m.diverge(op, false, op.primary)
+		case op.primary == nil && serr != nil:
+			m.diverge(op, true, serr)
+		}
+		atomic.AddInt64(&m.pending, -1)
+	}
+}
+
+func (m *Mirror) diverge(op mirrorOp, secondary bool, err error) {
+	if secondary {
+		if err != ErrMirrorBufferFull {
+			atomic.AddInt64(&m.secondaryFailed, 1)
+		}
+	} else {
+		atomic.AddInt64(&m.primaryFailed, 1)
+	}
+
+	m.Client.logger.Warn("Hawkular mirrored write diverged", "command", op.command, "secondary", secondary, "error", err)
+
+	if m.options.OnDivergence != nil {
+		m.options.OnDivergence(MirrorDivergence{
+			Secondary: secondary,
+			Command:   op.command,
+			Metrics:   op.metrics,
+			Err:       err,
+			Time:      time.Now(),
+		})
+	}
+}
+
+// MirrorStats returns the amount of mirrored writes and divergences
+func (m *Mirror) MirrorStats() MirrorStats {
+	return MirrorStats{
+		Writes:           atomic.LoadInt64(&m.writes),
+		PrimaryFailed:    atomic.LoadInt64(&m.primaryFailed),
+		SecondaryFailed:  atomic.LoadInt64(&m.secondaryFailed),
+		SecondaryDropped: atomic.LoadInt64(&m.secondaryDropped),
+		Pending:          atomic.LoadInt64(&m.pending),
+	}
+}
+
+// Close writes the buffered secondary writes and closes both clients
+func (m *Mirror) Close() error {
+	m.closeLock.Lock()
+	if !m.closed {
+		m.closed = true
+		if m.queue != nil {
+			close(m.queue)
+		}
+	}
+	m.closeLock.Unlock()
+	<-m.done
+
+	perr := m.Client.Close()
+	serr := m.secondary.Close()
+	if perr != nil {
+		return perr
+	}
+	return serr
+}
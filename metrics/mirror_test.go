@@ -0,0 +1,233 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+type mirrorServer struct {
+	*httptest.Server
+	failing int32
+	writes  int32
+	deletes int32
+	blocked chan struct{} // Holds the requests until closed, if set
+	body    atomic.Value  // Body of the latest write
+}
+
+func newMirrorServer() *mirrorServer {
+	s := &mirrorServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.blocked != nil {
+			<-s.blocked
+		}
+		if atomic.LoadInt32(&s.failing) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case "POST":
+			atomic.AddInt32(&s.writes, 1)
+			b, _ := ioutil.ReadAll(r.Body)
+			s.body.Store(string(b))
+		case "DELETE":
+			atomic.AddInt32(&s.deletes, 1)
+		}
+		w.Write([]byte("[]"))
+	}))
+	return s
+}
+
+func newMirror(t *testing.T, consistency MirrorConsistency, onDivergence func(MirrorDivergence)) (*Mirror, *mirrorServer, *mirrorServer) {
+	primary, secondary := newMirrorServer(), newMirrorServer()
+	pc, err := NewHawkularClient(Parameters{Tenant: "mirror", Url: primary.URL})
+	assert.NoError(t, err)
+	sc, err := NewHawkularClient(Parameters{Tenant: "mirror", Url: secondary.URL})
+	assert.NoError(t, err)
+	return NewMirror(pc, sc, MirrorOptions{Consistency: consistency, OnDivergence: onDivergence}), primary, secondary
+}
+
+func mirrorWrite() []MetricHeader {
+	return []MetricHeader{{
+		Type: Gauge,
+		ID:   "test.mirror",
+		Data: []Datapoint{{Timestamp: time.Now(), Value: 1.0}},
+	}}
+}
+
+// waitMirror waits until the secondary writes have been processed
+func waitMirror(t *testing.T, m *Mirror) {
+	deadline := time.Now().Add(5 * time.Second)
+	for m.MirrorStats().Pending > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int64(0), m.MirrorStats().Pending)
+}
+
+func TestMirrorPrimaryRequired(t *testing.T) {
+	lock := &sync.Mutex{}
+	divergences := make([]MirrorDivergence, 0)
+	m, primary, secondary := newMirror(t, MirrorPrimaryRequired, func(d MirrorDivergence) {
+		lock.Lock()
+		defer lock.Unlock()
+		divergences = append(divergences, d)
+	})
+	defer primary.Close()
+	defer secondary.Close()
+	defer m.Close()
+
+	assert.NoError(t, m.Write(mirrorWrite()))
+	waitMirror(t, m)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&primary.writes))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&secondary.writes))
+
+	atomic.StoreInt32(&secondary.failing, 1)
+	assert.NoError(t, m.Write(mirrorWrite()))
+	waitMirror(t, m)
+	assert.Equal(t, 1, len(divergences))
+	assert.True(t, divergences[0].Secondary)
+	assert.Equal(t, "Write", divergences[0].Command)
+	assert.Equal(t, "test.mirror", divergences[0].Metrics[0].ID)
+
+	atomic.StoreInt32(&secondary.failing, 0)
+	atomic.StoreInt32(&primary.failing, 1)
+	assert.Error(t, m.Write(mirrorWrite()))
+	waitMirror(t, m)
+	assert.Equal(t, 2, len(divergences))
+	assert.False(t, divergences[1].Secondary)
+
+	s := m.MirrorStats()
+	assert.Equal(t, int64(3), s.Writes)
+	assert.Equal(t, int64(1), s.PrimaryFailed)
+	assert.Equal(t, int64(1), s.SecondaryFailed)
+
+	// Reads are served by the primary only
+	atomic.StoreInt32(&primary.failing, 0)
+	atomic.StoreInt32(&secondary.failing, 1)
+	_, err := m.Definitions()
+	assert.NoError(t, err)
+}
+
+func TestMirrorCopiesWrites(t *testing.T) {
+	m, primary, secondary := newMirror(t, MirrorPrimaryRequired, nil)
+	defer primary.Close()
+	defer secondary.Close()
+	defer m.Close()
+
+	secondary.blocked = make(chan struct{})
+	mhs := mirrorWrite()
+	assert.NoError(t, m.Write(mhs))
+
+	// The caller reuses its batch while the secondary write is pending
+	mhs[0].ID = "reused"
+	mhs[0].Data[0].Value = 2.0
+	close(secondary.blocked)
+	waitMirror(t, m)
+
+	assert.Equal(t, primary.body.Load(), secondary.body.Load())
+	assert.Contains(t, secondary.body.Load(), "test.mirror")
+}
+
+func TestMirrorDelete(t *testing.T) {
+	m, primary, secondary := newMirror(t, MirrorPrimaryRequired, nil)
+	defer primary.Close()
+	defer secondary.Close()
+	defer m.Close()
+
+	assert.NoError(t, m.Delete(Gauge, "test.mirror"))
+	waitMirror(t, m)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&primary.deletes))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&secondary.deletes))
+	assert.Equal(t, int64(1), m.MirrorStats().Writes)
+}
+
+func TestMirrorBothRequired(t *testing.T) {
+	m, primary, secondary := newMirror(t, MirrorBothRequired, nil)
+	defer primary.Close()
+	defer secondary.Close()
+	defer m.Close()
+
+	atomic.StoreInt32(&secondary.failing, 1)
+	err := m.UpdateTags(Gauge, "test.mirror", map[string]string{"a": "b"})
+	assert.Error(t, err)
+	merr, ok := err.(*MirrorError)
+	assert.True(t, ok)
+	assert.NoError(t, merr.Primary)
+	assert.Error(t, merr.Secondary)
+}
+
+func TestMirrorBestEffort(t *testing.T) {
+	m, primary, secondary := newMirror(t, MirrorBestEffort, nil)
+	defer primary.Close()
+	defer secondary.Close()
+
+	// Secondary is not written when the primary fails
+	atomic.StoreInt32(&primary.failing, 1)
+	assert.Error(t, m.Write(mirrorWrite()))
+	atomic.StoreInt32(&primary.failing, 0)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, m.Write(mirrorWrite()))
+	}
+	assert.NoError(t, m.Close())
+
+	assert.Equal(t, int32(5), atomic.LoadInt32(&primary.writes))
+	assert.Equal(t, int32(5), atomic.LoadInt32(&secondary.writes))
+	assert.Equal(t, int64(0), m.MirrorStats().Pending)
+}
+
+func TestMirrorSlowSecondary(t *testing.T) {
+	primary, secondary := newMirrorServer(), newMirrorServer()
+	defer primary.Close()
+	defer secondary.Close()
+	secondary.blocked = make(chan struct{})
+
+	pc, err := NewHawkularClient(Parameters{Tenant: "mirror", Url: primary.URL})
+	assert.NoError(t, err)
+	sc, err := NewHawkularClient(Parameters{Tenant: "mirror", Url: secondary.URL})
+	assert.NoError(t, err)
+	m := NewMirror(pc, sc, MirrorOptions{BufferSize: 10})
+
+	// The primary writes return while the secondary is stuck
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, m.Write(mirrorWrite()))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Primary writes waited for the secondary")
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&primary.writes))
+	assert.Equal(t, int64(3), m.MirrorStats().Pending)
+
+	close(secondary.blocked)
+	assert.NoError(t, m.Close())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&secondary.writes))
+}
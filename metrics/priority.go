@@ -0,0 +1,229 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Priority selects the lane of the request pool a request is queued to
+type Priority int
+
+const (
+	// PriorityInteractive is meant for latency sensitive requests, such as dashboard reads
+	PriorityInteractive Priority = iota
+	// PriorityNormal is the default priority of all the requests
+	PriorityNormal
+	// PriorityBulk is meant for large background jobs, such as backfilling writes
+	PriorityBulk
+)
+
+const priorityLanes = 3
+
+// DefaultPriorityWeights is the scheduling share of each priority if Parameters.PriorityWeights is not set.
+// The default weights do not reserve any workers.
+var DefaultPriorityWeights = map[Priority]int{
+	PriorityInteractive: 4,
+	PriorityNormal:      2,
+	PriorityBulk:        1,
+}
+
+// String returns a string representation of type
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityNormal:
+		return "normal"
+	case PriorityBulk:
+		return "bulk"
+	}
+	return ""
+}
+
+type priorityKey struct{}
+
+// RequestPriority sets the priority lane of the request
+func RequestPriority(p Priority) Modifier {
+	return func(r *http.Request) error {
+		*r = *r.WithContext(context.WithValue(r.Context(), priorityKey{}, p))
+		return nil
+	}
+}
+
+func requestPriority(r *http.Request) Priority {
+	if p, ok := r.Context().Value(priorityKey{}).(Priority); ok && p >= 0 && p < priorityLanes {
+		return p
+	}
+	return PriorityNormal
+}
+
+// requestQueue holds the pending requests of each priority lane. Workers pick the next request
+// with smooth weighted round robin among the non-empty lanes, so that the lower priorities still
+// get their share when the higher priorities are busy.
+//
+// Configured weights also reserve workers for the higher priorities: a lane may only take a worker if
+// the free workers exceed the unused reservations of the lanes above it, so slow bulk requests can not
+// occupy all the workers. The requests whose context is done are dropped instead of being sent.
+type requestQueue struct {
+	lock     sync.Mutex
+	cond     *sync.Cond
+	lanes    [priorityLanes][]*poolRequest
+	weights  [priorityLanes]int
+	current  [priorityLanes]int
+	reserved [priorityLanes]int
+	active   [priorityLanes]int
+	workers  int
+	size     int
+	closed   bool
+}
+
+// newRequestQueue creates the queue of the given amount of workers. The workers are only reserved
+// if the weights are given, zero workers meaning no reservations either.
+func newRequestQueue(weights map[Priority]int, workers int) *requestQueue {
+	if len(weights) == 0 {
+		weights, workers = DefaultPriorityWeights, 0
+	}
+	q := &requestQueue{workers: workers}
+	q.cond = sync.NewCond(&q.lock)
+	total := 0
+	for i := range q.weights {
+		q.weights[i] = 1
+		if w, found := weights[Priority(i)]; found && w > 0 {
+			q.weights[i] = w
+		}
+		total += q.weights[i]
+	}
+	for i := range q.reserved {
+		q.reserved[i] = workers * q.weights[i] / total
+	}
+	return q
+}
+
+// push queues the request and returns the new size of the queue
+func (q *requestQueue) push(p Priority, pr *poolRequest) int {
+	q.lock.Lock()
+	q.lanes[p] = append(q.lanes[p], pr)
+	q.size++
+	size := q.size
+	q.lock.Unlock()
+	q.cond.Signal()
+	return size
+}
+
+// pop blocks until a request is available and its lane may take a worker. The worker must call done
+// with the returned priority once the request is sent. It returns false once the queue is closed and empty
+func (q *requestQueue) pop() (*poolRequest, Priority, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for {
+		q.dropCancelled()
+		if best := q.next(); best >= 0 {
+			pr := q.lanes[best][0]
+			q.lanes[best][0] = nil
+			q.lanes[best] = q.lanes[best][1:]
+			q.size--
+			q.active[best]++
+			return pr, Priority(best), true
+		}
+		if q.size == 0 && q.closed {
+			return nil, 0, false
+		}
+		q.cond.Wait()
+	}
+}
+
+// done releases the worker taken by a request of the priority
+func (q *requestQueue) done(p Priority) {
+	q.lock.Lock()
+	q.active[p]--
+	q.lock.Unlock()
+	q.cond.Broadcast()
+}
+
+// next returns the lane of the next request, or -1 if none of the lanes may take a worker
+func (q *requestQueue) next() int {
+	total := 0
+	best := -1
+	for i := range q.lanes {
+		if len(q.lanes[i]) == 0 || !q.allowed(i) {
+			continue
+		}
+		q.current[i] += q.weights[i]
+		total += q.weights[i]
+		if best < 0 || q.current[i] > q.current[best] {
+			best = i
+		}
+	}
+	if best >= 0 {
+		q.current[best] -= total
+	}
+	return best
+}
+
+// allowed checks if the lane may take a worker without using the reservations of the higher priorities
+func (q *requestQueue) allowed(lane int) bool {
+	if q.workers == 0 {
+		return true
+	}
+	free := q.workers
+	for i := range q.active {
+		free -= q.active[i]
+	}
+	for i := 0; i < lane; i++ {
+		if unused := q.reserved[i] - q.active[i]; unused > 0 {
+			free -= unused
+		}
+	}
+	return free > 0
+}
+
+// dropCancelled answers the requests at the head of the lanes whose context is done with the context
+// error. The rest are dropped once they reach the head.
+func (q *requestQueue) dropCancelled() {
+	for i := range q.lanes {
+		for len(q.lanes[i]) > 0 {
+			pr := q.lanes[i][0]
+			err := pr.req.Context().Err()
+			if err == nil {
+				break
+			}
+			pr.rChan <- &poolResponse{err: err}
+			q.lanes[i][0] = nil
+			q.lanes[i] = q.lanes[i][1:]
+			q.size--
+		}
+	}
+}
+
+// len returns the amount of queued requests
+func (q *requestQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.size
+}
+
+func (q *requestQueue) close() {
+	q.lock.Lock()
+	q.closed = true
+	q.lock.Unlock()
+	q.cond.Broadcast()
+}
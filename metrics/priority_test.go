@@ -0,0 +1,211 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestRequestQueueWeights(t *testing.T) {
+	q := newRequestQueue(map[Priority]int{PriorityInteractive: 3, PriorityNormal: 1, PriorityBulk: 1}, 0)
+
+	for i := 0; i < 10; i++ {
+		for p := PriorityInteractive; p <= PriorityBulk; p++ {
+			q.push(p, &poolRequest{req: &http.Request{Method: p.String()}})
+		}
+	}
+	assert.Equal(t, 30, q.len())
+
+	served := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		pr, _, ok := q.pop()
+		assert.True(t, ok)
+		served[pr.req.Method]++
+	}
+	assert.Equal(t, 6, served["interactive"])
+	assert.Equal(t, 2, served["normal"])
+	assert.Equal(t, 2, served["bulk"])
+
+	q.close()
+	for i := 0; i < 20; i++ {
+		_, _, ok := q.pop()
+		assert.True(t, ok, "Closed queue should still be drained")
+	}
+	_, _, ok := q.pop()
+	assert.False(t, ok)
+}
+
+func TestRequestQueueReservations(t *testing.T) {
+	// Of the 4 workers, 2 are reserved for the interactive and 1 for the normal priority
+	q := newRequestQueue(map[Priority]int{PriorityInteractive: 2, PriorityNormal: 1, PriorityBulk: 1}, 4)
+	for i := 0; i < 4; i++ {
+		q.push(PriorityBulk, &poolRequest{req: &http.Request{Method: "bulk"}})
+	}
+
+	pr, p, ok := q.pop()
+	assert.True(t, ok)
+	assert.Equal(t, "bulk", pr.req.Method)
+
+	popped := make(chan Priority, 4)
+	go func() {
+		for {
+			_, p, ok := q.pop()
+			if !ok {
+				return
+			}
+			popped <- p
+		}
+	}()
+
+	select {
+	case <-popped:
+		t.Fatal("Bulk requests should not take the reserved workers")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	q.push(PriorityInteractive, &poolRequest{req: &http.Request{Method: "interactive"}})
+	assert.Equal(t, PriorityInteractive, <-popped)
+	q.push(PriorityNormal, &poolRequest{req: &http.Request{Method: "normal"}})
+	assert.Equal(t, PriorityNormal, <-popped)
+
+	// The bulk requests may use the unreserved worker once the first one is done
+	q.done(p)
+	assert.Equal(t, PriorityBulk, <-popped)
+	q.done(PriorityBulk)
+	assert.Equal(t, PriorityBulk, <-popped)
+
+	q.done(PriorityInteractive)
+	q.done(PriorityNormal)
+	q.done(PriorityBulk)
+	q.close()
+	assert.Equal(t, PriorityBulk, <-popped)
+}
+
+func TestRequestQueueDropsCancelled(t *testing.T) {
+	q := newRequestQueue(nil, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := &poolRequest{req: (&http.Request{Method: "cancelled"}).WithContext(ctx), rChan: make(chan *poolResponse, 1)}
+	q.push(PriorityNormal, cancelled)
+	q.push(PriorityNormal, &poolRequest{req: &http.Request{Method: "kept"}})
+	cancel()
+
+	pr, _, ok := q.pop()
+	assert.True(t, ok)
+	assert.Equal(t, "kept", pr.req.Method)
+	assert.Equal(t, context.Canceled, (<-cancelled.rChan).err)
+	assert.Equal(t, 0, q.len())
+}
+
+func TestPriorityLanes(t *testing.T) {
+	lock := &sync.Mutex{}
+	order := make([]string, 0)
+	release := make(chan struct{})
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("p") == "blocker" {
+			<-release
+		}
+		lock.Lock()
+		order = append(order, r.URL.Query().Get("p"))
+		lock.Unlock()
+	}))
+	defer s.Close()
+
+	c, err := NewHawkularClient(Parameters{Tenant: "lanes", Url: s.URL})
+	assert.NoError(t, err)
+
+	send := func(name string, p Priority, wg *sync.WaitGroup) {
+		defer wg.Done()
+		_, err := c.Send(c.URL("GET"), Filters(Param("p", name)), RequestPriority(p))
+		assert.NoError(t, err)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go send("blocker", PriorityNormal, wg)
+	time.Sleep(20 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go send("bulk", PriorityBulk, wg)
+	}
+	time.Sleep(20 * time.Millisecond)
+	wg.Add(1)
+	go send("interactive", PriorityInteractive, wg)
+	time.Sleep(20 * time.Millisecond)
+
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, []string{"blocker", "interactive", "bulk", "bulk", "bulk"}, order)
+}
+
+func TestDefaultWeightsUseAllWorkers(t *testing.T) {
+	var lock sync.Mutex
+	running, peak := 0, 0
+	release := make(chan struct{})
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		if running == 8 {
+			close(release)
+		}
+		lock.Unlock()
+
+		select {
+		case <-release:
+		case <-time.After(2 * time.Second):
+		}
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+	}))
+	defer s.Close()
+
+	c, err := NewHawkularClient(Parameters{Tenant: "lanes", Url: s.URL, Concurrency: 8})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Send(c.URL("GET"))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 8, peak, "Default priority requests should use all the workers")
+}
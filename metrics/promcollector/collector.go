@@ -0,0 +1,92 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package promcollector exposes the statistics of the Hawkular-Metrics client as a Prometheus collector.
+// metrics.PublishExpvar publishes the same statistics without the Prometheus client dependency.
+package promcollector
+
+import (
+	"strconv"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "hawkular_client"
+
+// Collector implements prometheus.Collector for the client statistics
+type Collector struct {
+	stats metrics.StatsProvider
+
+	queueDepth *prometheus.Desc
+	inFlight   *prometheus.Desc
+	bytesSent  *prometheus.Desc
+	errors     *prometheus.Desc
+	requests   *prometheus.Desc
+	latency    *prometheus.Desc
+}
+
+// NewCollector returns a new collector reading the statistics from the given client
+func NewCollector(s metrics.StatsProvider, constLabels prometheus.Labels) *Collector {
+	desc := func(name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, constLabels)
+	}
+
+	return &Collector{
+		stats:      s,
+		queueDepth: desc("queue_depth", "Requests waiting for a worker."),
+		inFlight:   desc("in_flight_requests", "Requests being processed by the client."),
+		bytesSent:  desc("sent_bytes_total", "Total size of the request payloads."),
+		errors:     desc("errors_total", "Failed requests by status code, 0 for connection errors.", "code"),
+		requests:   desc("requests_total", "Requests by client command and metric type.", "command", "type"),
+		latency:    desc("request_duration_seconds", "Request latency by client command and metric type.", "command", "type"),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.queueDepth
+	ch <- c.inFlight
+	ch <- c.bytesSent
+	ch <- c.errors
+	ch <- c.requests
+	ch <- c.latency
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.queueDepth, prometheus.GaugeValue, float64(s.QueueDepth))
+	ch <- prometheus.MustNewConstMetric(c.inFlight, prometheus.GaugeValue, float64(s.InFlight))
+	ch <- prometheus.MustNewConstMetric(c.bytesSent, prometheus.CounterValue, float64(s.BytesSent))
+
+	for code, n := range s.Errors {
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(n), strconv.Itoa(code))
+	}
+
+	for _, cmd := range s.Commands {
+		t := string(cmd.Type)
+		ch <- prometheus.MustNewConstMetric(c.requests, prometheus.CounterValue, float64(cmd.Requests), cmd.Command, t)
+
+		buckets := make(map[float64]uint64, len(cmd.Latency.Buckets))
+		for i, b := range cmd.Latency.Buckets {
+			buckets[b] = cmd.Latency.Counts[i]
+		}
+		ch <- prometheus.MustNewConstHistogram(c.latency, cmd.Latency.Count, cmd.Latency.Sum, buckets, cmd.Command, t)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package promcollector
+
+import (
+	"testing"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	assert "github.com/stretchr/testify/require"
+)
+
+type staticStats struct {
+	s *metrics.ClientStats
+}
+
+func (s staticStats) Stats() *metrics.ClientStats {
+	return s.s
+}
+
+func TestCollector(t *testing.T) {
+	s := &metrics.ClientStats{
+		QueueDepth: 2,
+		BytesSent:  1024,
+		Errors:     map[int]uint64{500: 3},
+		Commands: []metrics.CommandStats{
+			{
+				Command:  "Write",
+				Type:     metrics.Gauge,
+				Requests: 4,
+				Latency: metrics.Histogram{
+					Buckets: []float64{0.1, 1},
+					Counts:  []uint64{3, 4},
+					Count:   4,
+					Sum:     1.2,
+				},
+			},
+		},
+	}
+
+	r := prometheus.NewPedanticRegistry()
+	assert.NoError(t, r.Register(NewCollector(staticStats{s}, nil)))
+
+	mfs, err := r.Gather()
+	assert.NoError(t, err)
+
+	families := make(map[string]int)
+	for _, mf := range mfs {
+		families[mf.GetName()] = len(mf.GetMetric())
+	}
+	assert.Equal(t, 1, families["hawkular_client_queue_depth"])
+	assert.Equal(t, 1, families["hawkular_client_errors_total"])
+	assert.Equal(t, 1, families["hawkular_client_requests_total"])
+	assert.Equal(t, 1, families["hawkular_client_request_duration_seconds"])
+}
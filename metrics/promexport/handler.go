@@ -0,0 +1,267 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package promexport exposes the latest datapoints of Hawkular-Metrics in the Prometheus text and
+// OpenMetrics formats, for example for Prometheus federation.
+//
+// The gauge and counter definitions matching the tag query are exported. The metric names are read from
+// the __name__ tag, as written by promscrape and promremote, and default to the metric ids. The rest of the
+// tags are the labels. The invalid characters of the names are replaced with underscores. In the OpenMetrics
+// format the counters without the _total suffix are exposed with the unknown type.
+//
+// The response format is negotiated from the Accept header and encoded with the expfmt package of the
+// Prometheus common libraries.
+package promexport
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+	"github.com/hawkular/hawkular-client-go/metrics/promscrape"
+)
+
+// QueryParam is the request query parameter overriding Options.Query
+const QueryParam = "query"
+
+// Options configures the handler
+type Options struct {
+	Query       []string      // Tag query conditions, joined with AND. All the definitions are exported if empty.
+	Lookback    time.Duration // Age of the oldest exported datapoints, defaults to 5 minutes
+	Parallelism int           // Concurrent ReadRaw requests, defaults to 4
+
+	// The tenant is read from the TenantParam query parameter, such as tenant. The tenant of the client
+	// is used if it is not set.
+	TenantParam string
+}
+
+func (o *Options) defaults() {
+	if o.Lookback <= 0 {
+		o.Lookback = 5 * time.Minute
+	}
+	if o.Parallelism <= 0 {
+		o.Parallelism = 4
+	}
+}
+
+type handler struct {
+	c *metrics.Client
+	o Options
+}
+
+// NewHandler returns the exposition endpoint. The format is negotiated with the Accept header.
+func NewHandler(c *metrics.Client, o Options) http.Handler {
+	o.defaults()
+	return &handler{c: c, o: o}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var o []metrics.Modifier
+	if h.o.TenantParam != "" {
+		if tenant := q.Get(h.o.TenantParam); tenant != "" {
+			o = append(o, metrics.Tenant(tenant))
+		}
+	}
+	query := h.o.Query
+	if qs, found := q[QueryParam]; found {
+		query = qs
+	}
+
+	mfs, err := h.families(query, o)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+	w.Header().Set("Content-Type", string(format))
+	enc := expfmt.NewEncoder(w, format)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		closer.Close()
+	}
+}
+
+// latest is the last datapoint of a definition
+type latest struct {
+	md *metrics.MetricDefinition
+	dp *metrics.Datapoint
+}
+
+// families reads the latest datapoints of the matching definitions and groups them by metric name
+func (h *handler) families(query []string, o []metrics.Modifier) ([]*dto.MetricFamily, error) {
+	do := o
+	if len(query) > 0 {
+		do = append(do[:len(do):len(do)], metrics.Filters(metrics.TagsQueryFilter(query...)))
+	}
+	all, err := h.c.Definitions(do...)
+	if err != nil {
+		return nil, err
+	}
+	mds := make([]*metrics.MetricDefinition, 0, len(all))
+	for _, md := range all {
+		if md.Type == metrics.Gauge || md.Type == metrics.Counter {
+			mds = append(mds, md)
+		}
+	}
+	sort.Slice(mds, func(i, j int) bool { return mds[i].ID < mds[j].ID })
+
+	latests, err := h.read(mds, o)
+	if err != nil {
+		return nil, err
+	}
+
+	families := make(map[string]*dto.MetricFamily)
+	seen := make(map[string]bool)
+	for _, l := range latests {
+		if l.dp == nil {
+			continue
+		}
+		value, err := metrics.ConvertToFloat64(l.dp.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		name := l.md.Tags[promscrape.NameLabel]
+		if name == "" {
+			name = l.md.ID
+		}
+		name = sanitize(name, true)
+		typ := dto.MetricType_GAUGE
+		if l.md.Type == metrics.Counter {
+			typ = dto.MetricType_COUNTER
+		}
+
+		mf, found := families[name]
+		if !found {
+			mf = &dto.MetricFamily{Name: &name, Type: &typ}
+			families[name] = mf
+		} else if mf.GetType() != typ {
+			// A family has a single type, the first definition wins
+			continue
+		}
+
+		m := &dto.Metric{TimestampMs: proto.Int64(metrics.ToUnixMilli(l.dp.Timestamp))}
+		for k, v := range l.md.Tags {
+			if k = sanitize(k, false); strings.HasPrefix(k, "__") || v == "" {
+				continue
+			}
+			m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(k), Value: proto.String(v)})
+		}
+		sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+
+		// The series must be unique, the first definition wins
+		key := name
+		for _, lp := range m.Label {
+			key += "\xff" + lp.GetName() + "\xff" + lp.GetValue()
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if typ == dto.MetricType_COUNTER {
+			m.Counter = &dto.Counter{Value: &value}
+		} else {
+			m.Gauge = &dto.Gauge{Value: &value}
+		}
+		mf.Metric = append(mf.Metric, m)
+	}
+
+	mfs := make([]*dto.MetricFamily, 0, len(families))
+	for _, mf := range families {
+		mfs = append(mfs, mf)
+	}
+	sort.Slice(mfs, func(i, j int) bool { return mfs[i].GetName() < mfs[j].GetName() })
+	return mfs, nil
+}
+
+// read fetches the latest datapoint of each definition within the lookback, concurrently
+func (h *handler) read(mds []*metrics.MetricDefinition, o []metrics.Modifier) ([]latest, error) {
+	f := metrics.Filters(metrics.StartTimeFilter(time.Now().Add(-h.o.Lookback)), metrics.OrderFilter(metrics.DESC), metrics.LimitFilter(1))
+	ro := append(o[:len(o):len(o)], f)
+
+	latests := make([]latest, len(mds))
+	indexes := make(chan int)
+	errs := make(chan error, h.o.Parallelism)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < h.o.Parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				dps, err := h.c.ReadRaw(mds[i].Type, mds[i].ID, ro...)
+				if err != nil {
+					errs <- err
+					return
+				}
+				latests[i].md = mds[i]
+				if len(dps) > 0 {
+					latests[i].dp = dps[0]
+				}
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for i := range mds {
+		select {
+		case indexes <- i:
+		case err = <-errs:
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+	return latests, err
+}
+
+// sanitize replaces the characters invalid in metric names, or label names, with underscores
+func sanitize(s string, metricName bool) string {
+	b := []byte(s)
+	for i, c := range b {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || (c == ':' && metricName)
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || (b[0] >= '0' && b[0] <= '9') {
+		return "_" + string(b)
+	}
+	return string(b)
+}
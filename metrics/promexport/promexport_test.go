@@ -0,0 +1,133 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package promexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// store serves the definitions and the latest datapoints of a fake Hawkular-Metrics
+type store struct {
+	lock    sync.Mutex
+	tags    []string
+	tenants []string
+	raw     map[string]string
+}
+
+func (s *store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r.URL.Path == "/hawkular/metrics/metrics" {
+		s.tags = append(s.tags, r.URL.Query().Get("tags"))
+		s.tenants = append(s.tenants, r.Header.Get("Hawkular-Tenant"))
+		mds := []*metrics.MetricDefinition{
+			{Type: metrics.Gauge, ID: "http_requests{code=200}", Tags: map[string]string{"__name__": "http.requests", "code": "200", "bad-label": "x"}},
+			{Type: metrics.Counter, ID: "hits", Tags: map[string]string{"env": "prod"}},
+			{Type: metrics.Gauge, ID: "stale"},
+			{Type: metrics.String, ID: "log"},
+			{Type: metrics.Gauge, ID: "1st"},
+		}
+		json.NewEncoder(w).Encode(mds)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("order") != "DESC" || q.Get("limit") != "1" || q.Get("start") == "" {
+		http.Error(w, "unexpected query "+r.URL.RawQuery, http.StatusBadRequest)
+		return
+	}
+	body, found := s.raw[r.URL.Path]
+	if !found {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	fmt.Fprint(w, body)
+}
+
+func TestHandler(t *testing.T) {
+	st := &store{raw: map[string]string{
+		"/hawkular/metrics/gauges/http_requests{code=200}/raw": `[{"timestamp":1500000000000,"value":1.5}]`,
+		"/hawkular/metrics/counters/hits/raw":                  `[{"timestamp":1500000001000,"value":42}]`,
+		"/hawkular/metrics/gauges/1st/raw":                     `[{"timestamp":1500000002000,"value":7}]`,
+	}}
+	s := httptest.NewServer(st)
+	defer s.Close()
+
+	c, err := metrics.NewHawkularClient(metrics.Parameters{Tenant: "default", Url: s.URL})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	h := NewHandler(c, Options{Query: []string{"env = 'prod'"}, TenantParam: "tenant"})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/federate?tenant=ops", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+	assert.Equal(t, `# TYPE _1st gauge
+_1st 7 1500000002000
+# TYPE hits counter
+hits{env="prod"} 42 1500000001000
+# TYPE http_requests gauge
+http_requests{bad_label="x",code="200"} 1.5 1500000000000
+`, w.Body.String())
+	assert.Equal(t, "env = 'prod'", st.tags[0])
+	assert.Equal(t, "ops", st.tenants[0])
+
+	// The query parameter overrides the configured query
+	r := httptest.NewRequest("GET", "/federate?query=code+%3D+'200'", nil)
+	r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/openmetrics-text"))
+	// OpenMetrics requires the _total suffix of the counters
+	assert.Contains(t, w.Body.String(), "# TYPE hits unknown\n"+`hits{env="prod"} 42.0 1.500000001e+09`)
+	assert.True(t, strings.HasSuffix(w.Body.String(), "# EOF\n"))
+	assert.Equal(t, "code = '200'", st.tags[1])
+	assert.Equal(t, "default", st.tenants[1])
+}
+
+func TestHandlerError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hawkular/metrics/metrics" {
+			w.Write([]byte(`[{"type":"gauge","id":"a"},{"type":"gauge","id":"b"}]`))
+			return
+		}
+		http.Error(w, `{"errorMsg":"failed"}`, http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	c, err := metrics.NewHawkularClient(metrics.Parameters{Tenant: "default", Url: s.URL})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	w := httptest.NewRecorder()
+	NewHandler(c, Options{Parallelism: 1}).ServeHTTP(w, httptest.NewRequest("GET", "/federate", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
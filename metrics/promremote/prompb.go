@@ -0,0 +1,286 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package promremote
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The messages of the Prometheus remote storage protocol (prompb), only with the fields used here.
+// The rest of the fields, such as the metadata, exemplars and native histograms, are skipped.
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	value     float64
+	timestamp int64 // Milliseconds since epoch
+}
+
+type timeSeries struct {
+	labels  []label
+	samples []sample
+}
+
+type writeRequest struct {
+	timeseries []timeSeries
+}
+
+// Label matcher types
+const (
+	matchEqual = iota
+	matchNotEqual
+	matchRegexp
+	matchNotRegexp
+)
+
+type labelMatcher struct {
+	typ   uint64
+	name  string
+	value string
+}
+
+type query struct {
+	start    int64
+	end      int64
+	matchers []labelMatcher
+}
+
+type readRequest struct {
+	queries []query
+}
+
+type queryResult struct {
+	timeseries []timeSeries
+}
+
+type readResponse struct {
+	results []queryResult
+}
+
+// field is a decoded field, v holds the length-delimited values and x the rest
+type field struct {
+	num protowire.Number
+	typ protowire.Type
+	v   []byte
+	x   uint64
+}
+
+func parseFields(b []byte, f func(fd field) error) error {
+	for len(b) > 0 {
+		fd := field{}
+		var n int
+		fd.num, fd.typ, n = protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch fd.typ {
+		case protowire.BytesType:
+			fd.v, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			fd.x, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			fd.x, n = protowire.ConsumeFixed64(b)
+		default:
+			n = protowire.ConsumeFieldValue(fd.num, fd.typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := f(fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// is checks that the field has the number and the wire type
+func (fd field) is(num protowire.Number, typ protowire.Type) bool {
+	return fd.num == num && fd.typ == typ
+}
+
+func unmarshalLabel(b []byte) (label, error) {
+	l := label{}
+	err := parseFields(b, func(fd field) error {
+		switch {
+		case fd.is(1, protowire.BytesType):
+			l.name = string(fd.v)
+		case fd.is(2, protowire.BytesType):
+			l.value = string(fd.v)
+		}
+		return nil
+	})
+	return l, err
+}
+
+func unmarshalSample(b []byte) (sample, error) {
+	s := sample{}
+	err := parseFields(b, func(fd field) error {
+		switch {
+		case fd.is(1, protowire.Fixed64Type):
+			s.value = math.Float64frombits(fd.x)
+		case fd.is(2, protowire.VarintType):
+			s.timestamp = int64(fd.x)
+		}
+		return nil
+	})
+	return s, err
+}
+
+func unmarshalTimeSeries(b []byte) (timeSeries, error) {
+	ts := timeSeries{}
+	err := parseFields(b, func(fd field) error {
+		switch {
+		case fd.is(1, protowire.BytesType):
+			l, err := unmarshalLabel(fd.v)
+			if err != nil {
+				return err
+			}
+			ts.labels = append(ts.labels, l)
+		case fd.is(2, protowire.BytesType):
+			s, err := unmarshalSample(fd.v)
+			if err != nil {
+				return err
+			}
+			ts.samples = append(ts.samples, s)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+func unmarshalWriteRequest(b []byte) (*writeRequest, error) {
+	wr := &writeRequest{}
+	err := parseFields(b, func(fd field) error {
+		if fd.is(1, protowire.BytesType) {
+			ts, err := unmarshalTimeSeries(fd.v)
+			if err != nil {
+				return err
+			}
+			wr.timeseries = append(wr.timeseries, ts)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Invalid write request: %s", err.Error())
+	}
+	return wr, nil
+}
+
+func unmarshalQuery(b []byte) (query, error) {
+	q := query{}
+	err := parseFields(b, func(fd field) error {
+		switch {
+		case fd.is(1, protowire.VarintType):
+			q.start = int64(fd.x)
+		case fd.is(2, protowire.VarintType):
+			q.end = int64(fd.x)
+		case fd.is(3, protowire.BytesType):
+			m := labelMatcher{}
+			err := parseFields(fd.v, func(fd field) error {
+				switch {
+				case fd.is(1, protowire.VarintType):
+					m.typ = fd.x
+				case fd.is(2, protowire.BytesType):
+					m.name = string(fd.v)
+				case fd.is(3, protowire.BytesType):
+					m.value = string(fd.v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			q.matchers = append(q.matchers, m)
+		}
+		return nil
+	})
+	return q, err
+}
+
+func unmarshalReadRequest(b []byte) (*readRequest, error) {
+	rr := &readRequest{}
+	err := parseFields(b, func(fd field) error {
+		if fd.is(1, protowire.BytesType) {
+			q, err := unmarshalQuery(fd.v)
+			if err != nil {
+				return err
+			}
+			rr.queries = append(rr.queries, q)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Invalid read request: %s", err.Error())
+	}
+	return rr, nil
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendVarint(b []byte, num protowire.Number, x uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, x)
+}
+
+func (ts timeSeries) marshal() []byte {
+	var b []byte
+	for _, l := range ts.labels {
+		var lb []byte
+		lb = appendString(lb, 1, l.name)
+		lb = appendString(lb, 2, l.value)
+		b = appendMessage(b, 1, lb)
+	}
+	for _, s := range ts.samples {
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.value))
+		sb = appendVarint(sb, 2, uint64(s.timestamp))
+		b = appendMessage(b, 2, sb)
+	}
+	return b
+}
+
+func (rr *readResponse) marshal() []byte {
+	var b []byte
+	for _, r := range rr.results {
+		var rb []byte
+		for _, ts := range r.timeseries {
+			rb = appendMessage(rb, 1, ts.marshal())
+		}
+		b = appendMessage(b, 1, rb)
+	}
+	return b
+}
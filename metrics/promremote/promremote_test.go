@@ -0,0 +1,259 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package promremote
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	assert "github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/hawkular/hawkular-client-go/internal/testutil"
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// The request encoders and the response decoder of the Prometheus side
+
+func (wr *writeRequest) marshal() []byte {
+	var b []byte
+	for _, ts := range wr.timeseries {
+		b = appendMessage(b, 1, ts.marshal())
+	}
+	return b
+}
+
+func (rr *readRequest) marshal() []byte {
+	var b []byte
+	for _, q := range rr.queries {
+		var qb []byte
+		qb = appendVarint(qb, 1, uint64(q.start))
+		qb = appendVarint(qb, 2, uint64(q.end))
+		for _, m := range q.matchers {
+			var mb []byte
+			mb = appendVarint(mb, 1, m.typ)
+			mb = appendString(mb, 2, m.name)
+			mb = appendString(mb, 3, m.value)
+			qb = appendMessage(qb, 3, mb)
+		}
+		b = appendMessage(b, 1, qb)
+	}
+	return b
+}
+
+func unmarshalReadResponse(b []byte) (*readResponse, error) {
+	rr := &readResponse{}
+	err := parseFields(b, func(fd field) error {
+		if !fd.is(1, protowire.BytesType) {
+			return nil
+		}
+		r := queryResult{}
+		err := parseFields(fd.v, func(fd field) error {
+			if fd.is(1, protowire.BytesType) {
+				ts, err := unmarshalTimeSeries(fd.v)
+				if err != nil {
+					return err
+				}
+				r.timeseries = append(r.timeseries, ts)
+			}
+			return nil
+		})
+		rr.results = append(rr.results, r)
+		return err
+	})
+	return rr, err
+}
+
+func post(t *testing.T, h http.Handler, body []byte, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(snappy.Encode(nil, body)))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestDecodeWriteRequest(t *testing.T) {
+	// WriteRequest{timeseries: [{labels: [{name: "a", value: "b"}], samples: [{value: 1, timestamp: 2}]}]}
+	// as encoded by the Prometheus protobuf code, followed by an unknown metadata field
+	golden := []byte{
+		0x0a, 0x15,
+		0x0a, 0x06, 0x0a, 0x01, 'a', 0x12, 0x01, 'b',
+		0x12, 0x0b, 0x09, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, 0x10, 0x02,
+		0x1a, 0x02, 0x08, 0x01,
+	}
+	wr, err := unmarshalWriteRequest(golden)
+	assert.NoError(t, err)
+	assert.Equal(t, []timeSeries{{labels: []label{{"a", "b"}}, samples: []sample{{1, 2}}}}, wr.timeseries)
+	assert.Equal(t, golden[:23], wr.marshal())
+
+	_, err = unmarshalWriteRequest(golden[:10])
+	assert.Error(t, err)
+}
+
+func TestWriteHandler(t *testing.T) {
+	wr := &writeRequest{timeseries: []timeSeries{
+		{
+			labels:  []label{{"__name__", "http_requests_total"}, {"code", "200"}, {"tenant", "team-a"}},
+			samples: []sample{{10, 1500000000000}, {12.7, 1500000015000}},
+		},
+		{
+			labels:  []label{{"__name__", "temperature"}},
+			samples: []sample{{21.5, 1500000000000}, {math.Float64frombits(0x7ff0000000000002), 1500000015000}},
+		},
+		{
+			labels:  []label{{"__name__", "stale"}},
+			samples: []sample{{math.NaN(), 1500000000000}},
+		},
+	}}
+
+	rec := &testutil.Recorder{}
+	h := NewWriteHandler(rec, Options{TenantHeader: "X-Scope-OrgID", TenantLabel: "tenant"})
+	w := post(t, h, wr.marshal(), http.Header{"X-Scope-Orgid": {"team-b"}})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	writes := rec.Tenants()
+	assert.Equal(t, 2, len(writes))
+	counter := writes["team-a"][0]
+	assert.Equal(t, metrics.MetricType(metrics.Counter), counter.Type)
+	assert.Equal(t, "http_requests_total{code=200}", counter.ID)
+	assert.Equal(t, map[string]string{"__name__": "http_requests_total", "code": "200"}, counter.Tags)
+	assert.Equal(t, []metrics.Datapoint{
+		{Timestamp: metrics.FromUnixMilli(1500000000000), Value: int64(10)},
+		{Timestamp: metrics.FromUnixMilli(1500000015000), Value: int64(12)},
+	}, counter.Data)
+
+	gauge := writes["team-b"][0]
+	assert.Equal(t, metrics.MetricType(metrics.Gauge), gauge.Type)
+	assert.Equal(t, "temperature", gauge.ID)
+	assert.Equal(t, 1, len(gauge.Data))
+	assert.Equal(t, 21.5, gauge.Data[0].Value)
+
+	// The fractions of the counters are kept as gauges
+	rec = &testutil.Recorder{}
+	w = post(t, NewWriteHandler(rec, Options{TenantLabel: "tenant", CountersAsGauges: true}), wr.marshal(), nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	counter = rec.Tenants()["team-a"][0]
+	assert.Equal(t, metrics.MetricType(metrics.Gauge), counter.Type)
+	assert.Equal(t, 12.7, counter.Data[1].Value)
+
+	w = post(t, h, []byte{0x0a, 0x15, 0x0a}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader("not snappy"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// A few bytes claiming a 2 GiB body are rejected before decoding
+	header := make([]byte, binary.MaxVarintLen64)
+	r = httptest.NewRequest("POST", "/", bytes.NewReader(header[:binary.PutUvarint(header, 1<<31)]))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "exceeds the limit")
+}
+
+func TestReadHandler(t *testing.T) {
+	var tagQuery, tenant string
+	hawkular := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = r.Header.Get("Hawkular-Tenant")
+		switch r.URL.Path {
+		case "/hawkular/metrics/metrics":
+			tagQuery = r.URL.Query().Get("tags")
+			w.Write([]byte(`[
+				{"id":"up{job=api}","type":"gauge","tags":{"__name__":"up","job":"api"}},
+				{"id":"up{job=db}","type":"gauge","tags":{"__name__":"up","job":"db"}},
+				{"id":"requests","type":"counter","tags":{"job":"api"}},
+				{"id":"log","type":"string","tags":{"__name__":"up","job":"api"}}
+			]`))
+		case "/hawkular/metrics/gauges/up%7Bjob=api%7D/raw", "/hawkular/metrics/gauges/up{job=api}/raw":
+			assert.Equal(t, "1500000000000", r.URL.Query().Get("start"))
+			assert.Equal(t, "1500000060001", r.URL.Query().Get("end"))
+			json.NewEncoder(w).Encode([]metrics.Datapoint{{Timestamp: metrics.FromUnixMilli(1500000000000), Value: 1.0}})
+		default:
+			t.Errorf("Unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer hawkular.Close()
+
+	c, err := metrics.NewHawkularClient(metrics.Parameters{Tenant: "default", Url: hawkular.URL})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	rr := &readRequest{queries: []query{{
+		start: 1500000000000,
+		end:   1500000060000,
+		matchers: []labelMatcher{
+			{matchEqual, "__name__", "up"},
+			{matchNotRegexp, "job", "d.*"},
+			{matchEqual, "tenant", "team-a"},
+		},
+	}}}
+	w := post(t, NewReadHandler(c, Options{TenantLabel: "tenant"}), rr.marshal(), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "snappy", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "__name__ = 'up'", tagQuery)
+	assert.Equal(t, "team-a", tenant)
+
+	b, err := snappy.Decode(nil, w.Body.Bytes())
+	assert.NoError(t, err)
+	resp, err := unmarshalReadResponse(b)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(resp.results))
+	assert.Equal(t, []timeSeries{{
+		labels:  []label{{"__name__", "up"}, {"job", "api"}, {"tenant", "team-a"}},
+		samples: []sample{{1, 1500000000000}},
+	}}, resp.results[0].timeseries)
+
+	rr.queries[0].matchers = []labelMatcher{{matchRegexp, "job", "("}}
+	w = post(t, NewReadHandler(c, Options{}), rr.marshal(), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	body, _ := ioutil.ReadAll(w.Body)
+	assert.Contains(t, string(body), "Invalid regular expression")
+}
+
+func TestMatcherCondition(t *testing.T) {
+	cases := []struct {
+		m         labelMatcher
+		condition string
+	}{
+		{labelMatcher{matchEqual, "job", "api"}, "job = 'api'"},
+		{labelMatcher{matchEqual, "job", ""}, ""},
+		{labelMatcher{matchEqual, "job", "it's"}, ""},
+		{labelMatcher{matchNotEqual, "job", "api"}, ""},
+		{labelMatcher{matchRegexp, "job", "api|db"}, "job =~ /api|db/"},
+		{labelMatcher{matchRegexp, "job", "a.*"}, "job =~ /a.*/"},
+		{labelMatcher{matchRegexp, "job", ".*"}, ""},
+		{labelMatcher{matchNotRegexp, "job", "api"}, ""},
+	}
+	for _, c := range cases {
+		ms, _, err := (&readHandler{}).matchers(httptest.NewRequest("POST", "/", nil), []labelMatcher{c.m})
+		assert.NoError(t, err)
+		assert.Equal(t, c.condition, ms[0].condition(), c.m.value)
+	}
+}
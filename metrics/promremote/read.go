@@ -0,0 +1,214 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package promremote
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/snappy"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+	"github.com/hawkular/hawkular-client-go/metrics/promscrape"
+)
+
+type readHandler struct {
+	c *metrics.Client
+	o Options
+}
+
+// NewReadHandler returns the remote_read endpoint. The gauge and counter definitions matching the
+// label matchers are read with ReadRaw.
+func NewReadHandler(c *metrics.Client, o Options) http.Handler {
+	o.defaults()
+	return &readHandler{c: c, o: o}
+}
+
+func (h *readHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, err := readSnappy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rr, err := unmarshalReadRequest(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := &readResponse{}
+	for _, q := range rr.queries {
+		ms, tenant, err := h.matchers(r, q.matchers)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := h.query(q, ms, tenant)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.results = append(resp.results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+	w.Write(snappy.Encode(nil, resp.marshal()))
+}
+
+// matcher is a compiled label matcher
+type matcher struct {
+	labelMatcher
+	re *regexp.Regexp
+}
+
+func (m *matcher) matches(labels map[string]string) bool {
+	v := labels[m.name]
+	switch m.typ {
+	case matchNotEqual:
+		return v != m.value
+	case matchRegexp:
+		return m.re.MatchString(v)
+	case matchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return v == m.value
+}
+
+// condition is the tag query language expression of the matcher, or an empty string if the tag query
+// could not select the same series. Only the matchers requiring a non-empty value are used.
+func (m *matcher) condition() string {
+	switch m.typ {
+	case matchEqual:
+		if m.value != "" && !strings.ContainsAny(m.value, `'\`) {
+			return fmt.Sprintf("%s = '%s'", m.name, m.value)
+		}
+	case matchRegexp:
+		if !m.re.MatchString("") && !strings.Contains(m.value, "/") {
+			return fmt.Sprintf("%s =~ /%s/", m.name, m.value)
+		}
+	}
+	return ""
+}
+
+// matchers compiles the label matchers and takes out the tenant label matcher
+func (h *readHandler) matchers(r *http.Request, lms []labelMatcher) ([]*matcher, string, error) {
+	label := ""
+	ms := make([]*matcher, 0, len(lms))
+	for _, lm := range lms {
+		if h.o.TenantLabel != "" && lm.name == h.o.TenantLabel {
+			if lm.typ != matchEqual {
+				return nil, "", fmt.Errorf("Tenant label %s only supports equality matchers", lm.name)
+			}
+			label = lm.value
+			continue
+		}
+
+		m := &matcher{labelMatcher: lm}
+		switch lm.typ {
+		case matchEqual, matchNotEqual:
+		case matchRegexp, matchNotRegexp:
+			re, err := regexp.Compile("^(?:" + lm.value + ")$")
+			if err != nil {
+				return nil, "", fmt.Errorf("Invalid regular expression %s: %s", lm.value, err.Error())
+			}
+			m.re = re
+		default:
+			return nil, "", fmt.Errorf("Unknown matcher type %d", lm.typ)
+		}
+		ms = append(ms, m)
+	}
+	return ms, h.o.tenant(r, label), nil
+}
+
+func (h *readHandler) query(q query, ms []*matcher, tenant string) (queryResult, error) {
+	result := queryResult{}
+
+	var o []metrics.Modifier
+	if tenant != "" {
+		o = append(o, metrics.Tenant(tenant))
+	}
+
+	conditions := []string{}
+	for _, m := range ms {
+		if c := m.condition(); c != "" {
+			conditions = append(conditions, c)
+		}
+	}
+	do := o
+	if len(conditions) > 0 {
+		do = append(do[:len(do):len(do)], metrics.Filters(metrics.TagsQueryFilter(conditions...)))
+	}
+	mds, err := h.c.Definitions(do...)
+	if err != nil {
+		return result, err
+	}
+	sort.Slice(mds, func(i, j int) bool { return mds[i].ID < mds[j].ID })
+
+	for _, md := range mds {
+		if md.Type != metrics.Gauge && md.Type != metrics.Counter {
+			continue
+		}
+		labels := make(map[string]string, len(md.Tags)+2)
+		for k, v := range md.Tags {
+			labels[k] = v
+		}
+		if labels[promscrape.NameLabel] == "" {
+			labels[promscrape.NameLabel] = md.ID
+		}
+		if h.o.TenantLabel != "" && tenant != "" {
+			labels[h.o.TenantLabel] = tenant
+		}
+
+		matched := true
+		for _, m := range ms {
+			matched = matched && m.matches(labels)
+		}
+		if !matched {
+			continue
+		}
+
+		// The end of the query is inclusive
+		f := metrics.Filters(metrics.StartTimeFilter(metrics.FromUnixMilli(q.start)), metrics.EndTimeFilter(metrics.FromUnixMilli(q.end+1)), metrics.OrderFilter(metrics.ASC))
+		dps, err := h.c.ReadRaw(md.Type, md.ID, append(o[:len(o):len(o)], f)...)
+		if err != nil {
+			return result, err
+		}
+		if len(dps) == 0 {
+			continue
+		}
+
+		ts := timeSeries{labels: make([]label, 0, len(labels)), samples: make([]sample, 0, len(dps))}
+		for k, v := range labels {
+			ts.labels = append(ts.labels, label{name: k, value: v})
+		}
+		sort.Slice(ts.labels, func(i, j int) bool { return ts.labels[i].name < ts.labels[j].name })
+		for _, dp := range dps {
+			v, err := metrics.ConvertToFloat64(dp.Value)
+			if err != nil {
+				return result, err
+			}
+			ts.samples = append(ts.samples, sample{value: v, timestamp: metrics.ToUnixMilli(dp.Timestamp)})
+		}
+		result.timeseries = append(result.timeseries, ts)
+	}
+	return result, nil
+}
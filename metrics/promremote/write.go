@@ -0,0 +1,184 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package promremote implements the Prometheus remote storage protocol on top of Hawkular-Metrics:
+// a remote_write receiver that stores the samples and a remote_read endpoint that reads them back.
+//
+// Prometheus does not send the metric types with the samples, the series selected by Options.IsCounter
+// (by default the names ending with _total, _count or _bucket) are stored as counters and the rest as gauges.
+// The labels, including __name__, are the definition tags. The remote_read endpoint finds the series with
+// tag queries, so the definitions must be created with the tags, for example by writing through a
+// DefinitionCache with EnsureDefinitions.
+package promremote
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/golang/snappy"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+	"github.com/hawkular/hawkular-client-go/metrics/promscrape"
+)
+
+// Limits of the compressed and the decompressed request bodies, as in Prometheus
+const (
+	maxRequestSize = 32 << 20
+	maxDecodedSize = 32 << 20
+)
+
+// Options configures the handlers
+type Options struct {
+	ID        promscrape.IDFunc      // Builds the metric ids from the labels, defaults to promscrape.DefaultID
+	IsCounter func(name string) bool // Selects the series stored as counters, defaults to DefaultIsCounter
+
+	// The tenant is read from TenantLabel, which is not stored as a tag, then from the TenantHeader request
+	// header, such as X-Scope-OrgID. The tenant of the client is used if neither is set.
+	TenantHeader string
+	TenantLabel  string
+
+	// CountersAsGauges stores the series selected by IsCounter as gauges. Hawkular-Metrics counters are
+	// integers, the fractions of the Prometheus counters are lost without it.
+	CountersAsGauges bool
+}
+
+// DefaultIsCounter selects the series whose names end with _total, _count or _bucket
+func DefaultIsCounter(name string) bool {
+	return strings.HasSuffix(name, "_total") || strings.HasSuffix(name, "_count") || strings.HasSuffix(name, "_bucket")
+}
+
+func (o *Options) defaults() {
+	if o.ID == nil {
+		o.ID = promscrape.DefaultID
+	}
+	if o.IsCounter == nil {
+		o.IsCounter = DefaultIsCounter
+	}
+}
+
+// tenant returns the tenant of the request, or an empty string for the tenant of the client
+func (o *Options) tenant(r *http.Request, label string) string {
+	if label != "" {
+		return label
+	}
+	if o.TenantHeader != "" {
+		return r.Header.Get(o.TenantHeader)
+	}
+	return ""
+}
+
+// readSnappy reads and decompresses the request body
+func readSnappy(r *http.Request) ([]byte, error) {
+	compressed, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		return nil, err
+	}
+	// Decode allocates the length claimed by the header
+	n, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decompress the request: %s", err.Error())
+	}
+	if n > maxDecodedSize {
+		return nil, fmt.Errorf("Decompressed request size %d exceeds the limit of %d bytes", n, maxDecodedSize)
+	}
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decompress the request: %s", err.Error())
+	}
+	return b, nil
+}
+
+type writeHandler struct {
+	w metrics.Writer
+	o Options
+}
+
+// NewWriteHandler returns the remote_write receiver. The samples are written with a Write per tenant,
+// failed writes are answered with 500 so that Prometheus retries them.
+func NewWriteHandler(w metrics.Writer, o Options) http.Handler {
+	o.defaults()
+	return &writeHandler{w: w, o: o}
+}
+
+func (h *writeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, err := readSnappy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wr, err := unmarshalWriteRequest(b)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenants := make(map[string][]metrics.MetricHeader)
+	for _, ts := range wr.timeseries {
+		labels := make(map[string]string, len(ts.labels))
+		for _, l := range ts.labels {
+			labels[l.name] = l.value
+		}
+		tenant := h.o.tenant(r, labels[h.o.TenantLabel])
+		if h.o.TenantLabel != "" {
+			delete(labels, h.o.TenantLabel)
+		}
+
+		name := labels[promscrape.NameLabel]
+		idLabels := make(map[string]string, len(labels))
+		for k, v := range labels {
+			if k != promscrape.NameLabel {
+				idLabels[k] = v
+			}
+		}
+		mh := metrics.MetricHeader{Type: metrics.Gauge, ID: h.o.ID(name, idLabels), Tags: labels}
+		counter := !h.o.CountersAsGauges && h.o.IsCounter(name)
+		if counter {
+			mh.Type = metrics.Counter
+		}
+
+		for _, s := range ts.samples {
+			// Including the staleness markers
+			if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+				continue
+			}
+			dp := metrics.Datapoint{Timestamp: metrics.FromUnixMilli(s.timestamp), Value: s.value}
+			if counter {
+				dp.Value = int64(s.value)
+			}
+			mh.Data = append(mh.Data, dp)
+		}
+		if len(mh.Data) > 0 {
+			tenants[tenant] = append(tenants[tenant], mh)
+		}
+	}
+
+	for tenant, mhs := range tenants {
+		var o []metrics.Modifier
+		if tenant != "" {
+			o = append(o, metrics.Tenant(tenant))
+		}
+		if err := h.w.Write(mhs, o...); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,218 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package promscrape reads the Prometheus text exposition format into Hawkular-Metrics datapoints
+// and scrapes Prometheus targets periodically.
+//
+// Counters are stored as counters and gauges and untyped samples as gauges. Summaries are stored as a gauge
+// for each quantile, a gauge for the sum and a counter for the count, histograms as a counter for each bucket,
+// a gauge for the sum and a counter for the count, with the sample names of the text format (name_bucket,
+// name_sum and name_count). The labels of a sample, including the name as __name__, are the definition tags.
+//
+// The text format is parsed with the expfmt package of the Prometheus common libraries.
+package promscrape
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// NameLabel is the label and the definition tag with the sample name
+const NameLabel = "__name__"
+
+// IDFunc builds the metric id from the sample name and labels, the labels do not include NameLabel
+type IDFunc func(name string, labels map[string]string) string
+
+// DefaultID writes the name and the sorted labels as in the Prometheus text format without the quotes,
+// such as http_requests_total{code=200,method=get}
+func DefaultID(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// LabelID joins the name and the values of the given labels with the separator, skipping the missing
+// labels. The rest of the labels are only stored as tags, so they should not be needed to tell the
+// metrics apart.
+func LabelID(separator string, labels ...string) IDFunc {
+	return func(name string, l map[string]string) string {
+		parts := []string{name}
+		for _, label := range labels {
+			if v, found := l[label]; found {
+				parts = append(parts, v)
+			}
+		}
+		return strings.Join(parts, separator)
+	}
+}
+
+// Options configures the conversion
+type Options struct {
+	ID     IDFunc            // Defaults to DefaultID
+	Labels map[string]string // Added to every sample, replacing the scraped labels with the same name
+
+	// CountersAsGauges stores the counters and the counts of summaries and histograms as gauges.
+	// Hawkular-Metrics counters are integers, the fractions of the Prometheus counters are lost without it.
+	CountersAsGauges bool
+}
+
+// Parse reads the text exposition format. Samples without a timestamp get the time now.
+// The returned MetricHeaders have the labels as Tags, to be used as the definition tags.
+func Parse(r io.Reader, now time.Time, o Options) ([]metrics.MetricHeader, error) {
+	var p expfmt.TextParser
+	families, err := p.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c := &converter{o: o, index: make(map[string]int)}
+	if c.o.ID == nil {
+		c.o.ID = DefaultID
+	}
+	for _, name := range names {
+		mf := families[name]
+		for _, m := range mf.GetMetric() {
+			ts := now
+			if m.TimestampMs != nil {
+				ts = metrics.FromUnixMilli(m.GetTimestampMs())
+			}
+			labels := make(map[string]string, len(m.GetLabel())+len(o.Labels))
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			for k, v := range o.Labels {
+				labels[k] = v
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				c.counter(name, labels, ts, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				c.gauge(name, labels, ts, m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					c.gauge(name, with(labels, "quantile", formatFloat(q.GetQuantile())), ts, q.GetValue())
+				}
+				c.gauge(name+"_sum", labels, ts, s.GetSampleSum())
+				c.counter(name+"_count", labels, ts, float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				inf := false
+				for _, b := range h.GetBucket() {
+					inf = inf || math.IsInf(b.GetUpperBound(), 1)
+					c.counter(name+"_bucket", with(labels, "le", formatFloat(b.GetUpperBound())), ts, float64(b.GetCumulativeCount()))
+				}
+				if !inf {
+					c.counter(name+"_bucket", with(labels, "le", "+Inf"), ts, float64(h.GetSampleCount()))
+				}
+				c.gauge(name+"_sum", labels, ts, h.GetSampleSum())
+				c.counter(name+"_count", labels, ts, float64(h.GetSampleCount()))
+			default:
+				c.gauge(name, labels, ts, m.GetUntyped().GetValue())
+			}
+		}
+	}
+	return c.mhs, nil
+}
+
+// Definitions returns the definitions of the metrics with their tags
+func Definitions(mhs []metrics.MetricHeader) []metrics.MetricDefinition {
+	mds := make([]metrics.MetricDefinition, 0, len(mhs))
+	for _, mh := range mhs {
+		mds = append(mds, metrics.MetricDefinition{Tenant: mh.Tenant, Type: mh.Type, ID: mh.ID, Tags: mh.Tags})
+	}
+	return mds
+}
+
+// converter collects the samples to MetricHeaders, merging the samples with the same id
+type converter struct {
+	o     Options
+	mhs   []metrics.MetricHeader
+	index map[string]int
+}
+
+func (c *converter) gauge(name string, labels map[string]string, ts time.Time, v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		// Not representable in JSON
+		return
+	}
+	c.add(metrics.Gauge, name, labels, metrics.Datapoint{Timestamp: ts, Value: v})
+}
+
+func (c *converter) counter(name string, labels map[string]string, ts time.Time, v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	if c.o.CountersAsGauges {
+		c.gauge(name, labels, ts, v)
+		return
+	}
+	c.add(metrics.Counter, name, labels, metrics.Datapoint{Timestamp: ts, Value: int64(v)})
+}
+
+func (c *converter) add(t metrics.MetricType, name string, labels map[string]string, dp metrics.Datapoint) {
+	id := c.o.ID(name, labels)
+	key := string(t) + "/" + id
+	if i, found := c.index[key]; found {
+		c.mhs[i].Data = append(c.mhs[i].Data, dp)
+		return
+	}
+
+	c.index[key] = len(c.mhs)
+	c.mhs = append(c.mhs, metrics.MetricHeader{
+		Type: t,
+		ID:   id,
+		Data: []metrics.Datapoint{dp},
+		Tags: with(labels, NameLabel, name),
+	})
+}
+
+// with returns a copy of the labels with the added label
+func with(labels map[string]string, k, v string) map[string]string {
+	l := make(map[string]string, len(labels)+1)
+	for lk, lv := range labels {
+		l[lk] = lv
+	}
+	l[k] = v
+	return l
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,189 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package promscrape
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/hawkular/hawkular-client-go/internal/testutil"
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+const exposition = `# HELP http_requests_total Requests.
+# TYPE http_requests_total counter
+http_requests_total{method="get",code="200"} 1027 1500000000000
+http_requests_total{method="post",code="200"} 3.7
+# TYPE temperature gauge
+temperature 21.5
+temperature_nan NaN
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 0.05
+rpc_duration_seconds{quantile="0.99"} 0.2
+rpc_duration_seconds_sum 17.5
+rpc_duration_seconds_count 200
+# TYPE request_size_bytes histogram
+request_size_bytes_bucket{le="100"} 5
+request_size_bytes_bucket{le="1000"} 8
+request_size_bytes_bucket{le="+Inf"} 9
+request_size_bytes_sum 3000
+request_size_bytes_count 9
+`
+
+var now = time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+
+func byID(mhs []metrics.MetricHeader) map[string]metrics.MetricHeader {
+	m := make(map[string]metrics.MetricHeader)
+	for _, mh := range mhs {
+		m[mh.ID] = mh
+	}
+	return m
+}
+
+func TestParse(t *testing.T) {
+	mhs, err := Parse(strings.NewReader(exposition), now, Options{Labels: map[string]string{"job": "api"}})
+	assert.NoError(t, err)
+	m := byID(mhs)
+	assert.Equal(t, 12, len(mhs))
+
+	get := m["http_requests_total{code=200,job=api,method=get}"]
+	assert.Equal(t, metrics.MetricType(metrics.Counter), get.Type)
+	assert.Equal(t, int64(1027), get.Data[0].Value)
+	assert.Equal(t, metrics.FromUnixMilli(1500000000000), get.Data[0].Timestamp)
+	assert.Equal(t, map[string]string{"__name__": "http_requests_total", "code": "200", "method": "get", "job": "api"}, get.Tags)
+	assert.Equal(t, int64(3), m["http_requests_total{code=200,job=api,method=post}"].Data[0].Value)
+
+	temp := m["temperature{job=api}"]
+	assert.Equal(t, metrics.MetricType(metrics.Gauge), temp.Type)
+	assert.Equal(t, 21.5, temp.Data[0].Value)
+	assert.Equal(t, now, temp.Data[0].Timestamp)
+	_, found := m["temperature_nan{job=api}"]
+	assert.False(t, found)
+
+	assert.Equal(t, 0.2, m["rpc_duration_seconds{job=api,quantile=0.99}"].Data[0].Value)
+	assert.Equal(t, 17.5, m["rpc_duration_seconds_sum{job=api}"].Data[0].Value)
+	assert.Equal(t, int64(200), m["rpc_duration_seconds_count{job=api}"].Data[0].Value)
+
+	assert.Equal(t, int64(8), m["request_size_bytes_bucket{job=api,le=1000}"].Data[0].Value)
+	assert.Equal(t, int64(9), m["request_size_bytes_bucket{job=api,le=+Inf}"].Data[0].Value)
+	assert.Equal(t, "+Inf", m["request_size_bytes_bucket{job=api,le=+Inf}"].Tags["le"])
+	assert.Equal(t, 3000.0, m["request_size_bytes_sum{job=api}"].Data[0].Value)
+
+	mds := Definitions(mhs)
+	assert.Equal(t, len(mhs), len(mds))
+	assert.Equal(t, mhs[0].Tags, mds[0].Tags)
+
+	_, err = Parse(strings.NewReader("broken{ 1\n"), now, Options{})
+	assert.Error(t, err)
+}
+
+func TestParseOptions(t *testing.T) {
+	in := `# TYPE http_requests_total counter
+http_requests_total{method="get",code="200"} 1.5
+http_requests_total{method="get",code="500"} 2
+`
+	// Samples with the same id are merged
+	mhs, err := Parse(strings.NewReader(in), now, Options{ID: LabelID(".", "method", "missing"), CountersAsGauges: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(mhs))
+	assert.Equal(t, "http_requests_total.get", mhs[0].ID)
+	assert.Equal(t, metrics.MetricType(metrics.Gauge), mhs[0].Type)
+	assert.Equal(t, 2, len(mhs[0].Data))
+	assert.Equal(t, 1.5, mhs[0].Data[0].Value)
+}
+
+func TestScrape(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Accept"), "text/plain")
+		w.Write([]byte("# TYPE up gauge\nup 1\n"))
+	}))
+	defer target.Close()
+
+	var path, tenant string
+	var body []byte
+	hawkular := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, tenant = r.URL.Path, r.Header.Get("Hawkular-Tenant")
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer hawkular.Close()
+
+	c, err := metrics.NewHawkularClient(metrics.Parameters{Tenant: "default", Url: hawkular.URL})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	s := &Scraper{Writer: c, Job: "node", Modifiers: []metrics.Modifier{metrics.Tenant("scraped")}}
+	instance := strings.TrimPrefix(target.URL, "http://")
+	n, err := s.Scrape(context.Background(), Target{URL: target.URL, Labels: map[string]string{"dc": "east"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "/hawkular/metrics/gauges/raw", path)
+	assert.Equal(t, "scraped", tenant)
+
+	mhs := []metrics.MetricHeader{}
+	assert.NoError(t, json.Unmarshal(body, &mhs))
+	assert.Equal(t, "up{dc=east,instance="+instance+",job=node}", mhs[0].ID)
+
+	failing := httptest.NewServer(http.NotFoundHandler())
+	defer failing.Close()
+	_, err = s.Scrape(context.Background(), Target{URL: failing.URL})
+	assert.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("up 1\n"))
+	}))
+	defer target.Close()
+	failing := httptest.NewServer(http.NotFoundHandler())
+	defer failing.Close()
+
+	rec := &testutil.Recorder{}
+	errs := make(chan error, 10)
+	s := &Scraper{
+		Writer:   rec,
+		Targets:  []Target{{URL: target.URL}, {URL: failing.URL}},
+		Interval: 10 * time.Millisecond,
+		OnError: func(t Target, err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for rec.Count() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.True(t, rec.Count() >= 3)
+	assert.Error(t, <-errs)
+}
@@ -0,0 +1,154 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package promscrape
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// Labels added to the samples of every target, as Prometheus does
+const (
+	InstanceLabel = "instance"
+	JobLabel      = "job"
+)
+
+const acceptHeader = "text/plain;version=0.0.4;q=1,*/*;q=0.1"
+
+// Target is a Prometheus metrics endpoint
+type Target struct {
+	URL    string
+	Labels map[string]string // Added to the samples, the instance label defaults to the host and port of the URL
+}
+
+// Scraper polls the targets and writes their samples
+type Scraper struct {
+	Writer   metrics.Writer // Destination of the scraped samples
+	Targets  []Target
+	Job      string        // Value of the job label, not added if empty
+	Interval time.Duration // Defaults to 30 seconds
+	Timeout  time.Duration // Timeout of a single scrape, defaults to the interval
+	Options  Options
+
+	Client    *http.Client       // Defaults to http.DefaultClient
+	Modifiers []metrics.Modifier // Passed to Write, such as Tenant
+	OnError   func(t Target, err error)
+}
+
+// Scrape reads the target once and writes its samples, returning the number of datapoints written
+func (s *Scraper) Scrape(ctx context.Context, t Target) (int, error) {
+	labels := make(map[string]string, len(t.Labels)+len(s.Options.Labels)+2)
+	if u, err := url.Parse(t.URL); err == nil {
+		labels[InstanceLabel] = u.Host
+	}
+	if s.Job != "" {
+		labels[JobLabel] = s.Job
+	}
+	for k, v := range s.Options.Labels {
+		labels[k] = v
+	}
+	for k, v := range t.Labels {
+		labels[k] = v
+	}
+	o := s.Options
+	o.Labels = labels
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = s.interval()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", t.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", acceptHeader)
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	now := time.Now()
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Target %s returned status %d", t.URL, resp.StatusCode)
+	}
+
+	mhs, err := Parse(resp.Body, now, o)
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse the metrics of %s: %s", t.URL, err.Error())
+	}
+	if len(mhs) == 0 {
+		return 0, nil
+	}
+	if err := s.Writer.Write(mhs, s.Modifiers...); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, mh := range mhs {
+		n += len(mh.Data)
+	}
+	return n, nil
+}
+
+// Run scrapes every target at the interval until the context is cancelled. The targets are scraped
+// concurrently, the errors are passed to OnError.
+func (s *Scraper) Run(ctx context.Context) error {
+	wg := &sync.WaitGroup{}
+	for _, t := range s.Targets {
+		wg.Add(1)
+		go func(t Target) {
+			defer wg.Done()
+			ticker := time.NewTicker(s.interval())
+			defer ticker.Stop()
+			for {
+				if _, err := s.Scrape(ctx, t); err != nil && ctx.Err() == nil && s.OnError != nil {
+					s.OnError(t, err)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(t)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (s *Scraper) interval() time.Duration {
+	if s.Interval <= 0 {
+		return 30 * time.Second
+	}
+	return s.Interval
+}
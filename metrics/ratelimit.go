@@ -0,0 +1,266 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimitPolicy defines what happens to a request that exceeds the configured rate limits
+type RateLimitPolicy int
+
+const (
+	// RateLimitWait blocks the caller until enough tokens are available
+	RateLimitWait RateLimitPolicy = iota
+	// RateLimitReject fails the request immediately with a RateLimitError
+	RateLimitReject
+)
+
+// RateLimit defines token bucket limits. Zero value for a rate disables that limit
+type RateLimit struct {
+	Requests       float64 // Requests per second
+	Datapoints     float64 // Datapoints per second, counted from the Write calls
+	RequestBurst   int     // Maximum request burst, defaults to one second worth of requests
+	DatapointBurst int     // Maximum datapoint burst, defaults to one second worth of datapoints
+}
+
+// RateLimitError is returned when a request is rejected by the RateLimitReject policy
+type RateLimitError struct {
+	Tenant string
+	Wait   time.Duration // Estimated time until the request would have been allowed
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("Rate limit exceeded for tenant '%s', retry after %v", e.Tenant, e.Wait)
+}
+
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b < 1 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+// reserve takes n tokens from the bucket and returns how long the caller has to wait before
+// they are available. With wait false nothing is taken unless the tokens are available right away.
+func (b *tokenBucket) reserve(n float64, now time.Time, wait bool) (time.Duration, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// Larger requests than the burst could never be satisfied
+	n = math.Min(n, b.burst)
+
+	b.refill(now)
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0, true
+	}
+
+	delay := time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+	if !wait {
+		return delay, false
+	}
+	b.tokens -= n
+	return delay, true
+}
+
+// cancel returns previously reserved tokens
+func (b *tokenBucket) cancel(n float64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+math.Min(n, b.burst))
+}
+
+type bucketPair struct {
+	requests   *tokenBucket
+	datapoints *tokenBucket
+}
+
+func newBucketPair(l *RateLimit) *bucketPair {
+	if l == nil {
+		return &bucketPair{}
+	}
+	return &bucketPair{
+		requests:   newTokenBucket(l.Requests, l.RequestBurst),
+		datapoints: newTokenBucket(l.Datapoints, l.DatapointBurst),
+	}
+}
+
+type rateLimiter struct {
+	policy       RateLimitPolicy
+	client       *bucketPair
+	tenantLimits *RateLimit
+
+	lock    sync.Mutex
+	tenants map[string]*bucketPair
+}
+
+func newRateLimiter(client, tenant *RateLimit, policy RateLimitPolicy) *rateLimiter {
+	if client == nil && tenant == nil {
+		return nil
+	}
+	return &rateLimiter{
+		policy:       policy,
+		client:       newBucketPair(client),
+		tenantLimits: tenant,
+		tenants:      make(map[string]*bucketPair),
+	}
+}
+
+func (l *rateLimiter) tenant(tenant string) *bucketPair {
+	if l.tenantLimits == nil {
+		return &bucketPair{}
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	bp, found := l.tenants[tenant]
+	if !found {
+		bp = newBucketPair(l.tenantLimits)
+		l.tenants[tenant] = bp
+	}
+	return bp
+}
+
+type reservation struct {
+	bucket *tokenBucket
+	n      float64
+}
+
+// acquire reserves the tokens for a request carrying given amount of datapoints and waits
+// for them if the policy allows it
+func (l *rateLimiter) acquire(ctx context.Context, tenant string, datapoints int) error {
+	tb := l.tenant(tenant)
+	needed := []reservation{
+		{l.client.requests, 1},
+		{tb.requests, 1},
+	}
+	if datapoints > 0 {
+		needed = append(needed,
+			reservation{l.client.datapoints, float64(datapoints)},
+			reservation{tb.datapoints, float64(datapoints)})
+	}
+
+	wait := l.policy == RateLimitWait
+	now := time.Now()
+	var delay time.Duration
+	taken := make([]reservation, 0, len(needed))
+
+	for _, r := range needed {
+		if r.bucket == nil {
+			continue
+		}
+		d, ok := r.bucket.reserve(r.n, now, wait)
+		if !ok {
+			for _, t := range taken {
+				t.bucket.cancel(t.n)
+			}
+			return &RateLimitError{Tenant: tenant, Wait: d}
+		}
+		taken = append(taken, r)
+		if d > delay {
+			delay = d
+		}
+	}
+
+	if delay <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		for _, t := range taken {
+			t.bucket.cancel(t.n)
+		}
+		return ctx.Err()
+	}
+}
+
+type rateLimitedKey struct{}
+
+// rateLimited shares the rate limiter admission between the attempts of a single Send, so that
+// retries do not consume the tokens again
+func rateLimited(admitted *bool) Modifier {
+	return func(r *http.Request) error {
+		*r = *r.WithContext(context.WithValue(r.Context(), rateLimitedKey{}, admitted))
+		return nil
+	}
+}
+
+func limiterAdmission(r *http.Request) *bool {
+	if a, ok := r.Context().Value(rateLimitedKey{}).(*bool); ok {
+		return a
+	}
+	return new(bool)
+}
+
+type datapointCountKey struct{}
+
+// datapointCount stores the amount of datapoints in the request payload for the rate limiter
+func datapointCount(n int) Modifier {
+	return func(r *http.Request) error {
+		*r = *r.WithContext(context.WithValue(r.Context(), datapointCountKey{}, n))
+		return nil
+	}
+}
+
+func requestDatapoints(r *http.Request) int {
+	if n, ok := r.Context().Value(datapointCountKey{}).(int); ok {
+		return n
+	}
+	return 0
+}
+
+func countDatapoints(mhs []MetricHeader) int {
+	n := 0
+	for _, m := range mhs {
+		n += len(m.Data)
+	}
+	return n
+}
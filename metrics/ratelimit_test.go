@@ -0,0 +1,155 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10, 2)
+	now := time.Now()
+
+	d, ok := b.reserve(2, now, false)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), d)
+
+	d, ok = b.reserve(1, now, false)
+	assert.False(t, ok, "Bucket should be empty")
+	assert.Equal(t, 100*time.Millisecond, d)
+
+	_, ok = b.reserve(1, now.Add(100*time.Millisecond), false)
+	assert.True(t, ok, "Bucket should have refilled one token")
+
+	d, ok = b.reserve(1, now.Add(100*time.Millisecond), true)
+	assert.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, d)
+
+	assert.Nil(t, newTokenBucket(0, 10), "Zero rate should disable the bucket")
+}
+
+func TestRateLimitReject(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer s.Close()
+
+	p := Parameters{
+		Tenant:          "limited",
+		Url:             s.URL,
+		TenantRateLimit: &RateLimit{Requests: 1, Datapoints: 5},
+		RateLimitPolicy: RateLimitReject,
+	}
+
+	c, err := NewHawkularClient(p)
+	assert.NoError(t, err)
+
+	_, err = c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+
+	_, err = c.Send(c.URL("GET"))
+	assert.Error(t, err)
+	rlErr, ok := err.(*RateLimitError)
+	assert.True(t, ok)
+	assert.Equal(t, "limited", rlErr.Tenant)
+
+	// Other tenants have their own buckets
+	_, err = c.Send(c.URL("GET"), Tenant("other"))
+	assert.NoError(t, err)
+
+	data := []Datapoint{{Value: 1.0, Timestamp: time.Now()}, {Value: 2.0, Timestamp: time.Now()}}
+	mH := MetricHeader{ID: "test.ratelimit", Type: Gauge, Data: data}
+	err = c.Write([]MetricHeader{mH, mH, mH}, Tenant("third"))
+	assert.NoError(t, err, "Datapoints larger than the burst are limited to the burst size")
+
+	err = c.Write([]MetricHeader{mH}, Tenant("third"))
+	assert.Error(t, err)
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestRateLimitWait(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	p := Parameters{
+		Tenant:    "limited",
+		Url:       s.URL,
+		RateLimit: &RateLimit{Requests: 20, RequestBurst: 1},
+	}
+
+	c, err := NewHawkularClient(p)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err = c.Send(c.URL("GET"))
+		assert.NoError(t, err)
+	}
+	assert.True(t, time.Since(start) >= 90*time.Millisecond, "Requests should have been delayed by the limiter")
+}
+
+func TestRateLimitAfterCircuitBreaker(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	p := Parameters{
+		Tenant:          "limited",
+		Url:             s.URL,
+		RateLimit:       &RateLimit{Requests: 1, RequestBurst: 1},
+		RateLimitPolicy: RateLimitReject,
+		CircuitBreaker:  &CircuitBreaker{ConsecutiveFailures: 3, OpenTimeout: time.Minute},
+		MaxRetries:      2,
+		RetryBackoff:    time.Millisecond,
+	}
+
+	c, err := NewHawkularClient(p)
+	assert.NoError(t, err)
+
+	// Retries do not take new tokens
+	r, err := c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, r.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	assert.Equal(t, CircuitOpen, c.CircuitState())
+
+	// Requests rejected by the breaker do not consume tokens
+	b := c.limiter.client.requests
+	b.lock.Lock()
+	last := b.last
+	b.lock.Unlock()
+
+	_, err = c.Send(c.URL("GET"))
+	_, ok := err.(*CircuitOpenError)
+	assert.True(t, ok, "Open circuit should fail before the rate limiter")
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	assert.Equal(t, last, b.last, "Rate limiter should not have been consulted")
+}
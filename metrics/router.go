@@ -0,0 +1,389 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const defaultHashReplicas = 100
+
+// RouteFunc returns the name of the cluster which stores the tenant
+type RouteFunc func(tenant string) string
+
+// StaticRoute routes the tenants with a fixed table, unknown tenants are routed to the fallback cluster
+func StaticRoute(table map[string]string, fallback string) RouteFunc {
+	return func(tenant string) string {
+		if cluster, found := table[tenant]; found {
+			return cluster
+		}
+		return fallback
+	}
+}
+
+// HashRoute spreads the tenants over the clusters with consistent hashing, so adding a cluster moves only
+// a part of the tenants. Replicas is the amount of points per cluster in the hash ring, defaults to 100.
+func HashRoute(clusters []string, replicas int) RouteFunc {
+	if replicas <= 0 {
+		replicas = defaultHashReplicas
+	}
+
+	points := make([]uint32, 0, len(clusters)*replicas)
+	owners := make(map[uint32]string, len(clusters)*replicas)
+	for _, cluster := range clusters {
+		for i := 0; i < replicas; i++ {
+			h := hashKey(cluster + "#" + strconv.Itoa(i))
+			if _, found := owners[h]; found {
+				continue
+			}
+			owners[h] = cluster
+			points = append(points, h)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+
+	return func(tenant string) string {
+		if len(points) == 0 {
+			return ""
+		}
+		h := hashKey(tenant)
+		i := sort.Search(len(points), func(i int) bool { return points[i] >= h })
+		if i == len(points) {
+			i = 0
+		}
+		return owners[points[i]]
+	}
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// UnknownClusterError is returned when a tenant is routed to a cluster the Router does not have
+type UnknownClusterError struct {
+	Tenant  string
+	Cluster string
+}
+
+func (e *UnknownClusterError) Error() string {
+	return fmt.Sprintf("Tenant %s is routed to unknown cluster %q", e.Tenant, e.Cluster)
+}
+
+// RouterOptions configures the Router
+type RouterOptions struct {
+	Route  RouteFunc // Maps the tenants to the clusters
+	Tenant string    // Tenant of the commands without a Tenant modifier
+}
+
+// Router sends the commands to the Hawkular cluster storing the tenant. The tenant is read from the
+// Tenant modifier (or MetricHeader.Tenant and MetricDefinition.Tenant) and defaults to RouterOptions.Tenant.
+type Router struct {
+	clusters map[string]*Client
+	names    []string
+	route    RouteFunc
+	tenant   string
+}
+
+// NewRouter returns a Router over the named clients. The Router owns the clients and closes them in Close.
+func NewRouter(clusters map[string]*Client, options RouterOptions) (*Router, error) {
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("At least one cluster is required")
+	}
+	if options.Route == nil {
+		return nil, fmt.Errorf("Route is required")
+	}
+
+	names := make([]string, 0, len(clusters))
+	for name := range clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return &Router{
+		clusters: clusters,
+		names:    names,
+		route:    options.Route,
+		tenant:   options.Tenant,
+	}, nil
+}
+
+// Cluster returns the client of the cluster storing the tenant
+func (rt *Router) Cluster(tenant string) (*Client, error) {
+	name := rt.route(tenant)
+	c, found := rt.clusters[name]
+	if !found {
+		return nil, &UnknownClusterError{Tenant: tenant, Cluster: name}
+	}
+	return c, nil
+}
+
+// tenantOf returns the tenant set by the modifiers, or the default tenant
+func (rt *Router) tenantOf(o []Modifier) string {
+	if t := modifierTenant(o); t != "" {
+		return t
+	}
+	return rt.tenant
+}
+
+// client resolves the cluster of the command and pins the tenant to the request
+func (rt *Router) client(tenant string, o []Modifier) (*Client, []Modifier, error) {
+	if tenant == "" {
+		tenant = rt.tenantOf(o)
+	}
+	c, err := rt.Cluster(tenant)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tenant == "" {
+		return c, o, nil
+	}
+	return c, append(o[:len(o):len(o)], Tenant(tenant)), nil
+}
+
+// Send sends the request to the cluster of the tenant
+func (rt *Router) Send(o ...Modifier) (*http.Response, error) {
+	c, o, err := rt.client("", o)
+	if err != nil {
+		return nil, err
+	}
+	return c.Send(o...)
+}
+
+// Tenants lists the tenants of every cluster. If some of the clusters fail, the tenants of the rest
+// are returned with the error.
+func (rt *Router) Tenants(o ...Modifier) ([]*TenantDefinition, error) {
+	results := make([][]*TenantDefinition, len(rt.names))
+	err := rt.each(func(i int, c *Client) error {
+		tds, err := c.Tenants(o...)
+		results[i] = tds
+		return err
+	})
+
+	seen := make(map[string]bool)
+	tds := make([]*TenantDefinition, 0)
+	for _, r := range results {
+		for _, td := range r {
+			if !seen[td.ID] {
+				seen[td.ID] = true
+				tds = append(tds, td)
+			}
+		}
+	}
+	return tds, err
+}
+
+// CreateTenant creates the tenant in its cluster
+func (rt *Router) CreateTenant(tenant TenantDefinition, o ...Modifier) (bool, error) {
+	c, o, err := rt.client(tenant.ID, o)
+	if err != nil {
+		return false, err
+	}
+	return c.CreateTenant(tenant, o...)
+}
+
+// Create creates the metric definition in the cluster of its tenant
+func (rt *Router) Create(md MetricDefinition, o ...Modifier) (bool, error) {
+	c, o, err := rt.client(md.Tenant, o)
+	if err != nil {
+		return false, err
+	}
+	return c.Create(md, o...)
+}
+
+// AllDefinitions fetches the metric definitions of every tenant from every cluster
+func (rt *Router) AllDefinitions(o ...Modifier) ([]*MetricDefinition, error) {
+	results := make([][]*MetricDefinition, len(rt.names))
+	err := rt.each(func(i int, c *Client) error {
+		mds, err := c.AllDefinitions(o...)
+		results[i] = mds
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mds := make([]*MetricDefinition, 0)
+	for _, r := range results {
+		mds = append(mds, r...)
+	}
+	return mds, nil
+}
+
+// Definitions fetches the metric definitions of the tenant
+func (rt *Router) Definitions(o ...Modifier) ([]*MetricDefinition, error) {
+	c, o, err := rt.client("", o)
+	if err != nil {
+		return nil, err
+	}
+	return c.Definitions(o...)
+}
+
+// Definition fetches a single metric definition of the tenant
+func (rt *Router) Definition(t MetricType, id string, o ...Modifier) (*MetricDefinition, error) {
+	c, o, err := rt.client("", o)
+	if err != nil {
+		return nil, err
+	}
+	return c.Definition(t, id, o...)
+}
+
+// TagValues fetches the tag values of the tenant's metrics
+func (rt *Router) TagValues(tagQuery map[string]string, o ...Modifier) (map[string][]string, error) {
+	c, o, err := rt.client("", o)
+	if err != nil {
+		return nil, err
+	}
+	return c.TagValues(tagQuery, o...)
+}
+
+// UpdateTags modifies the tags of the tenant's metric definition
+func (rt *Router) UpdateTags(t MetricType, id string, tags map[string]string, o ...Modifier) error {
+	c, o, err := rt.client("", o)
+	if err != nil {
+		return err
+	}
+	return c.UpdateTags(t, id, tags, o...)
+}
+
+// DeleteTags deletes tags from the tenant's metric definition
+func (rt *Router) DeleteTags(t MetricType, id string, tags []string, o ...Modifier) error {
+	c, o, err := rt.client("", o)
+	if err != nil {
+		return err
+	}
+	return c.DeleteTags(t, id, tags, o...)
+}
+
+// Tags fetches the tags of the tenant's metric definition
+func (rt *Router) Tags(t MetricType, id string, o ...Modifier) (map[string]string, error) {
+	c, o, err := rt.client("", o)
+	if err != nil {
+		return nil, err
+	}
+	return c.Tags(t, id, o...)
+}
+
+// Delete deletes the tenant's metric
+func (rt *Router) Delete(t MetricType, id string, o ...Modifier) error {
+	c, o, err := rt.client("", o)
+	if err != nil {
+		return err
+	}
+	return c.Delete(t, id, o...)
+}
+
+// Write groups the datapoints by MetricHeader.Tenant and writes each group to the cluster of the tenant
+func (rt *Router) Write(metrics []MetricHeader, o ...Modifier) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	defaultTenant := rt.tenantOf(o)
+	groups := make(map[string][]MetricHeader)
+	for _, m := range metrics {
+		t := m.Tenant
+		if t == "" {
+			t = defaultTenant
+		}
+		groups[t] = append(groups[t], m)
+	}
+
+	wg := &sync.WaitGroup{}
+	errorsChan := make(chan error, len(groups))
+	for tenant, mhs := range groups {
+		c, on, err := rt.client(tenant, o)
+		if err != nil {
+			errorsChan <- err
+			continue
+		}
+		wg.Add(1)
+		go func(c *Client, mhs []MetricHeader, on []Modifier) {
+			defer wg.Done()
+			if err := c.Write(mhs, on...); err != nil {
+				errorsChan <- err
+			}
+		}(c, mhs, on)
+	}
+	wg.Wait()
+	close(errorsChan)
+
+	var err error
+	for e := range errorsChan {
+		if err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// ReadRaw reads the datapoints of the tenant's metric
+func (rt *Router) ReadRaw(t MetricType, id string, o ...Modifier) ([]*Datapoint, error) {
+	c, o, err := rt.client("", o)
+	if err != nil {
+		return nil, err
+	}
+	return c.ReadRaw(t, id, o...)
+}
+
+// ReadBuckets reads the statistical buckets of the tenant's metrics
+func (rt *Router) ReadBuckets(t MetricType, o ...Modifier) ([]*Bucketpoint, error) {
+	c, o, err := rt.client("", o)
+	if err != nil {
+		return nil, err
+	}
+	return c.ReadBuckets(t, o...)
+}
+
+// each runs f concurrently for every cluster and returns the first error
+func (rt *Router) each(f func(i int, c *Client) error) error {
+	wg := &sync.WaitGroup{}
+	errs := make([]error, len(rt.names))
+	for i, name := range rt.names {
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			errs[i] = f(i, c)
+		}(i, rt.clusters[name])
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the clients of every cluster
+func (rt *Router) Close() error {
+	var err error
+	for _, name := range rt.names {
+		if cerr := rt.clusters[name].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
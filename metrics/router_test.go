@@ -0,0 +1,171 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+type routerServer struct {
+	*httptest.Server
+	lock    sync.Mutex
+	tenants []string
+}
+
+func newRouterServer(tenants string) *routerServer {
+	s := &routerServer{tenants: make([]string, 0)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hawkular/metrics/tenants" && r.Method == "GET" {
+			w.Write([]byte(tenants))
+			return
+		}
+		s.lock.Lock()
+		s.tenants = append(s.tenants, r.Header.Get(tenantHeader))
+		s.lock.Unlock()
+		w.Write([]byte("[]"))
+	}))
+	return s
+}
+
+func (s *routerServer) received() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t := append([]string{}, s.tenants...)
+	sort.Strings(t)
+	return t
+}
+
+func TestRouter(t *testing.T) {
+	east := newRouterServer(`[{"id":"a"},{"id":"shared"}]`)
+	defer east.Close()
+	west := newRouterServer(`[{"id":"b"},{"id":"shared"}]`)
+	defer west.Close()
+
+	ec, err := NewHawkularClient(Parameters{Url: east.URL})
+	assert.NoError(t, err)
+	wc, err := NewHawkularClient(Parameters{Url: west.URL})
+	assert.NoError(t, err)
+
+	r, err := NewRouter(map[string]*Client{"east": ec, "west": wc}, RouterOptions{
+		Route:  StaticRoute(map[string]string{"a": "east", "b": "west"}, "east"),
+		Tenant: "a",
+	})
+	assert.NoError(t, err)
+	defer r.Close()
+
+	err = r.Write([]MetricHeader{
+		{Tenant: "a", Type: Gauge, ID: "m1", Data: []Datapoint{{Timestamp: time.Now(), Value: 1.0}}},
+		{Tenant: "b", Type: Gauge, ID: "m2", Data: []Datapoint{{Timestamp: time.Now(), Value: 1.0}}},
+		{Tenant: "b", Type: Counter, ID: "m3", Data: []Datapoint{{Timestamp: time.Now(), Value: 1}}},
+		{Type: Gauge, ID: "m4", Data: []Datapoint{{Timestamp: time.Now(), Value: 1.0}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, east.received())
+	assert.Equal(t, []string{"b", "b"}, west.received())
+
+	_, err = r.ReadRaw(Gauge, "m2", Tenant("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "b", "b"}, west.received())
+
+	_, err = r.Definitions()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "a"}, east.received())
+
+	assert.NoError(t, r.Delete(Gauge, "m2", Tenant("b")))
+	assert.Equal(t, []string{"b", "b", "b", "b"}, west.received())
+
+	tds, err := r.Tenants()
+	assert.NoError(t, err)
+	ids := make([]string, 0, len(tds))
+	for _, td := range tds {
+		ids = append(ids, td.ID)
+	}
+	sort.Strings(ids)
+	assert.Equal(t, []string{"a", "b", "shared"}, ids)
+
+	// Unknown cluster
+	r2, err := NewRouter(map[string]*Client{"east": ec}, RouterOptions{Route: StaticRoute(nil, "north")})
+	assert.NoError(t, err)
+	_, err = r2.Definitions(Tenant("c"))
+	assert.Error(t, err)
+	_, ok := err.(*UnknownClusterError)
+	assert.True(t, ok)
+}
+
+func TestRouterPartialTenants(t *testing.T) {
+	east := newRouterServer(`[{"id":"a"}]`)
+	defer east.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	ec, err := NewHawkularClient(Parameters{Url: east.URL})
+	assert.NoError(t, err)
+	wc, err := NewHawkularClient(Parameters{Url: down.URL})
+	assert.NoError(t, err)
+
+	r, err := NewRouter(map[string]*Client{"east": ec, "west": wc}, RouterOptions{Route: StaticRoute(map[string]string{"b": "west"}, "east")})
+	assert.NoError(t, err)
+	defer r.Close()
+
+	tds, err := r.Tenants()
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(tds))
+	assert.Equal(t, "a", tds[0].ID)
+
+	// The tenant is read without applying the other modifiers
+	applied := 0
+	counting := func(r *http.Request) error {
+		applied++
+		return nil
+	}
+	assert.Equal(t, "b", modifierTenant([]Modifier{counting, Tenant("a"), RequestPriority(PriorityBulk), Tenant("b")}))
+	assert.Equal(t, "", modifierTenant([]Modifier{counting, Filters(TypeFilter(Gauge))}))
+	assert.Equal(t, 0, applied)
+	_, err = r.Definitions(Tenant("b"), Filters(TypeFilter(Gauge)))
+	assert.Error(t, err, "Cluster of tenant b is down")
+}
+
+func TestHashRoute(t *testing.T) {
+	route := HashRoute([]string{"a", "b", "c"}, 0)
+
+	counts := make(map[string]int)
+	before := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		tenant := fmt.Sprintf("tenant-%d", i)
+		before[tenant] = route(tenant)
+		counts[before[tenant]]++
+	}
+	for _, c := range []string{"a", "b", "c"} {
+		assert.True(t, counts[c] > 500, "Cluster %s got %d tenants", c, counts[c])
+	}
+
+	// Adding a cluster moves tenants only to the new cluster
+	route = HashRoute([]string{"a", "b", "c", "d"}, 0)
+	for tenant, cluster := range before {
+		after := route(tenant)
+		assert.True(t, after == cluster || after == "d")
+	}
+}
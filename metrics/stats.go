@@ -0,0 +1,264 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds (in seconds) of the request latency histograms
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// StatsProvider is the interface to fetch the client's internal statistics
+type StatsProvider interface {
+	Stats() *ClientStats
+}
+
+// ClientStats is a snapshot of the client's internal statistics
+type ClientStats struct {
+	QueueDepth int            `json:"queueDepth"` // Requests waiting for a worker
+	InFlight   int            `json:"inFlight"`   // Requests being processed by Send
+	BytesSent  uint64         `json:"bytesSent"`  // Sum of request payload sizes
+	Errors     map[int]uint64 `json:"errors"`     // Failed requests per status code, connection errors are stored with code 0
+	Commands   []CommandStats `json:"commands"`   // Request counts and latencies per command, sorted by command and type
+	Timestamp  time.Time      `json:"-"`
+}
+
+// CommandStats has the statistics of a single command, such as Write or ReadRaw, for a metric type
+type CommandStats struct {
+	Command  string     `json:"command"`
+	Type     MetricType `json:"type,omitempty"`
+	Requests uint64     `json:"requests"`
+	Latency  Histogram  `json:"latency"`
+}
+
+// Histogram is a cumulative histogram of the latencies in seconds
+type Histogram struct {
+	Buckets []float64 `json:"buckets"` // Upper bounds of the buckets
+	Counts  []uint64  `json:"counts"`  // Cumulative counts of observations less or equal to the bucket upper bound
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+}
+
+type commandKey struct {
+	name       string
+	metricType MetricType
+}
+
+type commandInfoKey struct{}
+
+// command tags the request with the name of the client command and the metric type it operates on
+func command(name string, t MetricType) Modifier {
+	return func(r *http.Request) error {
+		*r = *r.WithContext(context.WithValue(r.Context(), commandInfoKey{}, commandKey{name, t}))
+		return nil
+	}
+}
+
+func requestCommand(r *http.Request) commandKey {
+	if k, ok := r.Context().Value(commandInfoKey{}).(commandKey); ok {
+		return k
+	}
+	return commandKey{name: "Send"}
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type clientStats struct {
+	lock      sync.Mutex
+	inFlight  int
+	bytesSent uint64
+	errors    map[int]uint64
+	requests  map[commandKey]uint64
+	latencies map[commandKey]*histogram
+}
+
+func newClientStats() *clientStats {
+	return &clientStats{
+		errors:    make(map[int]uint64),
+		requests:  make(map[commandKey]uint64),
+		latencies: make(map[commandKey]*histogram),
+	}
+}
+
+func (s *clientStats) started() {
+	s.lock.Lock()
+	s.inFlight++
+	s.lock.Unlock()
+}
+
+func (s *clientStats) finished() {
+	s.lock.Lock()
+	s.inFlight--
+	s.lock.Unlock()
+}
+
+// observe records a request that was sent to the server
+func (s *clientStats) observe(r *http.Request, resp *http.Response, err error, d time.Duration) {
+	k := requestCommand(r)
+	seconds := d.Seconds()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.requests[k]++
+	if r.ContentLength > 0 {
+		s.bytesSent += uint64(r.ContentLength)
+	}
+
+	if err != nil {
+		s.errors[0]++
+	} else if resp.StatusCode > 399 {
+		s.errors[resp.StatusCode]++
+	}
+
+	h, found := s.latencies[k]
+	if !found {
+		h = &histogram{counts: make([]uint64, len(LatencyBuckets))}
+		s.latencies[k] = h
+	}
+	h.count++
+	h.sum += seconds
+	for i, b := range LatencyBuckets {
+		if seconds <= b {
+			h.counts[i]++
+		}
+	}
+}
+
+func (s *clientStats) snapshot() *ClientStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	cs := &ClientStats{
+		InFlight:  s.inFlight,
+		BytesSent: s.bytesSent,
+		Errors:    make(map[int]uint64, len(s.errors)),
+		Commands:  make([]CommandStats, 0, len(s.requests)),
+		Timestamp: time.Now(),
+	}
+	for code, n := range s.errors {
+		cs.Errors[code] = n
+	}
+	for k, n := range s.requests {
+		h := s.latencies[k]
+		cs.Commands = append(cs.Commands, CommandStats{
+			Command:  k.name,
+			Type:     k.metricType,
+			Requests: n,
+			Latency: Histogram{
+				Buckets: LatencyBuckets,
+				Counts:  append([]uint64(nil), h.counts...),
+				Count:   h.count,
+				Sum:     h.sum,
+			},
+		})
+	}
+	sort.Slice(cs.Commands, func(i, j int) bool {
+		if cs.Commands[i].Command != cs.Commands[j].Command {
+			return cs.Commands[i].Command < cs.Commands[j].Command
+		}
+		return cs.Commands[i].Type < cs.Commands[j].Type
+	})
+	return cs
+}
+
+// Stats returns a snapshot of the client's internal statistics
+func (c *Client) Stats() *ClientStats {
+	cs := c.stats.snapshot()
+	cs.QueueDepth = c.pool.len()
+	return cs
+}
+
+// PublishExpvar exports the statistics as an expvar variable with the given name.
+// Like expvar.Publish, it panics if the name is already registered.
+func PublishExpvar(name string, s StatsProvider) {
+	expvar.Publish(name, statsVar(s))
+}
+
+func statsVar(s StatsProvider) expvar.Func {
+	return func() interface{} {
+		return s.Stats()
+	}
+}
+
+// StatsHeaders converts the statistics to gauge datapoints, each metric id is prefixed with the given prefix
+func (cs *ClientStats) StatsHeaders(prefix string) []MetricHeader {
+	mHs := make([]MetricHeader, 0, 4+len(cs.Errors)+2*len(cs.Commands))
+	gauge := func(id string, v float64) {
+		mHs = append(mHs, MetricHeader{
+			ID:   fmt.Sprintf("%s.%s", prefix, id),
+			Type: Gauge,
+			Data: []Datapoint{{Timestamp: cs.Timestamp, Value: v}},
+		})
+	}
+
+	gauge("queue_depth", float64(cs.QueueDepth))
+	gauge("in_flight", float64(cs.InFlight))
+	gauge("bytes_sent", float64(cs.BytesSent))
+	for code, n := range cs.Errors {
+		gauge("errors."+strconv.Itoa(code), float64(n))
+	}
+	for _, cmd := range cs.Commands {
+		id := cmd.Command
+		if cmd.Type != "" {
+			id = fmt.Sprintf("%s.%s", id, cmd.Type)
+		}
+		gauge("requests."+id, float64(cmd.Requests))
+		if cmd.Latency.Count > 0 {
+			gauge("latency_avg."+id, cmd.Latency.Sum/float64(cmd.Latency.Count))
+		}
+	}
+	return mHs
+}
+
+// ReportStats writes the client's own statistics back to Hawkular-Metrics as gauges every interval
+// until the context is cancelled or the client is closed. Modifiers are passed to every Write.
+// Failed writes are logged and retried on the next tick.
+func (c *Client) ReportStats(ctx context.Context, interval time.Duration, prefix string, o ...Modifier) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.done:
+			return ErrClientClosed
+		case <-ticker.C:
+			err := c.Write(c.Stats().StatsHeaders(prefix), o...)
+			if err == ErrClientClosed {
+				return err
+			}
+			if err != nil {
+				c.logger.Warn("Hawkular statistics report failed", "error", err)
+			}
+		}
+	}
+}
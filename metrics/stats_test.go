@@ -0,0 +1,73 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestClientStats(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "counters") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errorMsg":"bad"}`))
+		}
+	}))
+	defer s.Close()
+
+	c, err := NewHawkularClient(Parameters{Tenant: "stats", Url: s.URL})
+	assert.NoError(t, err)
+
+	dp := Datapoint{Value: 1.0, Timestamp: time.Now()}
+	err = c.Write([]MetricHeader{{ID: "a", Type: Gauge, Data: []Datapoint{dp}}})
+	assert.NoError(t, err)
+	err = c.Write([]MetricHeader{{ID: "b", Type: Counter, Data: []Datapoint{dp}}})
+	assert.Error(t, err)
+
+	cs := c.Stats()
+	assert.Equal(t, 0, cs.InFlight)
+	assert.Equal(t, 0, cs.QueueDepth)
+	assert.True(t, cs.BytesSent > 0)
+	assert.Equal(t, map[int]uint64{http.StatusBadRequest: 1}, cs.Errors)
+	assert.Equal(t, 2, len(cs.Commands))
+
+	assert.Equal(t, "Write", cs.Commands[0].Command)
+	assert.Equal(t, MetricType(Counter), cs.Commands[0].Type)
+	assert.Equal(t, uint64(1), cs.Commands[0].Requests)
+	assert.Equal(t, uint64(1), cs.Commands[0].Latency.Count)
+	assert.Equal(t, uint64(1), cs.Commands[0].Latency.Counts[len(LatencyBuckets)-1])
+
+	assert.True(t, json.Valid([]byte(statsVar(c).String())))
+
+	mHs := cs.StatsHeaders("client")
+	ids := make(map[string]bool)
+	for _, mH := range mHs {
+		assert.Equal(t, MetricType(Gauge), mH.Type)
+		ids[mH.ID] = true
+	}
+	assert.True(t, ids["client.queue_depth"])
+	assert.True(t, ids["client.errors.400"])
+	assert.True(t, ids["client.requests.Write.gauge"])
+}
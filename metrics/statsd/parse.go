@@ -0,0 +1,119 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package statsd is a StatsD server that aggregates the received metrics over a flush interval and
+// writes the results to Hawkular-Metrics.
+//
+// The lines are "name:value|type[|@rate][|#tag:value,...]", with the DogStatsD tags. The aggregates
+// are written at every flush for the metrics updated during the interval:
+//
+//	c         counter, the total since the server started, or since it was forgotten as idle
+//	g         gauge, the last value; values with a sign are added to the previous value
+//	ms, h, d  gauges name.count, name.sum, name.mean, name.lower, name.upper and name.upper_P for each percentile P
+//	s         gauge, the number of unique values
+//
+// The tags are the datapoint tags and the definition tags, and are appended to the ids in the Graphite
+// ";tag=value" form, sorted by name.
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Metric types of the protocol
+const (
+	Counter      = "c"
+	Gauge        = "g"
+	Timer        = "ms"
+	Histogram    = "h"
+	Distribution = "d"
+	Set          = "s"
+)
+
+// Sample is a parsed line
+type Sample struct {
+	Name  string
+	Type  string
+	Value float64
+	Delta bool   // Gauge value with a sign
+	Set   string // Value of the set samples
+	Rate  float64
+	Tags  map[string]string
+}
+
+// Parse parses a single line. The tags without a value, "#tag", get the value "true".
+func Parse(line string) (Sample, error) {
+	s := Sample{Rate: 1}
+
+	colon := strings.LastIndex(strings.SplitN(line, "|", 2)[0], ":")
+	if colon <= 0 {
+		return s, fmt.Errorf("Line %q is not in name:value|type format", line)
+	}
+	s.Name = line[:colon]
+	fields := strings.Split(line[colon+1:], "|")
+	if len(fields) < 2 {
+		return s, fmt.Errorf("Line %q is not in name:value|type format", line)
+	}
+	value := fields[0]
+	s.Type = fields[1]
+
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			rate, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return s, fmt.Errorf("Invalid sample rate %s of %s", f[1:], s.Name)
+			}
+			s.Rate = rate
+		case strings.HasPrefix(f, "#"):
+			s.Tags = make(map[string]string)
+			for _, tag := range strings.Split(f[1:], ",") {
+				if tag == "" {
+					continue
+				}
+				kv := strings.SplitN(tag, ":", 2)
+				if len(kv) == 1 {
+					kv = append(kv, "true")
+				}
+				if kv[0] == "" || kv[1] == "" {
+					return s, fmt.Errorf("Invalid tag %s of %s", tag, s.Name)
+				}
+				s.Tags[kv[0]] = kv[1]
+			}
+		}
+	}
+
+	switch s.Type {
+	case Set:
+		s.Set = value
+		return s, nil
+	case Counter, Gauge, Timer, Histogram, Distribution:
+	default:
+		return s, fmt.Errorf("Unknown metric type %s of %s", s.Type, s.Name)
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return s, fmt.Errorf("Invalid value %s of %s", value, s.Name)
+	}
+	s.Value = v
+	s.Delta = s.Type == Gauge && (value[0] == '+' || value[0] == '-')
+	return s, nil
+}
@@ -0,0 +1,327 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+// maxPacketSize is the largest UDP datagram read
+const maxPacketSize = 65535
+
+// Server receives the StatsD lines over UDP and writes the aggregates at every flush
+type Server struct {
+	Writer        metrics.Writer    // Destination of the flushed aggregates
+	FlushInterval time.Duration     // Defaults to 10 seconds
+	Percentiles   []float64         // Of the timers, defaults to 90
+	Prefix        string            // Prepended to the names
+	Tags          map[string]string // Added to every metric, the tags of the lines win
+
+	// The counters and gauges not updated for IdleFlushes flushes are forgotten, defaults to 60. A forgotten
+	// counter starts again from zero, which is seen as a counter reset.
+	IdleFlushes int
+
+	Modifiers []metrics.Modifier // Passed to Write, such as Tenant
+	OnError   func(err error)    // Receives the parse and write errors
+
+	lock     sync.Mutex
+	counters map[string]*counter
+	gauges   map[string]*gauge
+	timers   map[string]*timer
+	sets     map[string]*set
+}
+
+// series is the name and the tags shared by the aggregates
+type series struct {
+	name string
+	tags map[string]string
+}
+
+type counter struct {
+	series
+	total   float64
+	updated bool
+	idle    int // Flushes since the latest update
+}
+
+type gauge struct {
+	series
+	value   float64
+	updated bool
+	idle    int
+}
+
+type timer struct {
+	series
+	values []float64
+	count  float64 // Adjusted with the sample rates
+}
+
+type set struct {
+	series
+	values map[string]struct{}
+}
+
+// ListenAndServe listens on the UDP address, such as ":8125"
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, pc)
+}
+
+// Serve reads the datagrams of pc and flushes at the interval until the context is cancelled.
+// The connection is closed and the aggregates are flushed before returning.
+func (s *Server) Serve(ctx context.Context, pc net.PacketConn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(s.flushInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Flush(time.Now())
+			}
+		}
+	}()
+
+	errs := make(chan error, 1)
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for _, line := range strings.Split(string(buf[:n]), "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					s.Add(line)
+				}
+			}
+		}
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+	case err = <-errs:
+	}
+	cancel()
+	pc.Close()
+	wg.Wait()
+	s.Flush(time.Now())
+	return err
+}
+
+// Add parses the line and aggregates the sample
+func (s *Server) Add(line string) {
+	sample, err := Parse(line)
+	if err != nil {
+		s.error(err)
+		return
+	}
+
+	sr := series{name: s.Prefix + sample.Name, tags: sample.Tags}
+	if len(s.Tags) > 0 {
+		sr.tags = make(map[string]string, len(s.Tags)+len(sample.Tags))
+		for _, m := range []map[string]string{s.Tags, sample.Tags} {
+			for k, v := range m {
+				sr.tags[k] = v
+			}
+		}
+	}
+	key := metrics.TaggedID(sr.name, sr.tags)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch sample.Type {
+	case Counter:
+		if s.counters == nil {
+			s.counters = make(map[string]*counter)
+		}
+		c, found := s.counters[key]
+		if !found {
+			c = &counter{series: sr}
+			s.counters[key] = c
+		}
+		c.total += sample.Value / sample.Rate
+		c.updated = true
+	case Gauge:
+		if s.gauges == nil {
+			s.gauges = make(map[string]*gauge)
+		}
+		g, found := s.gauges[key]
+		if !found {
+			g = &gauge{series: sr}
+			s.gauges[key] = g
+		}
+		if sample.Delta {
+			g.value += sample.Value
+		} else {
+			g.value = sample.Value
+		}
+		g.updated = true
+	case Set:
+		if s.sets == nil {
+			s.sets = make(map[string]*set)
+		}
+		st, found := s.sets[key]
+		if !found {
+			st = &set{series: sr, values: make(map[string]struct{})}
+			s.sets[key] = st
+		}
+		st.values[sample.Set] = struct{}{}
+	default:
+		if s.timers == nil {
+			s.timers = make(map[string]*timer)
+		}
+		t, found := s.timers[key]
+		if !found {
+			t = &timer{series: sr}
+			s.timers[key] = t
+		}
+		t.values = append(t.values, sample.Value)
+		t.count += 1 / sample.Rate
+	}
+}
+
+// Flush writes the aggregates of the metrics updated since the previous flush, timestamped with now
+func (s *Server) Flush(now time.Time) {
+	mhs := s.aggregate(now)
+	if len(mhs) == 0 {
+		return
+	}
+	if err := s.Writer.Write(mhs, s.Modifiers...); err != nil {
+		s.error(err)
+	}
+}
+
+func (s *Server) aggregate(now time.Time) []metrics.MetricHeader {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	mhs := []metrics.MetricHeader{}
+	add := func(t metrics.MetricType, sr series, suffix string, value interface{}) {
+		name := sr.name + suffix
+		mhs = append(mhs, metrics.MetricHeader{
+			Type: t,
+			ID:   metrics.TaggedID(name, sr.tags),
+			Tags: sr.tags,
+			Data: []metrics.Datapoint{{Timestamp: now, Value: value, Tags: sr.tags}},
+		})
+	}
+
+	for key, c := range s.counters {
+		if c.updated {
+			add(metrics.Counter, c.series, "", int64(math.Round(c.total)))
+			c.updated, c.idle = false, 0
+		} else if c.idle++; c.idle >= s.idleFlushes() {
+			delete(s.counters, key)
+		}
+	}
+	for key, g := range s.gauges {
+		if g.updated {
+			add(metrics.Gauge, g.series, "", g.value)
+			g.updated, g.idle = false, 0
+		} else if g.idle++; g.idle >= s.idleFlushes() {
+			delete(s.gauges, key)
+		}
+	}
+	for _, st := range s.sets {
+		add(metrics.Gauge, st.series, "", float64(len(st.values)))
+	}
+	for _, t := range s.timers {
+		sort.Float64s(t.values)
+		sum := 0.0
+		for _, v := range t.values {
+			sum += v
+		}
+		n := len(t.values)
+		add(metrics.Gauge, t.series, ".count", t.count)
+		add(metrics.Gauge, t.series, ".sum", sum)
+		add(metrics.Gauge, t.series, ".mean", sum/float64(n))
+		add(metrics.Gauge, t.series, ".lower", t.values[0])
+		add(metrics.Gauge, t.series, ".upper", t.values[n-1])
+		for _, p := range s.percentiles() {
+			// Nearest rank
+			rank := int(math.Ceil(p/100*float64(n))) - 1
+			if rank < 0 {
+				rank = 0
+			} else if rank >= n {
+				rank = n - 1
+			}
+			add(metrics.Gauge, t.series, ".upper_"+percentileName(p), t.values[rank])
+		}
+	}
+	s.sets, s.timers = nil, nil
+
+	sort.Slice(mhs, func(i, j int) bool { return mhs[i].ID < mhs[j].ID })
+	return mhs
+}
+
+// percentileName formats 99.9 as 99_9
+func percentileName(p float64) string {
+	return strings.Replace(fmt.Sprintf("%g", p), ".", "_", -1)
+}
+
+func (s *Server) error(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+func (s *Server) percentiles() []float64 {
+	if len(s.Percentiles) == 0 {
+		return []float64{90}
+	}
+	return s.Percentiles
+}
+
+func (s *Server) flushInterval() time.Duration {
+	if s.FlushInterval <= 0 {
+		return 10 * time.Second
+	}
+	return s.FlushInterval
+}
+
+func (s *Server) idleFlushes() int {
+	if s.IdleFlushes <= 0 {
+		return 60
+	}
+	return s.IdleFlushes
+}
@@ -0,0 +1,179 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/hawkular/hawkular-client-go/internal/testutil"
+	"github.com/hawkular/hawkular-client-go/metrics"
+)
+
+func TestParse(t *testing.T) {
+	s, err := Parse("api.requests:3|c|@0.5|#env:prod,beta")
+	assert.NoError(t, err)
+	assert.Equal(t, Sample{Name: "api.requests", Type: Counter, Value: 3, Rate: 0.5, Tags: map[string]string{"env": "prod", "beta": "true"}}, s)
+
+	s, err = Parse("queue:-2|g")
+	assert.NoError(t, err)
+	assert.True(t, s.Delta)
+	assert.Equal(t, -2.0, s.Value)
+
+	s, err = Parse("users:alice|s")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", s.Set)
+
+	s, err = Parse("ns:name:1.5|ms")
+	assert.NoError(t, err)
+	assert.Equal(t, "ns:name", s.Name)
+	assert.False(t, s.Delta)
+
+	for _, line := range []string{"novalue", "a:1", ":1|c", "a:x|c", "a:1|x", "a:1|c|@2", "a:1|c|#:v", "a:NaN|g"} {
+		_, err = Parse(line)
+		assert.Error(t, err, line)
+	}
+}
+
+// last returns the metrics of the latest write by type and id
+func last(rec *testutil.Recorder) map[string]metrics.MetricHeader {
+	m := make(map[string]metrics.MetricHeader)
+	for _, mh := range rec.Last().Metrics {
+		m[string(mh.Type)+"/"+mh.ID] = mh
+	}
+	return m
+}
+
+func TestAggregate(t *testing.T) {
+	rec := &testutil.Recorder{}
+	errs := []error{}
+	s := &Server{Writer: rec, Prefix: "app.", Tags: map[string]string{"env": "test"}, Percentiles: []float64{50, 99.9}, OnError: func(err error) { errs = append(errs, err) }}
+
+	for _, line := range []string{
+		"hits:1|c", "hits:2|c|@0.5", "hits:1|c|#env:prod",
+		"temp:20|g", "temp:+5|g", "temp:-3|g",
+		"users:a|s", "users:b|s", "users:a|s",
+		"latency:30|ms", "latency:10|ms", "latency:20|ms|@0.5", "latency:40|h",
+		"broken",
+	} {
+		s.Add(line)
+	}
+	assert.Equal(t, 1, len(errs))
+
+	now := time.Date(2017, 7, 1, 12, 0, 0, 0, time.UTC)
+	s.Flush(now)
+	m := last(rec)
+	assert.Equal(t, 11, len(m))
+
+	hits := m["counter/app.hits;env=test"]
+	assert.Equal(t, int64(5), hits.Data[0].Value)
+	assert.Equal(t, now, hits.Data[0].Timestamp)
+	assert.Equal(t, map[string]string{"env": "test"}, hits.Tags)
+	prod := m["counter/app.hits;env=prod"]
+	assert.Equal(t, int64(1), prod.Data[0].Value)
+	assert.Equal(t, map[string]string{"env": "prod"}, prod.Data[0].Tags)
+
+	assert.Equal(t, 22.0, m["gauge/app.temp;env=test"].Data[0].Value)
+	assert.Equal(t, 2.0, m["gauge/app.users;env=test"].Data[0].Value)
+
+	assert.Equal(t, 5.0, m["gauge/app.latency.count;env=test"].Data[0].Value)
+	assert.Equal(t, 100.0, m["gauge/app.latency.sum;env=test"].Data[0].Value)
+	assert.Equal(t, 25.0, m["gauge/app.latency.mean;env=test"].Data[0].Value)
+	assert.Equal(t, 10.0, m["gauge/app.latency.lower;env=test"].Data[0].Value)
+	assert.Equal(t, 40.0, m["gauge/app.latency.upper;env=test"].Data[0].Value)
+	assert.Equal(t, 20.0, m["gauge/app.latency.upper_50;env=test"].Data[0].Value)
+	assert.Equal(t, 40.0, m["gauge/app.latency.upper_99_9;env=test"].Data[0].Value)
+
+	// Only the updated metrics are written, the counters keep their totals
+	s.Add("hits:1|c")
+	s.Add("temp:+1|g")
+	s.Flush(now.Add(time.Second))
+	m = last(rec)
+	assert.Equal(t, 2, len(m))
+	assert.Equal(t, int64(6), m["counter/app.hits;env=test"].Data[0].Value)
+	assert.Equal(t, 23.0, m["gauge/app.temp;env=test"].Data[0].Value)
+
+	writes := rec.Count()
+	s.Flush(now.Add(2 * time.Second))
+	assert.Equal(t, writes, rec.Count())
+}
+
+func TestIdleFlushes(t *testing.T) {
+	rec := &testutil.Recorder{}
+	s := &Server{Writer: rec, IdleFlushes: 2}
+	now := time.Now()
+
+	s.Add("hits:2|c")
+	s.Add("temp:20|g")
+	s.Flush(now)
+	s.Add("temp:+1|g")
+	s.Flush(now)
+	assert.Equal(t, 1, len(s.counters), "Counter idle for a single flush should be kept")
+
+	s.Flush(now)
+	assert.Equal(t, 0, len(s.counters))
+	assert.Equal(t, 1, len(s.gauges))
+	s.Flush(now)
+	assert.Equal(t, 0, len(s.gauges))
+
+	// The forgotten metrics start over
+	s.Add("hits:1|c")
+	s.Add("temp:+1|g")
+	s.Flush(now)
+	m := last(rec)
+	assert.Equal(t, int64(1), m["counter/hits"].Data[0].Value)
+	assert.Equal(t, 1.0, m["gauge/temp"].Data[0].Value)
+}
+
+func TestServe(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	rec := &testutil.Recorder{}
+	s := &Server{Writer: rec, FlushInterval: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Serve(ctx, pc) }()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte("hits:1|c\nhits:2|c\n"))
+	assert.NoError(t, err)
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.lock.Lock()
+		c := s.counters["hits"]
+		received := c != nil && c.total == 3
+		s.lock.Unlock()
+		if received {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The aggregates are flushed when the server stops
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Equal(t, int64(3), last(rec)["counter/hits"].Data[0].Value)
+}
@@ -0,0 +1,215 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceAccountTokenFile is the location of the Kubernetes / OpenShift service account token inside a pod
+const ServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// tokenExpiryMargin is subtracted from the token lifetime to refresh it before the server rejects it.
+// It is at most a quarter of the lifetime, so that short-lived tokens are still reused.
+const tokenExpiryMargin = time.Duration(30 * time.Second)
+
+// TokenSource provides the bearer tokens for the requests
+type TokenSource interface {
+	// Token returns the current token
+	Token() (string, error)
+	// Refresh discards the current token, it is called after the server has rejected it
+	Refresh() error
+}
+
+type staticTokenSource string
+
+// StaticTokenSource returns a TokenSource which always returns the same token
+func StaticTokenSource(token string) TokenSource {
+	return staticTokenSource(token)
+}
+
+func (s staticTokenSource) Token() (string, error) {
+	return string(s), nil
+}
+
+func (s staticTokenSource) Refresh() error {
+	return nil
+}
+
+// FileTokenSource reads the token from a file and reads it again whenever the file changes
+type FileTokenSource struct {
+	path string
+
+	lock    sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+// NewFileTokenSource returns a TokenSource reading the token from the given path, such as ServiceAccountTokenFile
+func NewFileTokenSource(path string) (*FileTokenSource, error) {
+	s := &FileTokenSource{path: path}
+	if _, err := s.Token(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Token returns the contents of the file, it is read again only if the file has been modified
+func (s *FileTokenSource) Token() (string, error) {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return "", err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.token != "" && fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return s.token, nil
+	}
+	return s.read(fi)
+}
+
+// Refresh reads the file again
+func (s *FileTokenSource) Refresh() error {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.read(fi)
+	return err
+}
+
+func (s *FileTokenSource) read(fi os.FileInfo) (string, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("Token file %s is empty", s.path)
+	}
+	s.token = token
+	s.modTime = fi.ModTime()
+	s.size = fi.Size()
+	return token, nil
+}
+
+// ClientCredentialsTokenSource fetches tokens with the OAuth2 client credentials grant and caches them until they expire
+type ClientCredentialsTokenSource struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	Client       *http.Client // Optional HTTP client for the token requests
+
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Token returns the cached token or fetches a new one if it is about to expire
+func (s *ClientCredentialsTokenSource) Token() (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.token != "" && (s.expires.IsZero() || time.Now().Before(s.expires)) {
+		return s.token, nil
+	}
+	return s.fetch()
+}
+
+// Refresh fetches a new token from the token endpoint
+func (s *ClientCredentialsTokenSource) Refresh() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err := s.fetch()
+	return err
+}
+
+func (s *ClientCredentialsTokenSource) fetch() (string, error) {
+	v := url.Values{}
+	v.Set("grant_type", "client_credentials")
+	if len(s.Scopes) > 0 {
+		v.Set("scope", strings.Join(s.Scopes, " "))
+	}
+
+	req, err := http.NewRequest("POST", s.TokenURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.ClientID), url.QueryEscape(s.ClientSecret))
+
+	c := s.Client
+	if c == nil {
+		c = &http.Client{Timeout: timeout}
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Token endpoint returned status code %d", resp.StatusCode)
+	}
+
+	tr := &oauthTokenResponse{}
+	if err = json.Unmarshal(b, tr); err != nil {
+		return "", err
+	}
+	if tr.AccessToken == "" {
+		return "", fmt.Errorf("Token endpoint did not return an access_token")
+	}
+
+	s.token = tr.AccessToken
+	s.expires = time.Time{}
+	if tr.ExpiresIn > 0 {
+		lifetime := time.Duration(tr.ExpiresIn) * time.Second
+		margin := tokenExpiryMargin
+		if margin > lifetime/4 {
+			margin = lifetime / 4
+		}
+		s.expires = time.Now().Add(lifetime - margin)
+	}
+	return s.token, nil
+}
@@ -0,0 +1,175 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+type rotatingServer struct {
+	lock     sync.Mutex
+	token    string
+	requests int
+}
+
+func (s *rotatingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests++
+	if r.Header.Get("Authorization") != fmt.Sprintf("Bearer %s", s.token) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+}
+
+func (s *rotatingServer) rotate(token string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.token = token
+}
+
+func TestFileTokenSourceRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hawkular-token")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token")
+	assert.NoError(t, ioutil.WriteFile(path, []byte("first\n"), 0600))
+
+	hs := &rotatingServer{token: "first"}
+	s := httptest.NewServer(hs)
+	defer s.Close()
+
+	ts, err := NewFileTokenSource(path)
+	assert.NoError(t, err)
+
+	c, err := NewHawkularClient(Parameters{Tenant: "rotating", Url: s.URL, TokenSource: ts})
+	assert.NoError(t, err)
+
+	r, err := c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+
+	// Rotated with the same size and within the same mtime granularity, detected only after the 401
+	assert.NoError(t, ioutil.WriteFile(path, []byte("secnd\n"), 0600))
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chtimes(path, fi.ModTime(), ts.modTime))
+	hs.rotate("secnd")
+
+	r, err = c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	assert.Equal(t, 3, hs.requests, "Rejected request should have been retried once")
+
+	// Modified files are read again without a failed request
+	assert.NoError(t, ioutil.WriteFile(path, []byte("third-token"), 0600))
+	hs.rotate("third-token")
+	r, err = c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	assert.Equal(t, 4, hs.requests)
+}
+
+func TestClientCredentialsTokenSource(t *testing.T) {
+	issued := 0
+	oauth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "collector" || pass != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		issued++
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":3600}`, issued)
+	}))
+	defer oauth.Close()
+
+	hs := &rotatingServer{token: "token-1"}
+	s := httptest.NewServer(hs)
+	defer s.Close()
+
+	ts := &ClientCredentialsTokenSource{TokenURL: oauth.URL, ClientID: "collector", ClientSecret: "s3cret"}
+	c, err := NewHawkularClient(Parameters{Tenant: "oauth", Url: s.URL, TokenSource: ts})
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		r, err := c.Send(c.URL("GET"))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, r.StatusCode)
+	}
+	assert.Equal(t, 1, issued, "Token should have been cached")
+
+	hs.rotate("token-2")
+	r, err := c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	assert.Equal(t, 2, issued)
+	assert.True(t, ts.expires.After(time.Now()))
+
+	_, err = NewHawkularClient(Parameters{Tenant: "oauth", Url: s.URL, TokenSource: ts, Token: "static"})
+	assert.Error(t, err)
+}
+
+func TestConcurrentTokenRefresh(t *testing.T) {
+	var lock sync.Mutex
+	issued := 0
+	oauth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		issued++
+		// Short-lived tokens are cached for most of their lifetime
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":20}`, issued)
+	}))
+	defer oauth.Close()
+
+	hs := &rotatingServer{token: "token-1"}
+	s := httptest.NewServer(hs)
+	defer s.Close()
+
+	ts := &ClientCredentialsTokenSource{TokenURL: oauth.URL}
+	c, err := NewHawkularClient(Parameters{Tenant: "oauth", Url: s.URL, TokenSource: ts, Concurrency: 4})
+	assert.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Send(c.URL("GET"))
+	assert.NoError(t, err)
+	assert.True(t, ts.expires.After(time.Now().Add(10*time.Second)))
+
+	hs.rotate("token-2")
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := c.Send(c.URL("GET"))
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, r.StatusCode)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, issued, "The rejected token should have been refreshed once")
+}
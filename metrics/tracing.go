@@ -0,0 +1,118 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+// Span attribute keys set by the client
+const (
+	AttributeTenant     = "hawkular.tenant"
+	AttributeEndpoint   = "hawkular.endpoint"
+	AttributeDatapoints = "hawkular.datapoints"
+	AttributeMethod     = "http.method"
+	AttributeStatusCode = "http.status_code"
+)
+
+const traceParentHeader = "traceparent"
+
+// Tracer creates spans for the client requests. Implement it with an adapter to the tracing library in use,
+// the client itself does not depend on any.
+type Tracer interface {
+	// StartSpan starts a new span as a child of the span in the given context
+	StartSpan(ctx context.Context, name string) Span
+}
+
+// Span is a single traced request
+type Span interface {
+	SpanContext() SpanContext
+	SetAttribute(key string, value interface{})
+	// End finishes the span, err is set for requests that did not get a response.
+	// Error status codes are only reported with the AttributeStatusCode.
+	End(err error)
+}
+
+// SpanContext identifies the span for W3C Trace Context propagation
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid returns true if both the trace and span ids are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent returns the value of the W3C traceparent header
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+type traceContextKey struct{}
+
+// TraceContext sets the context which holds the parent span of the request
+func TraceContext(ctx context.Context) Modifier {
+	return func(r *http.Request) error {
+		*r = *r.WithContext(context.WithValue(r.Context(), traceContextKey{}, ctx))
+		return nil
+	}
+}
+
+func (c *Client) startSpan(r *http.Request) Span {
+	parent, ok := r.Context().Value(traceContextKey{}).(context.Context)
+	if !ok {
+		parent = context.Background()
+	}
+
+	cmd := requestCommand(r)
+	name := fmt.Sprintf("hawkular.%s", cmd.name)
+	if cmd.metricType != "" {
+		name = fmt.Sprintf("%s %s", name, cmd.metricType)
+	}
+
+	span := c.tracer.StartSpan(parent, name)
+	span.SetAttribute(AttributeTenant, r.Header.Get(tenantHeader))
+	span.SetAttribute(AttributeMethod, r.Method)
+	if r.URL != nil {
+		span.SetAttribute(AttributeEndpoint, r.URL.Opaque)
+	}
+	if n := requestDatapoints(r); n > 0 {
+		span.SetAttribute(AttributeDatapoints, n)
+	}
+
+	if sc := span.SpanContext(); sc.IsValid() {
+		r.Header.Set(traceParentHeader, sc.TraceParent())
+	}
+	return span
+}
+
+func endSpan(span Span, resp *http.Response, err error) {
+	if resp != nil {
+		span.SetAttribute(AttributeStatusCode, resp.StatusCode)
+	}
+	span.End(err)
+}
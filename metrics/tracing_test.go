@@ -0,0 +1,97 @@
+/*
+   Copyright 2015-2017 Red Hat, Inc. and/or its affiliates
+   and other contributors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+type testSpan struct {
+	name   string
+	parent context.Context
+	attrs  map[string]interface{}
+	ended  bool
+}
+
+func (s *testSpan) SpanContext() SpanContext {
+	return SpanContext{
+		TraceID: [16]byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		Sampled: true,
+	}
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) {
+	s.attrs[key] = value
+}
+
+func (s *testSpan) End(err error) {
+	s.ended = true
+}
+
+type testTracer struct {
+	lock  sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(ctx context.Context, name string) Span {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s := &testSpan{name: name, parent: ctx, attrs: make(map[string]interface{})}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+func TestTracing(t *testing.T) {
+	var traceParent string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get("traceparent")
+	}))
+	defer s.Close()
+
+	tracer := &testTracer{}
+	c, err := NewHawkularClient(Parameters{Tenant: "traced", Url: s.URL, Tracer: tracer})
+	assert.NoError(t, err)
+
+	type parentKey struct{}
+	parent := context.WithValue(context.Background(), parentKey{}, "parent")
+
+	mH := MetricHeader{ID: "traced.gauge", Type: Gauge, Data: []Datapoint{{Value: 1.0, Timestamp: time.Now()}}}
+	err = c.Write([]MetricHeader{mH}, TraceContext(parent))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", traceParent)
+	assert.Equal(t, 1, len(tracer.spans))
+
+	span := tracer.spans[0]
+	assert.Equal(t, "hawkular.Write gauge", span.name)
+	assert.True(t, span.ended)
+	assert.Equal(t, "parent", span.parent.Value(parentKey{}))
+	assert.Equal(t, "traced", span.attrs[AttributeTenant])
+	assert.Equal(t, "POST", span.attrs[AttributeMethod])
+	assert.Equal(t, "/hawkular/metrics/gauges/raw", span.attrs[AttributeEndpoint])
+	assert.Equal(t, 1, span.attrs[AttributeDatapoints])
+	assert.Equal(t, http.StatusOK, span.attrs[AttributeStatusCode])
+}
@@ -18,11 +18,13 @@
 package metrics
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	// "fmt"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 )
 
@@ -32,6 +34,11 @@ type HawkularClientError struct {
 	Code int
 }
 
+// ShutdownError is returned by Close if some of the requests had to be abandoned
+type ShutdownError struct {
+	Abandoned int
+}
+
 // Parameters is a struct used as initialization parameters to the client
 type Parameters struct {
 	Tenant      string // Technically optional, but requires setting Tenant() option every time
@@ -42,17 +49,67 @@ type Parameters struct {
 	Token       string
 	Concurrency int
 	AdminToken  string
+
+	RateLimit       *RateLimit      // Optional client wide rate limits
+	TenantRateLimit *RateLimit      // Optional rate limits applied separately to each tenant
+	RateLimitPolicy RateLimitPolicy // Wait for tokens (default) or reject the request
+
+	CircuitBreaker *CircuitBreaker // Optional fail fast behavior when the server is unavailable
+
+	CloseTimeout time.Duration // Maximum time Close waits for the pending requests, defaults to 30s
+
+	PriorityWeights map[Priority]int // Share of the workers reserved for each priority lane, defaults to DefaultPriorityWeights without reservations
+
+	Tracer Tracer // Optional tracer to create a span for each request
+	Logger Logger // Optional logger for request and error events, such as *slog.Logger
+
+	TokenSource TokenSource // Rotating bearer tokens, replaces Token
+
+	ClientCertFile     string        // PEM encoded client certificate for mutual TLS, requires ClientKeyFile
+	ClientKeyFile      string        // PEM encoded private key of the client certificate
+	CAFile             string        // PEM encoded CA bundle to verify the server with
+	CertReloadInterval time.Duration // How often the files are checked for modifications, defaults to 1 minute
+
+	Timeout      time.Duration // Timeout of a single request, defaults to 30s
+	MaxRetries   int           // Retries of the reads and writes for connection errors and 5xx responses, disabled by default
+	RetryBackoff time.Duration // Delay before the first retry, doubled for each attempt. Defaults to 100ms
+
+	Urls                []string       // Multiple Hawkular-Metrics servers, used instead of Url
+	EndpointPolicy      EndpointPolicy // Failover (default) or round robin between the Urls
+	HealthCheckInterval time.Duration  // How often the unhealthy servers are probed, defaults to 10s
 }
 
 // Client is HawkularClient's internal data structure
 type Client struct {
-	Tenant      string
-	url         *url.URL
-	client      *http.Client
-	Credentials string // base64 encoded username/password for Basic header
-	Token       string // authentication token for Bearer header
-	AdminToken  string // authentication for items behind admin token
-	pool        chan (*poolRequest)
+	Tenant       string
+	url          *url.URL
+	client       *http.Client
+	Credentials  string // base64 encoded username/password for Basic header
+	Token        string // authentication token for Bearer header
+	AdminToken   string // authentication for items behind admin token
+	pool         *requestQueue
+	limiter      *rateLimiter
+	breaker      *circuitBreaker
+	stats        *clientStats
+	tokenSource  TokenSource
+	refreshLock  sync.Mutex // Serializes the token refreshes after rejected requests
+	certificates *certificateReloader
+	tracer       Tracer
+	logger       Logger
+	saturation   int   // Queue depth which is logged as saturated pool
+	saturated    int32 // 1 while the pool is saturated
+
+	ctx          context.Context // Parent of all the request contexts, cancelled when Close gives up waiting
+	cancel       context.CancelFunc
+	done         chan struct{} // Closed by Close to stop the background routines
+	closeLock    sync.RWMutex
+	closed       bool
+	inflight     sync.WaitGroup
+	abandoned    int64
+	closeTimeout time.Duration
+	maxRetries   int
+	retryBackoff time.Duration
+	endpoints    *endpointPool
 }
 
 type poolRequest struct {
@@ -70,6 +127,13 @@ type HawkularClient interface {
 	Send(*http.Request) (*http.Response, error)
 }
 
+// Writer writes datapoints, it is implemented by Client, DefinitionCache, Mirror and Router.
+// The collectors write to a Client, or to a DefinitionCache with EnsureDefinitions to create the
+// definitions with their tags.
+type Writer interface {
+	Write(metrics []MetricHeader, o ...Modifier) error
+}
+
 // Modifier Modifiers base type
 type Modifier func(*http.Request) error
 
@@ -93,10 +157,11 @@ const (
 // MetricHeader is the header struct for time series, which has identifiers (tenant, type, id) for uniqueness
 // and []Datapoint to describe the actual time series values.
 type MetricHeader struct {
-	Tenant string      `json:"-"`
-	Type   MetricType  `json:"-"`
-	ID     string      `json:"id"`
-	Data   []Datapoint `json:"data"`
+	Tenant string            `json:"-"`
+	Type   MetricType        `json:"-"`
+	ID     string            `json:"id"`
+	Data   []Datapoint       `json:"data"`
+	Tags   map[string]string `json:"-"` // Definition tags, used only by DefinitionCache when ensuring definitions
 }
 
 // Datapoint is a struct that represents a single time series value.
@@ -198,6 +263,16 @@ func (b *Bucketpoint) UnmarshalJSON(payload []byte) error {
 	return nil
 }
 
+// MarshalJSON writes the Start and End as milliseconds since epoch, matching UnmarshalJSON
+func (b Bucketpoint) MarshalJSON() ([]byte, error) {
+	bp := bucketpointJSON{
+		bucketpoint: bucketpoint(b),
+		StartTs:     ToUnixMilli(b.Start),
+		EndTs:       ToUnixMilli(b.End),
+	}
+	return json.Marshal(bp)
+}
+
 // Percentile is Hawkular-Metrics' estimated (not exact) percentile
 type Percentile struct {
 	Quantile float64 `json:"quantile"`